@@ -12,6 +12,8 @@ require (
 )
 
 require (
+	github.com/golang-jwt/jwt/v4 v4.4.2
 	github.com/spf13/viper v1.12.0
 	github.com/stretchr/testify v1.8.0
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
 )
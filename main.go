@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files" // swagger embed files
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"net/http"
 	"os"
 	"user-management-service/docs"
 	"user-management-service/src/configuration"
 	"user-management-service/src/controllers"
 	"user-management-service/src/helpers"
 	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
 	"user-management-service/src/services"
 	"user-management-service/src/validators"
 )
@@ -42,22 +45,168 @@ func main() {
 
 	config := configuration.NewConfig()
 
+	if err = config.ValidateSecrets(); err != nil {
+		panic(err)
+	}
+
+	// gin trusts every proxy unless told otherwise, which would let clients
+	// choose their IP through X-Forwarded-For and escape the IP lockout.
+	if err = router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+
 	connection := helpers.New(config.Database.ConnectionString)
 
 	connection.ConnectDb()
 
-	userValidator := validators.NewUserValidator(logger)
+	passwordPolicy, err := validators.NewPasswordPolicy(config.PasswordPolicy)
+
+	if err != nil {
+		panic(err)
+	}
+
+	userValidator := validators.NewUserValidator(passwordPolicy, logger)
+
+	passwordHasher := helpers.NewPasswordHasher(config.PasswordHashing)
+
+	tokenHelper, err := helpers.NewTokenHelper(config.Jwt)
+
+	if err != nil {
+		panic(err)
+	}
+
+	tokenService := services.NewTokenService(tokenHelper, config.Jwt, config.PasswordPolicy, logger)
+
+	notificationSender := helpers.NewNotificationSender(config.Notification)
+
+	emailVerificationService := services.NewEmailVerificationService(userValidator, tokenHelper, notificationSender,
+		config.EmailVerification, logger)
+
+	userService := services.NewUserService(userValidator, passwordHasher, tokenService, emailVerificationService,
+		config.PasswordPolicy, config.Pagination, logger)
+
+	if err = userService.BootstrapAdmin(context.Background(), config.Bootstrap); err != nil {
+		panic(err)
+	}
+
+	if err = userService.EnsureSearchIndex(context.Background()); err != nil {
+		panic(err)
+	}
+
+	if err = helpers.EnsureTokenIndexes(context.Background(), config.MagicLink.Window); err != nil {
+		panic(err)
+	}
+
+	if err = userService.MigrateLegacyPasswords(context.Background()); err != nil {
+		panic(err)
+	}
+
+	go userService.RunPurge(context.Background(), config.Deletion)
+
+	permissionService := services.NewPermissionService(logger)
+
+	secretEncryptor, err := helpers.NewSecretEncryptor(config.TwoFactor.EncryptionKey)
+
+	if err != nil {
+		panic(err)
+	}
+
+	twoFactorService := services.NewTwoFactorService(userValidator, secretEncryptor, config.TwoFactor, logger)
+
+	loginAttemptService := services.NewLoginAttemptService(userValidator, config.Lockout, logger)
+
+	apiKeyService := services.NewApiKeyService(userValidator, config.ApiKeys, logger)
+
+	sessionService := services.NewSessionService(userValidator, tokenService, logger)
+
+	impersonationService := services.NewImpersonationService(userValidator, tokenHelper, config.Impersonation,
+		logger)
+
+	userController := controllers.NewUserController(userService, permissionService, emailVerificationService,
+		twoFactorService, loginAttemptService, apiKeyService, sessionService, impersonationService, logger)
+
+	authValidator := validators.NewAuthValidator(logger)
+
+	authService := services.NewAuthService(authValidator, userService, tokenService, twoFactorService,
+		loginAttemptService, config.EmailVerification, logger)
+
+	passwordResetService := services.NewPasswordResetService(authValidator, userService, notificationSender,
+		config.PasswordReset, logger)
+
+	magicLinkService := services.NewMagicLinkService(authValidator, userService, tokenService, twoFactorService,
+		loginAttemptService, notificationSender, config.MagicLink, logger)
+
+	authController := controllers.NewAuthController(authService, passwordResetService, magicLinkService, logger)
+
+	oauthService := services.NewOAuthService(validators.NewOAuthValidator(logger), userService, tokenService,
+		tokenHelper, config.OAuth, logger)
+
+	oauthController := controllers.NewOAuthController(oauthService, permissionService, logger)
 
-	userService := services.NewUserService(userValidator, logger)
+	scimService := services.NewScimService(userValidator, passwordHasher, userService, tokenService, config.Scim,
+		logger)
 
-	userController := controllers.NewUserController(userService, logger)
+	scimController := controllers.NewScimController(scimService, permissionService, logger)
 
-	user := router.Group("/users")
+	auth := router.Group("/auth")
+	{
+		auth.POST("/login", authController.Login)
+		auth.POST("/refresh", authController.Refresh)
+		auth.POST("/logout", authController.Logout)
+		auth.POST("/password/forgot", authController.ForgotPassword)
+		auth.POST("/password/reset", authController.ResetPassword)
+		auth.POST("/magic-link", authController.RequestMagicLink)
+		auth.POST("/magic-link/verify", authController.MagicLinkLogin)
+	}
+
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, apiKeyService, logger)
+
+	authMiddleware.Public(http.MethodPost, "/users")
+	authMiddleware.Public(http.MethodGet, "/users/verify-email")
+	authMiddleware.Public(http.MethodPost, "/users/verify-email")
+	authMiddleware.Public(http.MethodPost, "/users/verify-email/resend")
+	authMiddleware.Public(http.MethodGet, "/users/email-change/confirm")
+	authMiddleware.Public(http.MethodPost, "/users/email-change/confirm")
+	authMiddleware.Public(http.MethodGet, "/scim/v2/ServiceProviderConfig")
+	authMiddleware.Public(http.MethodGet, "/scim/v2/ResourceTypes")
+	authMiddleware.Public(http.MethodGet, "/scim/v2/ResourceTypes/:id")
+	authMiddleware.Public(http.MethodGet, "/scim/v2/Schemas")
+	authMiddleware.Public(http.MethodGet, "/scim/v2/Schemas/:id")
+	authMiddleware.AllowScope(models.PasswordChangeScope, http.MethodPost, "/users/:id/password")
+
+	authMiddleware.BlockImpersonation(http.MethodPatch, "/users")
+	authMiddleware.BlockImpersonation(http.MethodDelete, "/users/:id")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/password")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/email-change")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/two-factor/enroll")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/two-factor/confirm")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/two-factor/recovery-codes")
+	authMiddleware.BlockImpersonation(http.MethodDelete, "/users/:id/two-factor")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/api-keys")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/impersonate")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users/:id/roles")
+	authMiddleware.BlockImpersonation(http.MethodDelete, "/users/:id/roles/:role")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/scim/v2/Users")
+	authMiddleware.BlockImpersonation(http.MethodPut, "/scim/v2/Users/:id")
+	authMiddleware.BlockImpersonation(http.MethodPatch, "/scim/v2/Users/:id")
+	authMiddleware.BlockImpersonation(http.MethodDelete, "/scim/v2/Users/:id")
+	authMiddleware.BlockImpersonation(http.MethodGet, "/oauth/authorize")
+	authMiddleware.BlockImpersonation(http.MethodPost, "/oauth/clients")
+
+	preconditionMiddleware := middlewares.NewPreconditionMiddleware(config.Concurrency, logger)
+
+	user := router.Group("/users", authMiddleware.Authenticate)
 	{
 		user.POST("", userController.AddUser)
-		user.PATCH("", userController.UpdateUser)
+		user.PATCH("", preconditionMiddleware.RequireIfMatch, userController.UpdateUser)
+
+		user.PATCH("/:id", preconditionMiddleware.RequireIfMatch, func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.PatchUser(context, id)
+		})
 
-		user.DELETE("/:id", func(context *gin.Context) {
+		user.DELETE("/:id", preconditionMiddleware.RequireIfMatch, func(context *gin.Context) {
 			id := context.Param("id")
 
 			userController.DeleteUser(context, id)
@@ -70,6 +219,190 @@ func main() {
 		})
 
 		user.GET("", userController.GetAllUser)
+		user.GET("/search", userController.SearchUsers)
+		user.GET("/deleted", userController.GetDeletedUsers)
+
+		user.POST("/:id/restore", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.RestoreUser(context, id)
+		})
+
+		user.GET("/verify-email", userController.VerifyEmail)
+		user.POST("/verify-email", userController.VerifyEmail)
+		user.POST("/verify-email/resend", userController.ResendVerification)
+
+		user.POST("/:id/email-change", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.RequestEmailChange(context, id)
+		})
+		user.GET("/email-change/confirm", userController.ConfirmEmailChange)
+		user.POST("/email-change/confirm", userController.ConfirmEmailChange)
+
+		user.POST("/:id/roles", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.GrantRole(context, id)
+		})
+
+		user.DELETE("/:id/roles/:role", func(context *gin.Context) {
+			id := context.Param("id")
+			role := context.Param("role")
+
+			userController.RevokeRole(context, id, role)
+		})
+
+		user.POST("/:id/two-factor/enroll", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.EnrollTwoFactor(context, id)
+		})
+
+		user.POST("/:id/two-factor/confirm", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.ConfirmTwoFactor(context, id)
+		})
+
+		user.POST("/:id/two-factor/recovery-codes", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.RegenerateRecoveryCodes(context, id)
+		})
+
+		user.DELETE("/:id/two-factor", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.ResetTwoFactor(context, id)
+		})
+
+		user.POST("/:id/unlock", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.UnlockUser(context, id)
+		})
+
+		user.POST("/:id/password", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.ChangePassword(context, id)
+		})
+
+		user.POST("/:id/password/expire", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.ExpirePassword(context, id)
+		})
+
+		user.POST("/:id/api-keys", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.CreateApiKey(context, id)
+		})
+
+		user.GET("/:id/api-keys", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.ListApiKeys(context, id)
+		})
+
+		user.DELETE("/:id/api-keys/:keyId", func(context *gin.Context) {
+			id := context.Param("id")
+			keyId := context.Param("keyId")
+
+			userController.RevokeApiKey(context, id, keyId)
+		})
+
+		user.GET("/:id/sessions", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.GetSessions(context, id)
+		})
+
+		user.DELETE("/:id/sessions", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.RevokeOtherSessions(context, id)
+		})
+
+		user.DELETE("/:id/sessions/:sessionId", func(context *gin.Context) {
+			id := context.Param("id")
+			sessionId := context.Param("sessionId")
+
+			userController.RevokeSession(context, id, sessionId)
+		})
+
+		user.POST("/:id/impersonate", func(context *gin.Context) {
+			id := context.Param("id")
+
+			userController.Impersonate(context, id)
+		})
+	}
+
+	router.GET("/.well-known/openid-configuration", oauthController.Discovery)
+	router.GET("/oauth/jwks", oauthController.Jwks)
+	router.POST("/oauth/token", oauthController.Token)
+
+	oauth := router.Group("/oauth", authMiddleware.Authenticate)
+	{
+		oauth.GET("/authorize", oauthController.Authorize)
+		oauth.GET("/userinfo", oauthController.UserInfo)
+		oauth.POST("/clients", oauthController.CreateClient)
+		oauth.GET("/clients", oauthController.GetAllClients)
+
+		oauth.DELETE("/clients/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			oauthController.DeleteClient(context, id)
+		})
+	}
+
+	scim := router.Group("/scim/v2", authMiddleware.Authenticate)
+	{
+		scim.GET("/Users", scimController.GetUsers)
+		scim.POST("/Users", scimController.CreateUser)
+
+		scim.GET("/Users/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			scimController.GetUser(context, id)
+		})
+
+		scim.PUT("/Users/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			scimController.ReplaceUser(context, id)
+		})
+
+		scim.PATCH("/Users/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			scimController.PatchUser(context, id)
+		})
+
+		scim.DELETE("/Users/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			scimController.DeleteUser(context, id)
+		})
+
+		scim.GET("/ServiceProviderConfig", scimController.GetServiceProviderConfig)
+		scim.GET("/ResourceTypes", scimController.GetResourceTypes)
+
+		scim.GET("/ResourceTypes/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			scimController.GetResourceType(context, id)
+		})
+
+		scim.GET("/Schemas", scimController.GetSchemas)
+
+		scim.GET("/Schemas/:id", func(context *gin.Context) {
+			id := context.Param("id")
+
+			scimController.GetSchema(context, id)
+		})
 	}
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	router.Run(":8080")
@@ -1,8 +1,10 @@
 package configuration
 
 import (
+	"fmt"
 	"github.com/spf13/viper"
 	"strings"
+	"time"
 )
 
 func NewConfig() *Configurations {
@@ -33,10 +35,203 @@ func NewConfig() *Configurations {
 	return config
 }
 
+// publishedSecrets are values that once shipped in configuration.yaml. Anyone
+// with the source knows them, so they are as good as no secret at all.
+var publishedSecrets = map[string]bool{
+	"change-me-in-production":                      true,
+	"change-me-cursor-secret":                      true,
+	"eWk7IV/1TKaI6uhKAZrW9uvJRFWRyJhqCCSfNrcvaDk=": true,
+}
+
+// ValidateSecrets fails when a signing or encryption secret is missing or
+// still set to a published value. The JWT secret is only required for HS256,
+// the other algorithms sign with the configured key pair.
+func (c *Configurations) ValidateSecrets() error {
+	type secret struct {
+		name  string
+		value string
+	}
+
+	secrets := []secret{
+		{"Two_Factor.Encryption_Key", c.TwoFactor.EncryptionKey},
+		{"Pagination.Cursor_Secret", c.Pagination.CursorSecret},
+	}
+
+	if c.Jwt.Algorithm == "" || strings.EqualFold(c.Jwt.Algorithm, "HS256") {
+		secrets = append(secrets, secret{"Jwt.Secret", c.Jwt.Secret})
+	}
+
+	for _, secret := range secrets {
+		if strings.TrimSpace(secret.value) == "" {
+			return fmt.Errorf("%s is required", secret.name)
+		}
+
+		if publishedSecrets[secret.value] || strings.HasPrefix(strings.ToLower(secret.value), "change-me") {
+			return fmt.Errorf("%s is still set to a published default", secret.name)
+		}
+	}
+
+	return nil
+}
+
 type Configurations struct {
-	Database DatabaseConfigurations
+	Server            ServerConfigurations
+	Database          DatabaseConfigurations
+	PasswordHashing   PasswordHashingConfigurations `mapstructure:"password_hashing"`
+	PasswordPolicy    PasswordPolicyConfigurations  `mapstructure:"password_policy"`
+	Jwt               JwtConfigurations
+	Bootstrap         BootstrapConfigurations
+	Notification      NotificationConfigurations
+	PasswordReset     PasswordResetConfigurations     `mapstructure:"password_reset"`
+	EmailVerification EmailVerificationConfigurations `mapstructure:"email_verification"`
+	TwoFactor         TwoFactorConfigurations         `mapstructure:"two_factor"`
+	Lockout           LockoutConfigurations
+	ApiKeys           ApiKeyConfigurations `mapstructure:"api_keys"`
+	OAuth             OAuthConfigurations
+	Impersonation     ImpersonationConfigurations
+	MagicLink         MagicLinkConfigurations `mapstructure:"magic_link"`
+	Scim              ScimConfigurations
+	Pagination        PaginationConfigurations
+	Deletion          DeletionConfigurations
+	Concurrency       ConcurrencyConfigurations
+}
+
+// ServerConfigurations lists the reverse proxies whose X-Forwarded-For and
+// X-Real-IP headers are believed when taking the client IP, e.g. for the
+// login lockout. With none, the address of the connection is used.
+type ServerConfigurations struct {
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 type DatabaseConfigurations struct {
 	ConnectionString string `mapstructure:"connection_string"`
 }
+
+type PasswordHashingConfigurations struct {
+	Algorithm string
+	Argon2    Argon2Configurations
+	Bcrypt    BcryptConfigurations
+}
+
+type PasswordPolicyConfigurations struct {
+	MinLength            int           `mapstructure:"min_length"`
+	MaxLength            int           `mapstructure:"max_length"`
+	RequireUppercase     bool          `mapstructure:"require_uppercase"`
+	RequireLowercase     bool          `mapstructure:"require_lowercase"`
+	RequireDigit         bool          `mapstructure:"require_digit"`
+	RequireSymbol        bool          `mapstructure:"require_symbol"`
+	DisallowPersonalInfo bool          `mapstructure:"disallow_personal_info"`
+	CommonPasswordFile   string        `mapstructure:"common_password_file"`
+	HistorySize          int           `mapstructure:"history_size"`
+	MaxAge               time.Duration `mapstructure:"max_age"`
+}
+
+type Argon2Configurations struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32 `mapstructure:"salt_length"`
+	KeyLength   uint32 `mapstructure:"key_length"`
+}
+
+type BcryptConfigurations struct {
+	Cost int
+}
+
+type JwtConfigurations struct {
+	Issuer          string
+	Audience        string
+	AccessTokenTtl  time.Duration `mapstructure:"access_token_ttl"`
+	RefreshTokenTtl time.Duration `mapstructure:"refresh_token_ttl"`
+	Algorithm       string
+	Secret          string
+	PrivateKey      string `mapstructure:"private_key"`
+	PrivateKeyFile  string `mapstructure:"private_key_file"`
+	PublicKey       string `mapstructure:"public_key"`
+	PublicKeyFile   string `mapstructure:"public_key_file"`
+}
+
+type BootstrapConfigurations struct {
+	AdminName     string `mapstructure:"admin_name"`
+	AdminEmail    string `mapstructure:"admin_email"`
+	AdminPassword string `mapstructure:"admin_password"`
+}
+
+type NotificationConfigurations struct {
+	Sender   string
+	FilePath string `mapstructure:"file_path"`
+}
+
+type PasswordResetConfigurations struct {
+	TokenTtl time.Duration `mapstructure:"token_ttl"`
+	ResetUrl string        `mapstructure:"reset_url"`
+}
+
+type EmailVerificationConfigurations struct {
+	Required  bool
+	TokenTtl  time.Duration `mapstructure:"token_ttl"`
+	VerifyUrl string        `mapstructure:"verify_url"`
+	ChangeUrl string        `mapstructure:"change_url"`
+}
+
+type TwoFactorConfigurations struct {
+	Issuer            string
+	EncryptionKey     string `mapstructure:"encryption_key"`
+	RecoveryCodeCount int    `mapstructure:"recovery_code_count"`
+}
+
+type LockoutConfigurations struct {
+	AccountThreshold int           `mapstructure:"account_threshold"`
+	IpThreshold      int           `mapstructure:"ip_threshold"`
+	BaseDelay        time.Duration `mapstructure:"base_delay"`
+	MaxDelay         time.Duration `mapstructure:"max_delay"`
+	ResetAfter       time.Duration `mapstructure:"reset_after"`
+}
+
+type ApiKeyConfigurations struct {
+	DefaultTtl time.Duration `mapstructure:"default_ttl"`
+	MaxTtl     time.Duration `mapstructure:"max_ttl"`
+	// AllowCredentialScopes lets keys be scoped to the operations that set
+	// passwords or grant roles, which SCIM provisioning with a key needs.
+	AllowCredentialScopes bool `mapstructure:"allow_credential_scopes"`
+}
+
+type OAuthConfigurations struct {
+	Issuer               string
+	AuthorizationCodeTtl time.Duration `mapstructure:"authorization_code_ttl"`
+}
+
+type ImpersonationConfigurations struct {
+	TokenTtl time.Duration `mapstructure:"token_ttl"`
+}
+
+type MagicLinkConfigurations struct {
+	TokenTtl    time.Duration `mapstructure:"token_ttl"`
+	LoginUrl    string        `mapstructure:"login_url"`
+	MaxRequests int           `mapstructure:"max_requests"`
+	Window      time.Duration
+}
+
+type ScimConfigurations struct {
+	BaseUrl    string `mapstructure:"base_url"`
+	MaxResults int    `mapstructure:"max_results"`
+}
+
+// DeletionConfigurations controls how long soft deleted users can be restored
+// before the purge job removes them for good.
+type DeletionConfigurations struct {
+	RetentionPeriod time.Duration `mapstructure:"retention_period"`
+	PurgeInterval   time.Duration `mapstructure:"purge_interval"`
+}
+
+// ConcurrencyConfigurations controls whether writes to a user must send the
+// ETag it was read with in If-Match.
+type ConcurrencyConfigurations struct {
+	RequireIfMatch bool `mapstructure:"require_if_match"`
+}
+
+type PaginationConfigurations struct {
+	DefaultLimit int    `mapstructure:"default_limit"`
+	MaxLimit     int    `mapstructure:"max_limit"`
+	CursorSecret string `mapstructure:"cursor_secret"`
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+type AuthController struct {
+	authService          services.IAuthService
+	passwordResetService services.IPasswordResetService
+	magicLinkService     services.IMagicLinkService
+	logger               *logrus.Logger
+}
+
+func NewAuthController(authService services.IAuthService, passwordResetService services.IPasswordResetService,
+	magicLinkService services.IMagicLinkService, logger *logrus.Logger) *AuthController {
+	return &AuthController{authService: authService, passwordResetService: passwordResetService,
+		magicLinkService: magicLinkService, logger: logger}
+}
+
+// Login godoc
+// @Summary      Login
+// @description  verifies the credentials and issues an access token
+// @Tags         auth
+// @Success      200     {object}  models.LoginResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      429              {string}  string    "error"
+// @Param        model  body    models.LoginModel  true  "LoginModel"
+// @Router       /auth/login [post]
+func (c *AuthController) Login(context *gin.Context) {
+	var model models.LoginModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.ClientIp = context.ClientIP()
+	model.UserAgent = context.Request.UserAgent()
+
+	result, error := c.authService.Login(context.Request.Context(), model)
+
+	if error != nil {
+		if error.RetryAfter > 0 {
+			context.Header("Retry-After", strconv.Itoa(int(math.Ceil(error.RetryAfter.Seconds()))))
+		}
+		context.JSON(error.StatusCode, error.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, result)
+}
+
+// Refresh godoc
+// @Summary      Refresh
+// @description  rotates the refresh token and issues a new access token
+// @Tags         auth
+// @Success      200     {object}  models.LoginResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Param        model  body    models.RefreshTokenModel  true  "RefreshTokenModel"
+// @Router       /auth/refresh [post]
+func (c *AuthController) Refresh(context *gin.Context) {
+	var model models.RefreshTokenModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.ClientIp = context.ClientIP()
+	model.UserAgent = context.Request.UserAgent()
+
+	result, error := c.authService.Refresh(context.Request.Context(), model)
+
+	if error != nil {
+		context.JSON(error.StatusCode, error.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, result)
+}
+
+// Logout godoc
+// @Summary      Logout
+// @description  revokes the refresh token and every token rotated from the same login
+// @Tags         auth
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Param        model  body    models.LogoutModel  true  "LogoutModel"
+// @Router       /auth/logout [post]
+func (c *AuthController) Logout(context *gin.Context) {
+	var model models.LogoutModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	errorModel := c.authService.Logout(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// ForgotPassword godoc
+// @Summary      ForgotPassword
+// @description  sends a password reset link if an account exists for the email
+// @Tags         auth
+// @Success      202
+// @Failure      400              {string}  string    "error"
+// @Param        model  body    models.ForgotPasswordModel  true  "ForgotPasswordModel"
+// @Router       /auth/password/forgot [post]
+func (c *AuthController) ForgotPassword(context *gin.Context) {
+	var model models.ForgotPasswordModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	errorModel := c.passwordResetService.ForgotPassword(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusAccepted, nil)
+}
+
+// ResetPassword godoc
+// @Summary      ResetPassword
+// @description  sets a new password using a password reset token
+// @Tags         auth
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Param        model  body    models.ResetPasswordModel  true  "ResetPasswordModel"
+// @Router       /auth/password/reset [post]
+func (c *AuthController) ResetPassword(context *gin.Context) {
+	var model models.ResetPasswordModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	errorModel := c.passwordResetService.ResetPassword(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// RequestMagicLink godoc
+// @Summary      RequestMagicLink
+// @description  sends a single-use sign-in link if an account exists for the email
+// @Tags         auth
+// @Success      202
+// @Failure      400              {string}  string    "error"
+// @Failure      429              {string}  string    "error"
+// @Param        model  body    models.MagicLinkModel  true  "MagicLinkModel"
+// @Router       /auth/magic-link [post]
+func (c *AuthController) RequestMagicLink(context *gin.Context) {
+	var model models.MagicLinkModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	errorModel := c.magicLinkService.RequestLink(context.Request.Context(), model)
+
+	if errorModel != nil {
+		if errorModel.RetryAfter > 0 {
+			context.Header("Retry-After", strconv.Itoa(int(math.Ceil(errorModel.RetryAfter.Seconds()))))
+		}
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusAccepted, nil)
+}
+
+// MagicLinkLogin godoc
+// @Summary      MagicLinkLogin
+// @description  exchanges a sign-in link token for an access token and a refresh token
+// @Tags         auth
+// @Success      200     {object}  models.LoginResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Param        model  body    models.MagicLinkLoginModel  true  "MagicLinkLoginModel"
+// @Router       /auth/magic-link/verify [post]
+func (c *AuthController) MagicLinkLogin(context *gin.Context) {
+	var model models.MagicLinkLoginModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.ClientIp = context.ClientIP()
+	model.UserAgent = context.Request.UserAgent()
+
+	result, error := c.magicLinkService.Login(context.Request.Context(), model)
+
+	if error != nil {
+		context.JSON(error.StatusCode, error.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, result)
+}
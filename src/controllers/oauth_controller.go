@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+// OAuthController serves the OpenID Connect provider endpoints. Protocol
+// endpoints answer errors with OAuth error objects; client management
+// answers them like the rest of the API.
+type OAuthController struct {
+	oauthService      services.IOAuthService
+	permissionService services.IPermissionService
+	logger            *logrus.Logger
+}
+
+func NewOAuthController(oauthService services.IOAuthService, permissionService services.IPermissionService,
+	logger *logrus.Logger) *OAuthController {
+	return &OAuthController{oauthService: oauthService, permissionService: permissionService, logger: logger}
+}
+
+// Discovery godoc
+// @Summary      Discovery
+// @description  OpenID Connect discovery document
+// @Tags         oauth
+// @Success      200     {object}  models.OpenIdConfigurationModel
+// @Router       /.well-known/openid-configuration [get]
+func (c *OAuthController) Discovery(context *gin.Context) {
+	context.JSON(http.StatusOK, c.oauthService.Discovery())
+}
+
+// Jwks godoc
+// @Summary      Jwks
+// @description  public keys that verify ID tokens; empty when tokens are signed with a shared secret
+// @Tags         oauth
+// @Success      200     {object}  models.JwksModel
+// @Router       /oauth/jwks [get]
+func (c *OAuthController) Jwks(context *gin.Context) {
+	context.JSON(http.StatusOK, c.oauthService.Jwks())
+}
+
+// Authorize godoc
+// @Summary      Authorize
+// @description  issues an authorization code for the authenticated user and redirects to the client; PKCE with S256 is required
+// @Tags         oauth
+// @Success      302
+// @Failure      400              {object}  models.OAuthErrorResponseModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.OAuthErrorResponseModel
+// @Param        response_type          query  string  true   "code"
+// @Param        client_id              query  string  true   "client_id"
+// @Param        redirect_uri           query  string  false  "redirect_uri"
+// @Param        scope                  query  string  false  "scope"
+// @Param        state                  query  string  false  "state"
+// @Param        nonce                  query  string  false  "nonce"
+// @Param        code_challenge         query  string  true   "code_challenge"
+// @Param        code_challenge_method  query  string  true   "S256"
+// @Router       /oauth/authorize [get]
+func (c *OAuthController) Authorize(context *gin.Context) {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	if principal.ApiKeyId != "" {
+		context.JSON(http.StatusForbidden, models.OAuthErrorResponseModel{Error: models.OAuthAccessDeniedError})
+		return
+	}
+
+	var model models.AuthorizeModel
+	err := context.ShouldBindQuery(&model)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, models.OAuthErrorResponseModel{Error: models.OAuthInvalidRequestError})
+		return
+	}
+	model.UserId = principal.UserId
+	model.ClientIp = context.ClientIP()
+	model.UserAgent = context.Request.UserAgent()
+
+	response, errorModel := c.oauthService.Authorize(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, models.OAuthErrorResponseModel{Error: errorModel.Error})
+		return
+	}
+
+	context.Redirect(http.StatusFound, response.RedirectUrl)
+}
+
+// Token godoc
+// @Summary      Token
+// @description  redeems an authorization code or a refresh token; clients authenticate with HTTP Basic or form parameters
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Success      200     {object}  models.OAuthTokenResponseModel
+// @Failure      400              {object}  models.OAuthErrorResponseModel
+// @Failure      401              {object}  models.OAuthErrorResponseModel
+// @Param        grant_type     formData  string  true   "authorization_code or refresh_token"
+// @Param        code           formData  string  false  "code"
+// @Param        redirect_uri   formData  string  false  "redirect_uri"
+// @Param        code_verifier  formData  string  false  "code_verifier"
+// @Param        refresh_token  formData  string  false  "refresh_token"
+// @Param        client_id      formData  string  false  "client_id"
+// @Param        client_secret  formData  string  false  "client_secret"
+// @Router       /oauth/token [post]
+func (c *OAuthController) Token(context *gin.Context) {
+	context.Header("Cache-Control", "no-store")
+	context.Header("Pragma", "no-cache")
+
+	var model models.OAuthTokenModel
+	err := context.ShouldBind(&model)
+
+	if err != nil {
+		context.JSON(http.StatusBadRequest, models.OAuthErrorResponseModel{Error: models.OAuthInvalidRequestError})
+		return
+	}
+
+	clientId, clientSecret, basic := context.Request.BasicAuth()
+
+	if basic {
+		model.ClientId, _ = url.QueryUnescape(clientId)
+		model.ClientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+	model.ClientIp = context.ClientIP()
+	model.UserAgent = context.Request.UserAgent()
+
+	response, errorModel := c.oauthService.Token(context.Request.Context(), model)
+
+	if errorModel != nil {
+		if errorModel.StatusCode == http.StatusUnauthorized && basic {
+			context.Header("WWW-Authenticate", "Basic")
+		}
+		context.JSON(errorModel.StatusCode, models.OAuthErrorResponseModel{Error: errorModel.Error})
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// UserInfo godoc
+// @Summary      UserInfo
+// @description  claims about the user the access token was issued to
+// @Tags         oauth
+// @Success      200     {object}  models.UserInfoResponseModel
+// @Failure      401              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Router       /oauth/userinfo [get]
+func (c *OAuthController) UserInfo(context *gin.Context) {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	response, errorModel := c.oauthService.UserInfo(context.Request.Context(),
+		models.UserInfoModel{UserId: principal.UserId})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// CreateClient godoc
+// @Summary      CreateClient
+// @description  registers an OAuth client; the secret of confidential clients is only returned by this call
+// @Tags         oauth
+// @Success      200     {object}  models.OAuthClientResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        model  body    models.CreateOAuthClientModel  true  "CreateOAuthClientModel"
+// @Router       /oauth/clients [post]
+func (c *OAuthController) CreateClient(context *gin.Context) {
+	if !c.authorize(context) {
+		return
+	}
+
+	var model models.CreateOAuthClientModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	response, errorModel := c.oauthService.CreateClient(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// GetAllClients godoc
+// @Summary      GetAllClients
+// @description  lists the registered OAuth clients
+// @Tags         oauth
+// @Success      200     {object}  []models.OAuthClientResponseModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Router       /oauth/clients [get]
+func (c *OAuthController) GetAllClients(context *gin.Context) {
+	if !c.authorize(context) {
+		return
+	}
+
+	response, errorModel := c.oauthService.GetAllClients(context.Request.Context())
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// DeleteClient godoc
+// @Summary      DeleteClient
+// @description  removes an OAuth client
+// @Tags         oauth
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /oauth/clients/{id} [delete]
+func (c *OAuthController) DeleteClient(context *gin.Context, id string) {
+	if !c.authorize(context) {
+		return
+	}
+
+	errorModel := c.oauthService.DeleteClient(context.Request.Context(), models.OAuthClientModel{Id: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+func (c *OAuthController) authorize(context *gin.Context) bool {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	errorModel := c.permissionService.Authorize(principal, models.ManageOAuthClientsPermission, "")
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return false
+	}
+
+	return true
+}
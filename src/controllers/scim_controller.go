@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+// ScimController serves the SCIM 2.0 endpoints for identity providers.
+// Responses use the SCIM media type and errors are SCIM error objects.
+type ScimController struct {
+	scimService       services.IScimService
+	permissionService services.IPermissionService
+	logger            *logrus.Logger
+}
+
+func NewScimController(scimService services.IScimService, permissionService services.IPermissionService,
+	logger *logrus.Logger) *ScimController {
+	return &ScimController{scimService: scimService, permissionService: permissionService, logger: logger}
+}
+
+// GetUsers godoc
+// @Summary      GetUsers
+// @description  lists provisioned users matching a SCIM filter, one page at a time
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimListResponseModel
+// @Failure      400              {object}  models.ScimErrorModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.ScimErrorModel
+// @Param        filter      query  string  false  "filter, e.g. userName eq \"a@b.com\""
+// @Param        startIndex  query  int     false  "1-based index of the first result"
+// @Param        count       query  int     false  "maximum number of results"
+// @Router       /scim/v2/Users [get]
+func (c *ScimController) GetUsers(context *gin.Context) {
+	if !c.authorize(context) {
+		return
+	}
+
+	var model models.ScimListModel
+	err := context.ShouldBindQuery(&model)
+
+	if err != nil {
+		c.respondError(context, http.StatusBadRequest, models.ScimInvalidValueError, models.BadRequestErrorMessage)
+		return
+	}
+
+	response, errorModel := c.scimService.GetUsers(context.Request.Context(), model)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	c.respond(context, http.StatusOK, response)
+}
+
+// GetUser godoc
+// @Summary      GetUser
+// @description  returns a provisioned user
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimUserModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.ScimErrorModel
+// @Failure      404              {object}  models.ScimErrorModel
+// @Param        id   path      string  true  "id"
+// @Router       /scim/v2/Users/{id} [get]
+func (c *ScimController) GetUser(context *gin.Context, id string) {
+	if !c.authorize(context) {
+		return
+	}
+
+	response, errorModel := c.scimService.GetUser(context.Request.Context(), id)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	c.respond(context, http.StatusOK, response)
+}
+
+// CreateUser godoc
+// @Summary      CreateUser
+// @description  provisions a user; userName is the user's email
+// @Tags         scim
+// @Accept       json
+// @Produce      json
+// @Success      201     {object}  models.ScimUserModel
+// @Failure      400              {object}  models.ScimErrorModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.ScimErrorModel
+// @Failure      409              {object}  models.ScimErrorModel
+// @Param        model  body    models.ScimUserModel  true  "ScimUserModel"
+// @Router       /scim/v2/Users [post]
+func (c *ScimController) CreateUser(context *gin.Context) {
+	if !c.authorize(context) {
+		return
+	}
+
+	var model models.ScimUserModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		c.respondError(context, http.StatusBadRequest, models.ScimInvalidSyntaxError, models.BadRequestErrorMessage)
+		return
+	}
+
+	response, errorModel := c.scimService.CreateUser(context.Request.Context(), model)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	context.Header("Location", response.Meta.Location)
+	c.respond(context, http.StatusCreated, response)
+}
+
+// ReplaceUser godoc
+// @Summary      ReplaceUser
+// @description  replaces the attributes of a provisioned user; active false deactivates the user
+// @Tags         scim
+// @Accept       json
+// @Produce      json
+// @Success      200     {object}  models.ScimUserModel
+// @Failure      400              {object}  models.ScimErrorModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.ScimErrorModel
+// @Failure      404              {object}  models.ScimErrorModel
+// @Failure      409              {object}  models.ScimErrorModel
+// @Param        id     path    string                true  "id"
+// @Param        model  body    models.ScimUserModel  true  "ScimUserModel"
+// @Router       /scim/v2/Users/{id} [put]
+func (c *ScimController) ReplaceUser(context *gin.Context, id string) {
+	if !c.authorize(context) {
+		return
+	}
+
+	var model models.ScimUserModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		c.respondError(context, http.StatusBadRequest, models.ScimInvalidSyntaxError, models.BadRequestErrorMessage)
+		return
+	}
+
+	response, errorModel := c.scimService.ReplaceUser(context.Request.Context(), id, model)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	c.respond(context, http.StatusOK, response)
+}
+
+// PatchUser godoc
+// @Summary      PatchUser
+// @description  applies add, replace and remove operations to a provisioned user
+// @Tags         scim
+// @Accept       json
+// @Produce      json
+// @Success      200     {object}  models.ScimUserModel
+// @Failure      400              {object}  models.ScimErrorModel
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.ScimErrorModel
+// @Failure      404              {object}  models.ScimErrorModel
+// @Failure      409              {object}  models.ScimErrorModel
+// @Param        id     path    string                 true  "id"
+// @Param        model  body    models.ScimPatchModel  true  "ScimPatchModel"
+// @Router       /scim/v2/Users/{id} [patch]
+func (c *ScimController) PatchUser(context *gin.Context, id string) {
+	if !c.authorize(context) {
+		return
+	}
+
+	var model models.ScimPatchModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		c.respondError(context, http.StatusBadRequest, models.ScimInvalidSyntaxError, models.BadRequestErrorMessage)
+		return
+	}
+
+	response, errorModel := c.scimService.PatchUser(context.Request.Context(), id, model)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	c.respond(context, http.StatusOK, response)
+}
+
+// DeleteUser godoc
+// @Summary      DeleteUser
+// @description  deprovisions a user
+// @Tags         scim
+// @Success      204
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {object}  models.ScimErrorModel
+// @Failure      404              {object}  models.ScimErrorModel
+// @Param        id   path      string  true  "id"
+// @Router       /scim/v2/Users/{id} [delete]
+func (c *ScimController) DeleteUser(context *gin.Context, id string) {
+	if !c.authorize(context) {
+		return
+	}
+
+	errorModel := c.scimService.DeleteUser(context.Request.Context(), id)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+// GetServiceProviderConfig godoc
+// @Summary      GetServiceProviderConfig
+// @description  SCIM features supported by the service
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimServiceProviderConfigModel
+// @Router       /scim/v2/ServiceProviderConfig [get]
+func (c *ScimController) GetServiceProviderConfig(context *gin.Context) {
+	c.respond(context, http.StatusOK, c.scimService.GetServiceProviderConfig())
+}
+
+// GetResourceTypes godoc
+// @Summary      GetResourceTypes
+// @description  SCIM resource types served; only User
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimListResponseModel
+// @Router       /scim/v2/ResourceTypes [get]
+func (c *ScimController) GetResourceTypes(context *gin.Context) {
+	c.respond(context, http.StatusOK, c.scimService.GetResourceTypes())
+}
+
+// GetResourceType godoc
+// @Summary      GetResourceType
+// @description  a SCIM resource type by name
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimResourceTypeModel
+// @Failure      404              {object}  models.ScimErrorModel
+// @Param        id   path      string  true  "id"
+// @Router       /scim/v2/ResourceTypes/{id} [get]
+func (c *ScimController) GetResourceType(context *gin.Context, id string) {
+	response, errorModel := c.scimService.GetResourceType(id)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	c.respond(context, http.StatusOK, response)
+}
+
+// GetSchemas godoc
+// @Summary      GetSchemas
+// @description  SCIM schemas served; only the core User schema
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimListResponseModel
+// @Router       /scim/v2/Schemas [get]
+func (c *ScimController) GetSchemas(context *gin.Context) {
+	c.respond(context, http.StatusOK, c.scimService.GetSchemas())
+}
+
+// GetSchema godoc
+// @Summary      GetSchema
+// @description  a SCIM schema by URN
+// @Tags         scim
+// @Produce      json
+// @Success      200     {object}  models.ScimSchemaModel
+// @Failure      404              {object}  models.ScimErrorModel
+// @Param        id   path      string  true  "id"
+// @Router       /scim/v2/Schemas/{id} [get]
+func (c *ScimController) GetSchema(context *gin.Context, id string) {
+	response, errorModel := c.scimService.GetSchema(id)
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return
+	}
+
+	c.respond(context, http.StatusOK, response)
+}
+
+func (c *ScimController) authorize(context *gin.Context) bool {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	errorModel := c.permissionService.Authorize(principal, models.ProvisionUsersPermission, "")
+
+	if errorModel != nil {
+		c.respondErrorModel(context, errorModel)
+		return false
+	}
+
+	return true
+}
+
+func (c *ScimController) respond(context *gin.Context, status int, response interface{}) {
+	body, err := json.Marshal(response)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "ScimController").
+			WithField("Operation", "Marshal").
+			WithField("Error", err.Error()).
+			Error("")
+		c.respondError(context, http.StatusInternalServerError, "", models.InternalErrorMessage)
+		return
+	}
+
+	context.Data(status, models.ScimContentType, body)
+}
+
+// respondErrorModel turns a service error into a SCIM error, naming the
+// scimType for the bad request and conflict cases RFC 7644 defines.
+func (c *ScimController) respondErrorModel(context *gin.Context, errorModel *models.ErrorModel) {
+	scimType := ""
+
+	switch {
+	case errorModel.Error == models.InvalidScimFilterMessage:
+		scimType = models.ScimInvalidFilterError
+	case errorModel.Error == models.InvalidScimPatchMessage:
+		scimType = models.ScimInvalidPathError
+	case errorModel.StatusCode == http.StatusConflict:
+		scimType = models.ScimUniquenessError
+	case errorModel.StatusCode == http.StatusBadRequest:
+		scimType = models.ScimInvalidValueError
+	}
+
+	c.respondError(context, errorModel.StatusCode, scimType, errorModel.Error)
+}
+
+func (c *ScimController) respondError(context *gin.Context, status int, scimType string, detail string) {
+	body, _ := json.Marshal(models.ScimErrorModel{
+		Schemas:  []string{models.ScimErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+
+	context.Data(status, models.ScimContentType, body)
+}
@@ -3,18 +3,90 @@ package controllers
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
+	"user-management-service/src/helpers"
+	"user-management-service/src/middlewares"
 	"user-management-service/src/models"
 	"user-management-service/src/services"
 )
 
 type UserController struct {
-	userService services.IUserService
-	logger      *logrus.Logger
+	userService              services.IUserService
+	permissionService        services.IPermissionService
+	emailVerificationService services.IEmailVerificationService
+	twoFactorService         services.ITwoFactorService
+	loginAttemptService      services.ILoginAttemptService
+	apiKeyService            services.IApiKeyService
+	sessionService           services.ISessionService
+	impersonationService     services.IImpersonationService
+	logger                   *logrus.Logger
 }
 
-func NewUserController(userService services.IUserService, logger *logrus.Logger) *UserController {
-	return &UserController{userService: userService, logger: logger}
+func NewUserController(userService services.IUserService, permissionService services.IPermissionService,
+	emailVerificationService services.IEmailVerificationService, twoFactorService services.ITwoFactorService,
+	loginAttemptService services.ILoginAttemptService, apiKeyService services.IApiKeyService,
+	sessionService services.ISessionService, impersonationService services.IImpersonationService,
+	logger *logrus.Logger) *UserController {
+	return &UserController{userService: userService, permissionService: permissionService,
+		emailVerificationService: emailVerificationService, twoFactorService: twoFactorService,
+		loginAttemptService: loginAttemptService, apiKeyService: apiKeyService, sessionService: sessionService,
+		impersonationService: impersonationService, logger: logger}
+}
+
+// authorize writes the error response and returns false when the
+// authenticated principal may not perform the operation.
+func (c *UserController) authorize(context *gin.Context, permission string, ownerId string) bool {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	errorModel := c.permissionService.Authorize(principal, permission, ownerId)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return false
+	}
+
+	return true
+}
+
+// authorizeOwner only lets users act on their own account, for operations
+// such as two-factor enrollment that no permission delegates. API keys are
+// never accepted for these.
+func (c *UserController) authorizeOwner(context *gin.Context, id string) bool {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	if principal.UserId == "" || principal.UserId != id || principal.ApiKeyId != "" {
+		context.JSON(http.StatusForbidden, models.ForbiddenErrorMessage)
+		return false
+	}
+
+	return true
+}
+
+// authorizeApiKeys lets the owner or holders of ManageApiKeysPermission
+// manage a user's API keys, but never through an API key.
+func (c *UserController) authorizeApiKeys(context *gin.Context, id string) bool {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	if principal.ApiKeyId != "" {
+		context.JSON(http.StatusForbidden, models.ForbiddenErrorMessage)
+		return false
+	}
+
+	return c.authorize(context, models.ManageApiKeysPermission, id)
+}
+
+// ifMatch returns the user version required by the If-Match header, or
+// writes the error response and returns false when no version can match it.
+func (c *UserController) ifMatch(context *gin.Context) (*int64, bool) {
+	version, err := helpers.ParseIfMatch(context.GetHeader("If-Match"))
+
+	if err != nil {
+		context.JSON(http.StatusPreconditionFailed, models.PreconditionFailedMessage)
+		return nil, false
+	}
+
+	return version, true
 }
 
 // AddUser godoc
@@ -50,10 +122,16 @@ func (c *UserController) AddUser(context *gin.Context) {
 
 // UpdateUser godoc
 // @Summary      UpdateUser
-// @description  updates the user
+// @description  updates the user; with If-Match only while the user is still at the version of that ETag
 // @Tags         user
 // @Success      200     {object}  models.UpdateUserResponseModel
+// @Header       200     {string}  ETag  "version of the updated user"
 // @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      412              {string}  string    "error"
+// @Failure      428              {string}  string    "error"
+// @Param        If-Match  header  string  false  "ETag the user was read with"
 // @Param        model  body    models.UpdateUserModel  true  "UpdateUserModel"
 // @Router       /users [patch]
 func (c *UserController) UpdateUser(context *gin.Context) {
@@ -69,6 +147,19 @@ func (c *UserController) UpdateUser(context *gin.Context) {
 		context.JSON(errorModel.StatusCode, errorModel.Error)
 		return
 	}
+
+	if !c.authorize(context, models.UpdateUsersPermission, model.Id) {
+		return
+	}
+
+	version, ok := c.ifMatch(context)
+
+	if !ok {
+		return
+	}
+
+	model.Version = version
+
 	result, error := c.userService.UpdateUser(context.Request.Context(), model)
 
 	if error != nil {
@@ -76,19 +167,106 @@ func (c *UserController) UpdateUser(context *gin.Context) {
 		return
 	}
 
+	context.Header("ETag", helpers.UserETag(result.Version))
+	context.JSON(http.StatusOK, result)
+}
+
+// PatchUser godoc
+// @Summary      PatchUser
+// @description  updates only the given fields of the user, from a JSON Merge Patch (application/merge-patch+json or
+// @description  application/json) or JSON Patch operations (application/json-patch+json); with If-Match only while
+// @description  the user is still at the version of that ETag
+// @Tags         user
+// @Accept       json
+// @Accept       application/merge-patch+json
+// @Accept       application/json-patch+json
+// @Success      200     {object}  models.UpdateUserResponseModel
+// @Header       200     {string}  ETag  "version of the updated user"
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Failure      409              {string}  string    "error"
+// @Failure      412              {string}  string    "error"
+// @Failure      415              {string}  string    "error"
+// @Failure      428              {string}  string    "error"
+// @Param        id     path    string  true  "id"
+// @Param        If-Match  header  string  false  "ETag the user was read with"
+// @Param        patch  body    object  true  "merge patch document or JSON Patch operations"
+// @Router       /users/{id} [patch]
+func (c *UserController) PatchUser(context *gin.Context, id string) {
+	if !c.authorize(context, models.UpdateUsersPermission, id) {
+		return
+	}
+
+	version, ok := c.ifMatch(context)
+
+	if !ok {
+		return
+	}
+
+	model := models.PatchUserModel{Id: id, Version: version}
+	var err error
+
+	switch context.ContentType() {
+	case models.JsonPatchContentType:
+		err = context.ShouldBindJSON(&model.Operations)
+	case models.MergePatchContentType, gin.MIMEJSON:
+		err = context.ShouldBindJSON(&model.MergePatch)
+	default:
+		context.JSON(http.StatusUnsupportedMediaType, models.UnsupportedMediaTypeMessage)
+		return
+	}
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	result, errorModel := c.userService.PatchUser(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.Header("ETag", helpers.UserETag(result.Version))
 	context.JSON(http.StatusOK, result)
 }
 
 // DeleteUser godoc
 // @Summary      DeleteUser
-// @description  deletes the user
+// @description  deletes the user; an admin can restore it until it is purged after the retention period. With
+// @description  If-Match the user is only deleted while still at the version of that ETag
 // @Tags         user
 // @Success      200     {object}  models.AddUserResponseModel
 // @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Failure      409              {string}  string    "error"
+// @Failure      412              {string}  string    "error"
+// @Failure      428              {string}  string    "error"
 // @Param        id   path      string  true  "id"
+// @Param        If-Match  header  string  false  "ETag the user was read with"
 // @Router       /users/{id} [delete]
 func (c *UserController) DeleteUser(context *gin.Context, id string) {
-	model := models.DeleteUserModel{Id: id}
+	if !c.authorize(context, models.DeleteUsersPermission, id) {
+		return
+	}
+
+	version, ok := c.ifMatch(context)
+
+	if !ok {
+		return
+	}
+
+	model := models.DeleteUserModel{Id: id, Version: version}
 
 	errorModel := c.userService.DeleteUser(context.Request.Context(), model)
 
@@ -100,15 +278,91 @@ func (c *UserController) DeleteUser(context *gin.Context, id string) {
 	context.JSON(http.StatusOK, nil)
 }
 
+// RestoreUser godoc
+// @Summary      RestoreUser
+// @description  restores a deleted user that has not been purged yet
+// @Tags         user
+// @Success      200     {object}  models.GetUserResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/restore [post]
+func (c *UserController) RestoreUser(context *gin.Context, id string) {
+	if !c.authorize(context, models.RestoreUsersPermission, "") {
+		return
+	}
+
+	response, errorModel := c.userService.RestoreUser(context.Request.Context(), models.RestoreUserModel{Id: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// GetDeletedUsers godoc
+// @Summary      GetDeletedUsers
+// @description  lists deleted users that can still be restored, most recently deleted first
+// @Tags         user
+// @Success      200     {object}  models.UserListResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        page   query  int  false  "1-based page number"
+// @Param        limit  query  int  false  "users per page, capped by the server"
+// @Router       /users/deleted [get]
+func (c *UserController) GetDeletedUsers(context *gin.Context) {
+	if !c.authorize(context, models.RestoreUsersPermission, "") {
+		return
+	}
+
+	var model models.ListDeletedUsersModel
+	err := context.ShouldBindQuery(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	response, errorModel := c.userService.ListDeletedUsers(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	response.Links = helpers.PageLinks(context.Request.URL, response.Page, response.Limit, *response.Total)
+
+	context.JSON(http.StatusOK, response)
+}
+
 // GetUser godoc
 // @Summary      GetUser
-// @description  retrieves the user
+// @description  retrieves the user with its version as the ETag; with If-None-Match nothing is sent back while the
+// @description  user is still at the version of that ETag
 // @Tags         user
 // @Success      200     {object}  models.GetUserResponseModel
+// @Header       200     {string}  ETag  "version of the user"
+// @Success      304
 // @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
 // @Param        id   path      string  true  "id"
+// @Param        If-None-Match  header  string  false  "ETag of the copy the client has"
 // @Router       /users/{id} [get]
 func (c *UserController) GetUser(context *gin.Context, id string) {
+	if !c.authorize(context, models.ReadUsersPermission, id) {
+		return
+	}
 
 	model := models.GetUserModel{Id: id}
 
@@ -119,18 +373,795 @@ func (c *UserController) GetUser(context *gin.Context, id string) {
 		return
 	}
 
+	// The password expiry is only shown to the account owner.
+	if principal, _ := middlewares.GetPrincipal(context); principal.UserId != id {
+		response.PasswordExpiresAt = nil
+	}
+
+	etag := helpers.UserETag(response.Version)
+	context.Header("ETag", etag)
+
+	if helpers.ETagMatches(context.GetHeader("If-None-Match"), etag) {
+		context.Status(http.StatusNotModified)
+		return
+	}
+
 	context.JSON(http.StatusOK, response)
 }
 
 // GetAllUser godoc
 // @Summary      GetAllUser
-// @description  retrieves the user
+// @description  lists users one page at a time, either by page number with the total count or by cursor with
+// @description  cursors to the next and previous pages, and links to the other pages
 // @Tags         user
-// @Success      200     {object}  []models.GetUserResponseModel
+// @Success      200     {object}  models.UserListResponseModel
 // @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        page         query  int     false  "1-based page number"
+// @Param        limit        query  int     false  "users per page, capped by the server"
+// @Param        sort         query  string  false  "name, email or createdAt; prefix with - for descending order"
+// @Param        name         query  string  false  "name prefix"
+// @Param        emailDomain  query  string  false  "email domain, e.g. gmail.com"
+// @Param        status       query  string  false  "active, disabled or unverified"
+// @Param        pagination   query  string  false  "offset (default) or cursor"
+// @Param        cursor       query  string  false  "nextCursor or prevCursor of the previous response"
 // @Router       /users [get]
 func (c *UserController) GetAllUser(context *gin.Context) {
-	response, errorModel := c.userService.GetAllUsers(context)
+	if !c.authorize(context, models.ListUsersPermission, "") {
+		return
+	}
+
+	var model models.ListUsersModel
+	err := context.ShouldBindQuery(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	response, errorModel := c.userService.GetAllUsers(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	if response.Total != nil {
+		response.Links = helpers.PageLinks(context.Request.URL, response.Page, response.Limit, *response.Total)
+	} else {
+		response.Links = helpers.CursorLinks(context.Request.URL, response.Limit, response.PrevCursor,
+			response.NextCursor)
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// SearchUsers godoc
+// @Summary      SearchUsers
+// @description  searches users by name or email, most relevant first, with the matched fields highlighted
+// @Tags         user
+// @Success      200     {object}  models.UserSearchResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        q      query  string  true   "words, or the start of a name or email"
+// @Param        page   query  int     false  "1-based page number"
+// @Param        limit  query  int     false  "users per page, capped by the server"
+// @Router       /users/search [get]
+func (c *UserController) SearchUsers(context *gin.Context) {
+	if !c.authorize(context, models.ListUsersPermission, "") {
+		return
+	}
+
+	var model models.SearchUsersModel
+	err := context.ShouldBindQuery(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	response, errorModel := c.userService.SearchUsers(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	response.Links = helpers.PageLinks(context.Request.URL, response.Page, response.Limit, response.Total)
+
+	context.JSON(http.StatusOK, response)
+}
+
+// GrantRole godoc
+// @Summary      GrantRole
+// @description  grants a role to the user
+// @Tags         user
+// @Success      200     {object}  models.GetUserResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        model  body    models.UserRoleModel  true  "UserRoleModel"
+// @Router       /users/{id}/roles [post]
+func (c *UserController) GrantRole(context *gin.Context, id string) {
+	if !c.authorize(context, models.ManageRolesPermission, "") {
+		return
+	}
+
+	var model models.UserRoleModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.Id = id
+
+	response, errorModel := c.userService.GrantRole(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// RevokeRole godoc
+// @Summary      RevokeRole
+// @description  revokes a role from the user
+// @Tags         user
+// @Success      200     {object}  models.GetUserResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Failure      409              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        role   path      string  true  "role"
+// @Router       /users/{id}/roles/{role} [delete]
+func (c *UserController) RevokeRole(context *gin.Context, id string, role string) {
+	if !c.authorize(context, models.ManageRolesPermission, "") {
+		return
+	}
+
+	model := models.UserRoleModel{Id: id, Role: role}
+
+	response, errorModel := c.userService.RevokeRole(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// VerifyEmail godoc
+// @Summary      VerifyEmail
+// @description  confirms the email address with the token from the verification link
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Param        token   query      string  false  "token"
+// @Param        model  body    models.VerifyEmailModel  false  "VerifyEmailModel"
+// @Router       /users/verify-email [get]
+// @Router       /users/verify-email [post]
+func (c *UserController) VerifyEmail(context *gin.Context) {
+	var model models.VerifyEmailModel
+	var err error
+
+	if context.Request.Method == http.MethodGet {
+		err = context.ShouldBindQuery(&model)
+	} else {
+		err = context.ShouldBindJSON(&model)
+	}
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	errorModel := c.emailVerificationService.VerifyEmail(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// RequestEmailChange godoc
+// @Summary      RequestEmailChange
+// @description  sends a confirmation link to the new email address; the current address stays in use until it is
+// @description  confirmed and is told about the request
+// @Tags         user
+// @Success      202
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id     path    string                   true  "id"
+// @Param        model  body    models.EmailChangeModel  true  "EmailChangeModel"
+// @Router       /users/{id}/email-change [post]
+func (c *UserController) RequestEmailChange(context *gin.Context, id string) {
+	if !c.authorize(context, models.UpdateUsersPermission, id) {
+		return
+	}
+
+	var model models.EmailChangeModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	model.Id = id
+
+	errorModel := c.emailVerificationService.RequestEmailChange(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusAccepted, nil)
+}
+
+// ConfirmEmailChange godoc
+// @Summary      ConfirmEmailChange
+// @description  makes the new email address the user's email with the token from the confirmation link
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        token   query      string  false  "token"
+// @Param        model  body    models.VerifyEmailModel  false  "VerifyEmailModel"
+// @Router       /users/email-change/confirm [get]
+// @Router       /users/email-change/confirm [post]
+func (c *UserController) ConfirmEmailChange(context *gin.Context) {
+	var model models.VerifyEmailModel
+	var err error
+
+	if context.Request.Method == http.MethodGet {
+		err = context.ShouldBindQuery(&model)
+	} else {
+		err = context.ShouldBindJSON(&model)
+	}
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	errorModel := c.emailVerificationService.ConfirmEmailChange(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// ResendVerification godoc
+// @Summary      ResendVerification
+// @description  sends a new verification link if the email belongs to an unverified account
+// @Tags         user
+// @Success      202
+// @Failure      400              {string}  string    "error"
+// @Param        model  body    models.ResendVerificationModel  true  "ResendVerificationModel"
+// @Router       /users/verify-email/resend [post]
+func (c *UserController) ResendVerification(context *gin.Context) {
+	var model models.ResendVerificationModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	errorModel := c.emailVerificationService.ResendVerification(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusAccepted, nil)
+}
+
+// EnrollTwoFactor godoc
+// @Summary      EnrollTwoFactor
+// @description  starts two-factor enrollment and returns a new TOTP secret and otpauth URI
+// @Tags         user
+// @Success      200     {object}  models.TwoFactorEnrollmentResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Failure      409              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/two-factor/enroll [post]
+func (c *UserController) EnrollTwoFactor(context *gin.Context, id string) {
+	if !c.authorizeOwner(context, id) {
+		return
+	}
+
+	response, errorModel := c.twoFactorService.Enroll(context.Request.Context(), models.TwoFactorModel{Id: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// ConfirmTwoFactor godoc
+// @Summary      ConfirmTwoFactor
+// @description  enables two-factor authentication with a first code and returns the recovery codes
+// @Tags         user
+// @Success      200     {object}  models.RecoveryCodesResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Failure      409              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        model  body    models.TwoFactorCodeModel  true  "TwoFactorCodeModel"
+// @Router       /users/{id}/two-factor/confirm [post]
+func (c *UserController) ConfirmTwoFactor(context *gin.Context, id string) {
+	if !c.authorizeOwner(context, id) {
+		return
+	}
+
+	var model models.TwoFactorCodeModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.Id = id
+
+	response, errorModel := c.twoFactorService.ConfirmEnrollment(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      RegenerateRecoveryCodes
+// @description  replaces the recovery codes after checking a current two-factor code
+// @Tags         user
+// @Success      200     {object}  models.RecoveryCodesResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Failure      409              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        model  body    models.TwoFactorCodeModel  true  "TwoFactorCodeModel"
+// @Router       /users/{id}/two-factor/recovery-codes [post]
+func (c *UserController) RegenerateRecoveryCodes(context *gin.Context, id string) {
+	if !c.authorizeOwner(context, id) {
+		return
+	}
+
+	var model models.TwoFactorCodeModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.Id = id
+
+	response, errorModel := c.twoFactorService.RegenerateRecoveryCodes(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// ResetTwoFactor godoc
+// @Summary      ResetTwoFactor
+// @description  disables two-factor authentication for the user and discards the secret and recovery codes
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/two-factor [delete]
+func (c *UserController) ResetTwoFactor(context *gin.Context, id string) {
+	if !c.authorize(context, models.ResetTwoFactorPermission, "") {
+		return
+	}
+
+	errorModel := c.twoFactorService.Reset(context.Request.Context(), models.TwoFactorModel{Id: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// UnlockUser godoc
+// @Summary      UnlockUser
+// @description  clears the failed login counter and lockout of the user's account
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/unlock [post]
+func (c *UserController) UnlockUser(context *gin.Context, id string) {
+	if !c.authorize(context, models.UnlockUsersPermission, "") {
+		return
+	}
+
+	errorModel := c.loginAttemptService.Unlock(context.Request.Context(), models.UnlockUserModel{Id: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// ChangePassword godoc
+// @Summary      ChangePassword
+// @description  replaces the user's own password after checking the current one; also accepted with the restricted token issued for an expired password
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        model  body    models.ChangePasswordModel  true  "ChangePasswordModel"
+// @Router       /users/{id}/password [post]
+func (c *UserController) ChangePassword(context *gin.Context, id string) {
+	if !c.authorizeOwner(context, id) {
+		return
+	}
+
+	var model models.ChangePasswordModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.Id = id
+
+	errorModel := c.userService.ChangePassword(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// ExpirePassword godoc
+// @Summary      ExpirePassword
+// @description  forces the user to change the password at the next login and revokes the user's sessions
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/password/expire [post]
+func (c *UserController) ExpirePassword(context *gin.Context, id string) {
+	if !c.authorize(context, models.ExpirePasswordsPermission, "") {
+		return
+	}
+
+	errorModel := c.userService.ExpirePassword(context.Request.Context(), models.ExpirePasswordModel{Id: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// CreateApiKey godoc
+// @Summary      CreateApiKey
+// @description  issues an API key for the user; the key is only returned by this call
+// @Tags         user
+// @Success      200     {object}  models.CreateApiKeyResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        model  body    models.CreateApiKeyModel  true  "CreateApiKeyModel"
+// @Router       /users/{id}/api-keys [post]
+func (c *UserController) CreateApiKey(context *gin.Context, id string) {
+	if !c.authorizeApiKeys(context, id) {
+		return
+	}
+
+	var model models.CreateApiKeyModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+	model.UserId = id
+
+	response, errorModel := c.apiKeyService.Create(context.Request.Context(), model)
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// ListApiKeys godoc
+// @Summary      ListApiKeys
+// @description  lists the user's API keys that have not been revoked
+// @Tags         user
+// @Success      200     {object}  []models.ApiKeyResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/api-keys [get]
+func (c *UserController) ListApiKeys(context *gin.Context, id string) {
+	if !c.authorizeApiKeys(context, id) {
+		return
+	}
+
+	response, errorModel := c.apiKeyService.List(context.Request.Context(), models.ListApiKeysModel{UserId: id})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// RevokeApiKey godoc
+// @Summary      RevokeApiKey
+// @description  revokes one of the user's API keys
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        keyId   path      string  true  "keyId"
+// @Router       /users/{id}/api-keys/{keyId} [delete]
+func (c *UserController) RevokeApiKey(context *gin.Context, id string, keyId string) {
+	if !c.authorizeApiKeys(context, id) {
+		return
+	}
+
+	errorModel := c.apiKeyService.Revoke(context.Request.Context(), models.RevokeApiKeyModel{UserId: id, Id: keyId})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// currentSessionId is the session the request was made from, but only when
+// it belongs to the user whose sessions are being managed.
+func currentSessionId(context *gin.Context, id string) string {
+	principal, _ := middlewares.GetPrincipal(context)
+
+	if principal.UserId != id {
+		return ""
+	}
+
+	return principal.SessionId
+}
+
+// GetSessions godoc
+// @Summary      GetSessions
+// @description  lists the user's active sessions, marking the one the request was made from
+// @Tags         user
+// @Success      200     {object}  []models.SessionResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/sessions [get]
+func (c *UserController) GetSessions(context *gin.Context, id string) {
+	if !c.authorize(context, models.ManageSessionsPermission, id) {
+		return
+	}
+
+	response, errorModel := c.sessionService.GetSessions(context.Request.Context(),
+		models.ListSessionsModel{UserId: id, CurrentSessionId: currentSessionId(context, id)})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// RevokeSession godoc
+// @Summary      RevokeSession
+// @description  signs one session out; its access token stays valid until it expires
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        sessionId   path      string  true  "sessionId"
+// @Router       /users/{id}/sessions/{sessionId} [delete]
+func (c *UserController) RevokeSession(context *gin.Context, id string, sessionId string) {
+	if !c.authorize(context, models.ManageSessionsPermission, id) {
+		return
+	}
+
+	errorModel := c.sessionService.RevokeSession(context.Request.Context(),
+		models.RevokeSessionModel{UserId: id, Id: sessionId})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// RevokeOtherSessions godoc
+// @Summary      RevokeOtherSessions
+// @description  signs out every session of the user except the one the request was made from
+// @Tags         user
+// @Success      200
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Router       /users/{id}/sessions [delete]
+func (c *UserController) RevokeOtherSessions(context *gin.Context, id string) {
+	if !c.authorize(context, models.ManageSessionsPermission, id) {
+		return
+	}
+
+	errorModel := c.sessionService.RevokeOtherSessions(context.Request.Context(),
+		models.RevokeSessionsModel{UserId: id, CurrentSessionId: currentSessionId(context, id)})
+
+	if errorModel != nil {
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	context.JSON(http.StatusOK, nil)
+}
+
+// Impersonate godoc
+// @Summary      Impersonate
+// @description  issues a short-lived token acting as the user on behalf of the calling admin; the token cannot be refreshed and is refused for sensitive operations
+// @Tags         user
+// @Success      200     {object}  models.LoginResponseModel
+// @Failure      400              {string}  string    "error"
+// @Failure      401              {string}  string    "error"
+// @Failure      403              {string}  string    "error"
+// @Failure      404              {string}  string    "error"
+// @Param        id   path      string  true  "id"
+// @Param        model  body    models.ImpersonateModel  false  "ImpersonateModel"
+// @Router       /users/{id}/impersonate [post]
+func (c *UserController) Impersonate(context *gin.Context, id string) {
+	if !c.authorize(context, models.ImpersonateUsersPermission, "") {
+		return
+	}
+
+	var model models.ImpersonateModel
+	err := context.ShouldBindJSON(&model)
+
+	if err != nil && err != io.EOF {
+		errorModel := models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+
+		context.JSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	principal, _ := middlewares.GetPrincipal(context)
+	model.UserId = id
+	model.ActorId = principal.UserId
+	model.ActorEmail = principal.Email
+
+	response, errorModel := c.impersonationService.Impersonate(context.Request.Context(), model)
 
 	if errorModel != nil {
 		context.JSON(errorModel.StatusCode, errorModel.Error)
@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"user-management-service/src/models"
+)
+
+const apiKeyPrefixBytes = 6
+
+// GenerateApiKey returns a key of the form umk_<prefix>_<secret>. The prefix
+// is stored in plain text to look the key up; only the hash of the whole key
+// is stored.
+func GenerateApiKey() (key string, prefix string, err error) {
+	buffer := make([]byte, apiKeyPrefixBytes)
+	if _, err = rand.Read(buffer); err != nil {
+		return "", "", err
+	}
+
+	secret, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix = hex.EncodeToString(buffer)
+
+	return models.ApiKeyPrefix + "_" + prefix + "_" + secret, prefix, nil
+}
+
+// ParseApiKeyPrefix returns the lookup prefix of a key, or false when the
+// key is not in the format issued by GenerateApiKey.
+func ParseApiKeyPrefix(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+
+	if len(parts) != 3 || parts[0] != models.ApiKeyPrefix || len(parts[1]) != 2*apiKeyPrefixBytes ||
+		parts[2] == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
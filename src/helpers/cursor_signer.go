@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ICursorSigner interface {
+	Encode(value interface{}) (string, error)
+	Decode(cursor string, value interface{}) error
+}
+
+// CursorSigner turns pagination positions into opaque cursors that clients
+// cannot forge or alter. A cursor is the base64url encoded JSON of the
+// position followed by its HMAC-SHA256.
+type CursorSigner struct {
+	key []byte
+}
+
+// NewCursorSigner signs with the given secret. Without one a random key is
+// used, so cursors stop working when the service restarts and are not
+// accepted by other instances.
+func NewCursorSigner(secret string) *CursorSigner {
+	key := []byte(secret)
+
+	if secret == "" {
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+
+	return &CursorSigner{key: key}
+}
+
+func (s *CursorSigner) Encode(value interface{}) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), nil
+}
+
+func (s *CursorSigner) Decode(cursor string, value interface{}) error {
+	parts := strings.SplitN(cursor, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.sign(parts[0])) {
+		return ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(payload, value) != nil {
+		return ErrInvalidCursor
+	}
+
+	return nil
+}
+
+func (s *CursorSigner) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
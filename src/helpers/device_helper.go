@@ -0,0 +1,35 @@
+package helpers
+
+import "strings"
+
+// DescribeDevice gives a short, human readable name for the device a
+// user agent belongs to. It only recognises common platforms and is meant
+// for display, not for any security decision.
+func DescribeDevice(userAgent string) string {
+	lowered := strings.ToLower(userAgent)
+
+	platforms := []struct {
+		marker string
+		name   string
+	}{
+		{"iphone", "iPhone"},
+		{"ipad", "iPad"},
+		{"android", "Android"},
+		{"windows", "Windows"},
+		{"mac os x", "macOS"},
+		{"cros", "ChromeOS"},
+		{"linux", "Linux"},
+	}
+
+	for _, platform := range platforms {
+		if strings.Contains(lowered, platform.marker) {
+			return platform.name
+		}
+	}
+
+	if userAgent == "" {
+		return "Unknown"
+	}
+
+	return "Other"
+}
@@ -0,0 +1,51 @@
+package helpers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrETagMismatch = errors.New("etag does not match")
+
+// UserETag is the strong entity tag of the given user version.
+func UserETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseIfMatch returns the version an If-Match header requires. An empty
+// header or "*" requires none. Weak tags never match under the strong
+// comparison If-Match uses, and a list of tags is not supported, so both
+// fail with ErrETagMismatch like a tag of another version would.
+func ParseIfMatch(header string) (*int64, error) {
+	header = strings.TrimSpace(header)
+
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return nil, ErrETagMismatch
+	}
+
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version < 0 {
+		return nil, ErrETagMismatch
+	}
+
+	return &version, nil
+}
+
+// ETagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison so "W/" prefixed tags added by proxies still match.
+func ETagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,7 +3,9 @@ package helpers
 import (
 	"context"
 	"sync"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -11,12 +13,28 @@ import (
 var mongoOnce sync.Once
 
 const (
-	Db                 = "UserDb"
-	UserCollectionName = "User"
+	Db                               = "UserDb"
+	UserCollectionName               = "User"
+	RefreshTokenCollectionName       = "RefreshToken"
+	PasswordResetTokenCollectionName = "PasswordResetToken"
+	LoginAttemptCollectionName       = "LoginAttempt"
+	ApiKeyCollectionName             = "ApiKey"
+	OAuthClientCollectionName        = "OAuthClient"
+	AuthorizationCodeCollectionName  = "AuthorizationCode"
+	SessionCollectionName            = "Session"
+	MagicLinkTokenCollectionName     = "MagicLinkToken"
 )
 
 var (
-	UserCollection *mongo.Collection
+	UserCollection               *mongo.Collection
+	RefreshTokenCollection       *mongo.Collection
+	PasswordResetTokenCollection *mongo.Collection
+	LoginAttemptCollection       *mongo.Collection
+	ApiKeyCollection             *mongo.Collection
+	OAuthClientCollection        *mongo.Collection
+	AuthorizationCodeCollection  *mongo.Collection
+	SessionCollection            *mongo.Collection
+	MagicLinkTokenCollection     *mongo.Collection
 )
 
 type ConnectionHelper struct {
@@ -52,5 +70,55 @@ func (c *ConnectionHelper) ConnectDb() {
 		db := client.Database(Db)
 
 		UserCollection = db.Collection(UserCollectionName)
+		RefreshTokenCollection = db.Collection(RefreshTokenCollectionName)
+		PasswordResetTokenCollection = db.Collection(PasswordResetTokenCollectionName)
+		LoginAttemptCollection = db.Collection(LoginAttemptCollectionName)
+		ApiKeyCollection = db.Collection(ApiKeyCollectionName)
+		OAuthClientCollection = db.Collection(OAuthClientCollectionName)
+		AuthorizationCodeCollection = db.Collection(AuthorizationCodeCollectionName)
+		SessionCollection = db.Collection(SessionCollectionName)
+		MagicLinkTokenCollection = db.Collection(MagicLinkTokenCollectionName)
 	})
 }
+
+// EnsureTokenIndexes creates the indexes the token collections are looked up
+// by, unique so that a hash or prefix always names one record, and the TTL
+// indexes that remove records once they expired. Sign-in link requests are
+// kept for magicLinkWindow after expiring, since the rate limit still counts
+// them.
+func EnsureTokenIndexes(context context.Context, magicLinkWindow time.Duration) error {
+	expired := func(after time.Duration) mongo.IndexModel {
+		return mongo.IndexModel{
+			Keys:    bson.D{{"ExpiresAt", 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(after.Seconds())),
+		}
+	}
+	unique := func(field string) mongo.IndexModel {
+		return mongo.IndexModel{Keys: bson.D{{field, 1}}, Options: options.Index().SetUnique(true)}
+	}
+
+	indexes := []struct {
+		collection *mongo.Collection
+		models     []mongo.IndexModel
+	}{
+		{RefreshTokenCollection, []mongo.IndexModel{
+			unique("TokenHash"), {Keys: bson.D{{"FamilyId", 1}}}, expired(0)}},
+		{SessionCollection, []mongo.IndexModel{{Keys: bson.D{{"UserId", 1}}}}},
+		{PasswordResetTokenCollection, []mongo.IndexModel{unique("TokenHash"), expired(0)}},
+		// Requests for unknown emails have no token hash.
+		{MagicLinkTokenCollection, []mongo.IndexModel{
+			{Keys: bson.D{{"TokenHash", 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{"Email", 1}, {"CreatedAt", 1}}},
+			expired(magicLinkWindow)}},
+		{ApiKeyCollection, []mongo.IndexModel{unique("Prefix"), {Keys: bson.D{{"UserId", 1}}}, expired(0)}},
+		{AuthorizationCodeCollection, []mongo.IndexModel{unique("CodeHash"), expired(0)}},
+	}
+
+	for _, index := range indexes {
+		if _, err := index.collection.Indexes().CreateMany(context, index.models); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"user-management-service/src/configuration"
+	"user-management-service/src/models"
+)
+
+const (
+	FileNotificationSenderType     = "file"
+	InMemoryNotificationSenderType = "memory"
+
+	defaultNotificationFilePath = "notifications.log"
+)
+
+type INotificationSender interface {
+	Send(context context.Context, message models.NotificationMessage) error
+}
+
+// NewNotificationSender returns the sender selected by configuration. Only
+// local senders exist for now; a real mail provider can be plugged in by
+// implementing INotificationSender.
+func NewNotificationSender(config configuration.NotificationConfigurations) INotificationSender {
+	if strings.ToLower(config.Sender) == InMemoryNotificationSenderType {
+		return NewInMemoryNotificationSender()
+	}
+
+	return NewFileNotificationSender(config.FilePath)
+}
+
+// FileNotificationSender appends every message as a JSON line to a file.
+type FileNotificationSender struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileNotificationSender(path string) *FileNotificationSender {
+	if path == "" {
+		path = defaultNotificationFilePath
+	}
+	return &FileNotificationSender{path: path}
+}
+
+func (s *FileNotificationSender) Send(context context.Context, message models.NotificationMessage) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// InMemoryNotificationSender keeps sent messages in an outbox that tests and
+// local development can inspect.
+type InMemoryNotificationSender struct {
+	messages []models.NotificationMessage
+	mutex    sync.Mutex
+}
+
+func NewInMemoryNotificationSender() *InMemoryNotificationSender {
+	return &InMemoryNotificationSender{}
+}
+
+func (s *InMemoryNotificationSender) Send(context context.Context, message models.NotificationMessage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func (s *InMemoryNotificationSender) Messages() []models.NotificationMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := make([]models.NotificationMessage, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
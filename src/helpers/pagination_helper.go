@@ -0,0 +1,78 @@
+package helpers
+
+import (
+	"net/url"
+	"strconv"
+	"user-management-service/src/models"
+)
+
+// PageLinks builds the links of one page of an offset paginated listing from
+// the request URL, keeping its other query parameters. Links are relative to
+// the host. prev and next are left out on the first and last pages.
+func PageLinks(requestUrl *url.URL, page int, limit int, total int64) models.PaginationLinksModel {
+	lastPage := 1
+	if limit > 0 && total > 0 {
+		lastPage = int((total + int64(limit) - 1) / int64(limit))
+	}
+
+	link := func(page int) string {
+		query := requestUrl.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("limit", strconv.Itoa(limit))
+		return (&url.URL{Path: requestUrl.Path, RawQuery: query.Encode()}).String()
+	}
+
+	links := models.PaginationLinksModel{
+		Self:  link(page),
+		First: link(1),
+		Last:  link(lastPage),
+	}
+
+	if page > 1 {
+		previous := page - 1
+		if previous > lastPage {
+			previous = lastPage
+		}
+		links.Prev = link(previous)
+	}
+
+	if page < lastPage {
+		links.Next = link(page + 1)
+	}
+
+	return links
+}
+
+// CursorLinks builds the links of one page of a cursor paginated listing.
+// first restarts from the beginning with the same query; prev and next are
+// left out when the page has no cursor for them.
+func CursorLinks(requestUrl *url.URL, limit int, prevCursor string, nextCursor string) models.PaginationLinksModel {
+	link := func(cursor string) string {
+		query := requestUrl.Query()
+		query.Del("page")
+		query.Set("limit", strconv.Itoa(limit))
+		if cursor == "" {
+			query.Del("cursor")
+			query.Set("pagination", models.CursorPagination)
+		} else {
+			query.Del("pagination")
+			query.Set("cursor", cursor)
+		}
+		return (&url.URL{Path: requestUrl.Path, RawQuery: query.Encode()}).String()
+	}
+
+	links := models.PaginationLinksModel{
+		Self:  link(requestUrl.Query().Get("cursor")),
+		First: link(""),
+	}
+
+	if prevCursor != "" {
+		links.Prev = link(prevCursor)
+	}
+
+	if nextCursor != "" {
+		links.Next = link(nextCursor)
+	}
+
+	return links
+}
@@ -0,0 +1,181 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"user-management-service/src/configuration"
+)
+
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+type IPasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, encodedHash string) (match bool, needsRehash bool, err error)
+}
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies every supported encoding, so the algorithm or its cost can be
+// changed without invalidating stored passwords.
+type PasswordHasher struct {
+	algorithm  string
+	argon2     configuration.Argon2Configurations
+	bcryptCost int
+}
+
+func NewPasswordHasher(config configuration.PasswordHashingConfigurations) *PasswordHasher {
+	hasher := &PasswordHasher{
+		algorithm:  strings.ToLower(config.Algorithm),
+		argon2:     config.Argon2,
+		bcryptCost: config.Bcrypt.Cost,
+	}
+
+	if hasher.algorithm == "" {
+		hasher.algorithm = Argon2idAlgorithm
+	}
+	if hasher.argon2.Memory == 0 {
+		hasher.argon2.Memory = 64 * 1024
+	}
+	if hasher.argon2.Iterations == 0 {
+		hasher.argon2.Iterations = 3
+	}
+	if hasher.argon2.Parallelism == 0 {
+		hasher.argon2.Parallelism = 2
+	}
+	if hasher.argon2.SaltLength == 0 {
+		hasher.argon2.SaltLength = 16
+	}
+	if hasher.argon2.KeyLength == 0 {
+		hasher.argon2.KeyLength = 32
+	}
+	if hasher.bcryptCost == 0 {
+		hasher.bcryptCost = bcrypt.DefaultCost
+	}
+
+	return hasher
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == BcryptAlgorithm {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	if h.algorithm != Argon2idAlgorithm {
+		return "", fmt.Errorf("unsupported password hashing algorithm %q", h.algorithm)
+	}
+
+	salt := make([]byte, h.argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism,
+		h.argon2.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2idAlgorithm, argon2.Version,
+		h.argon2.Memory, h.argon2.Iterations, h.argon2.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches encodedHash and whether the stored
+// value should be replaced because it was produced with a different algorithm
+// or different parameters than the ones currently configured. An empty value
+// never matches. Anything else without a recognised prefix is rejected, legacy
+// plaintext passwords are hashed once at startup by MigrateLegacyPasswords.
+func (h *PasswordHasher) Verify(password string, encodedHash string) (match bool, needsRehash bool, err error) {
+	switch {
+	case encodedHash == "":
+		return false, false, nil
+	case strings.HasPrefix(encodedHash, "$"+Argon2idAlgorithm+"$"):
+		return h.verifyArgon2id(password, encodedHash)
+	case strings.HasPrefix(encodedHash, "$2a$"), strings.HasPrefix(encodedHash, "$2b$"),
+		strings.HasPrefix(encodedHash, "$2y$"):
+		return h.verifyBcrypt(password, encodedHash)
+	default:
+		return false, false, ErrInvalidPasswordHash
+	}
+}
+
+// IsPasswordHash reports whether value is in one of the encodings Verify
+// understands rather than a legacy plaintext password.
+func IsPasswordHash(value string) bool {
+	return strings.HasPrefix(value, "$"+Argon2idAlgorithm+"$") || strings.HasPrefix(value, "$2a$") ||
+		strings.HasPrefix(value, "$2b$") || strings.HasPrefix(value, "$2y$")
+}
+
+func (h *PasswordHasher) verifyArgon2id(password string, encodedHash string) (bool, bool, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+
+	if subtle.ConstantTimeCompare(actual, expected) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := h.algorithm != Argon2idAlgorithm ||
+		memory != h.argon2.Memory ||
+		iterations != h.argon2.Iterations ||
+		parallelism != h.argon2.Parallelism ||
+		uint32(len(salt)) != h.argon2.SaltLength ||
+		uint32(len(expected)) != h.argon2.KeyLength
+
+	return true, needsRehash, nil
+}
+
+func (h *PasswordHasher) verifyBcrypt(password string, encodedHash string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	return true, h.algorithm != BcryptAlgorithm || cost != h.bcryptCost, nil
+}
@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"context"
+
+	"user-management-service/src/models"
+)
+
+type principalContextKey struct{}
+
+func WithPrincipal(ctx context.Context, principal models.Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (models.Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(models.Principal)
+	return principal, ok
+}
+
+// Impersonating reports whether the request is made by an admin through an
+// impersonation token.
+func Impersonating(ctx context.Context) bool {
+	principal, _ := PrincipalFromContext(ctx)
+	return principal.ActorId != ""
+}
@@ -0,0 +1,408 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"user-management-service/src/models"
+)
+
+var ErrInvalidScimFilter = errors.New("invalid scim filter")
+
+// scimAttribute describes how a filterable SCIM attribute is stored.
+type scimAttribute struct {
+	field     string
+	kind      string
+	caseExact bool
+}
+
+const (
+	scimStringAttribute   = "string"
+	scimBooleanAttribute  = "boolean"
+	scimIdAttribute       = "id"
+	scimDateTimeAttribute = "dateTime"
+)
+
+// scimUserAttributes lists the User attributes that may be filtered on,
+// keyed by their lower case path. Matching of attribute names is case
+// insensitive as required by RFC 7644.
+var scimUserAttributes = map[string]scimAttribute{
+	"id":              {field: "_id", kind: scimIdAttribute},
+	"externalid":      {field: "ExternalId", kind: scimStringAttribute, caseExact: true},
+	"username":        {field: "Email", kind: scimStringAttribute},
+	"emails":          {field: "Email", kind: scimStringAttribute},
+	"emails.value":    {field: "Email", kind: scimStringAttribute},
+	"displayname":     {field: "Name", kind: scimStringAttribute},
+	"name.formatted":  {field: "Name", kind: scimStringAttribute},
+	"name.givenname":  {field: "GivenName", kind: scimStringAttribute},
+	"name.familyname": {field: "FamilyName", kind: scimStringAttribute},
+	"active":          {field: "Disabled", kind: scimBooleanAttribute},
+	"meta.created":    {field: "_id", kind: scimDateTimeAttribute},
+}
+
+// ParseScimFilter translates a SCIM filter expression on users, such as
+// `userName eq "a@b.com" and not (active eq false)`, into a MongoDB query.
+// Complex attribute filters in brackets are not supported.
+func ParseScimFilter(filter string) (bson.D, error) {
+	tokens, err := tokenizeScimFilter(filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &scimFilterParser{tokens: tokens}
+
+	query, err := parser.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.position != len(parser.tokens) {
+		return nil, ErrInvalidScimFilter
+	}
+
+	return query, nil
+}
+
+type scimFilterToken struct {
+	value  string
+	quoted bool
+}
+
+func tokenizeScimFilter(filter string) ([]scimFilterToken, error) {
+	var tokens []scimFilterToken
+
+	for i := 0; i < len(filter); {
+		switch character := filter[i]; {
+		case character == ' ' || character == '\t':
+			i++
+		case character == '(' || character == ')':
+			tokens = append(tokens, scimFilterToken{value: string(character)})
+			i++
+		case character == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, ErrInvalidScimFilter
+			}
+
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, ErrInvalidScimFilter
+			}
+
+			tokens = append(tokens, scimFilterToken{value: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t()\"", rune(filter[end])) {
+				end++
+			}
+			if strings.ContainsAny(filter[i:end], "[]") {
+				return nil, ErrInvalidScimFilter
+			}
+			tokens = append(tokens, scimFilterToken{value: filter[i:end]})
+			i = end
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, ErrInvalidScimFilter
+	}
+
+	return tokens, nil
+}
+
+type scimFilterParser struct {
+	tokens   []scimFilterToken
+	position int
+}
+
+func (p *scimFilterParser) peekKeyword(keyword string) bool {
+	return p.position < len(p.tokens) && !p.tokens[p.position].quoted &&
+		strings.EqualFold(p.tokens[p.position].value, keyword)
+}
+
+func (p *scimFilterParser) next() (scimFilterToken, error) {
+	if p.position >= len(p.tokens) {
+		return scimFilterToken{}, ErrInvalidScimFilter
+	}
+	token := p.tokens[p.position]
+	p.position++
+	return token, nil
+}
+
+func (p *scimFilterParser) parseOr() (bson.D, error) {
+	left, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	operands := bson.A{left}
+
+	for p.peekKeyword("or") {
+		p.position++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, right)
+	}
+
+	if len(operands) == 1 {
+		return left, nil
+	}
+
+	return bson.D{{"$or", operands}}, nil
+}
+
+func (p *scimFilterParser) parseAnd() (bson.D, error) {
+	left, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	operands := bson.A{left}
+
+	for p.peekKeyword("and") {
+		p.position++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, right)
+	}
+
+	if len(operands) == 1 {
+		return left, nil
+	}
+
+	return bson.D{{"$and", operands}}, nil
+}
+
+func (p *scimFilterParser) parseUnary() (bson.D, error) {
+	negate := false
+
+	if p.peekKeyword("not") {
+		p.position++
+		negate = true
+	}
+
+	if p.peekKeyword("(") {
+		p.position++
+
+		query, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.peekKeyword(")") {
+			return nil, ErrInvalidScimFilter
+		}
+		p.position++
+
+		if negate {
+			return bson.D{{"$nor", bson.A{query}}}, nil
+		}
+		return query, nil
+	}
+
+	// "not" must be followed by a parenthesised expression.
+	if negate {
+		return nil, ErrInvalidScimFilter
+	}
+
+	return p.parseComparison()
+}
+
+func (p *scimFilterParser) parseComparison() (bson.D, error) {
+	pathToken, err := p.next()
+
+	if err != nil || pathToken.quoted {
+		return nil, ErrInvalidScimFilter
+	}
+
+	path := strings.ToLower(strings.TrimPrefix(pathToken.value, models.ScimUserSchema+":"))
+	attribute, ok := scimUserAttributes[path]
+
+	if !ok {
+		return nil, ErrInvalidScimFilter
+	}
+
+	operatorToken, err := p.next()
+
+	if err != nil || operatorToken.quoted {
+		return nil, ErrInvalidScimFilter
+	}
+
+	operator := strings.ToLower(operatorToken.value)
+
+	if operator == "pr" {
+		return presentQuery(attribute), nil
+	}
+
+	valueToken, err := p.next()
+
+	if err != nil {
+		return nil, ErrInvalidScimFilter
+	}
+
+	switch attribute.kind {
+	case scimStringAttribute:
+		if !valueToken.quoted {
+			return nil, ErrInvalidScimFilter
+		}
+		return stringQuery(attribute, operator, valueToken.value)
+	case scimBooleanAttribute:
+		return booleanQuery(attribute, operator, valueToken)
+	case scimIdAttribute:
+		return idQuery(attribute, operator, valueToken)
+	case scimDateTimeAttribute:
+		return dateTimeQuery(attribute, operator, valueToken)
+	}
+
+	return nil, ErrInvalidScimFilter
+}
+
+func presentQuery(attribute scimAttribute) bson.D {
+	switch attribute.kind {
+	case scimStringAttribute:
+		return bson.D{{attribute.field, bson.D{{"$exists", true}, {"$nin", bson.A{"", nil}}}}}
+	default:
+		// Ids, creation times and the active flag are always present.
+		return bson.D{}
+	}
+}
+
+func stringQuery(attribute scimAttribute, operator string, value string) (bson.D, error) {
+	options := "i"
+	if attribute.caseExact {
+		options = ""
+	}
+
+	quoted := regexp.QuoteMeta(value)
+
+	var condition interface{}
+
+	switch operator {
+	case "eq":
+		if attribute.caseExact {
+			condition = value
+		} else {
+			condition = primitive.Regex{Pattern: "^" + quoted + "$", Options: options}
+		}
+	case "ne":
+		if attribute.caseExact {
+			condition = bson.D{{"$ne", value}}
+		} else {
+			condition = bson.D{{"$not", primitive.Regex{Pattern: "^" + quoted + "$", Options: options}}}
+		}
+	case "co":
+		condition = primitive.Regex{Pattern: quoted, Options: options}
+	case "sw":
+		condition = primitive.Regex{Pattern: "^" + quoted, Options: options}
+	case "ew":
+		condition = primitive.Regex{Pattern: quoted + "$", Options: options}
+	case "gt", "ge", "lt", "le":
+		condition = bson.D{{"$" + strings.Replace(operator, "e", "te", 1), value}}
+	default:
+		return nil, ErrInvalidScimFilter
+	}
+
+	return bson.D{{attribute.field, condition}}, nil
+}
+
+// booleanQuery handles the active attribute, which is stored inverted as
+// Disabled and absent for active users.
+func booleanQuery(attribute scimAttribute, operator string, token scimFilterToken) (bson.D, error) {
+	if token.quoted || (token.value != "true" && token.value != "false") {
+		return nil, ErrInvalidScimFilter
+	}
+
+	active := token.value == "true"
+
+	switch operator {
+	case "eq":
+	case "ne":
+		active = !active
+	default:
+		return nil, ErrInvalidScimFilter
+	}
+
+	if active {
+		return bson.D{{attribute.field, bson.D{{"$ne", true}}}}, nil
+	}
+	return bson.D{{attribute.field, true}}, nil
+}
+
+func idQuery(attribute scimAttribute, operator string, token scimFilterToken) (bson.D, error) {
+	if !token.quoted {
+		return nil, ErrInvalidScimFilter
+	}
+
+	// Ids that are not valid object ids simply match no user.
+	objID, _ := primitive.ObjectIDFromHex(token.value)
+
+	switch operator {
+	case "eq":
+		return bson.D{{attribute.field, objID}}, nil
+	case "ne":
+		return bson.D{{attribute.field, bson.D{{"$ne", objID}}}}, nil
+	}
+
+	return nil, ErrInvalidScimFilter
+}
+
+// dateTimeQuery compares creation times through the timestamp embedded in
+// object ids, which have a resolution of one second.
+func dateTimeQuery(attribute scimAttribute, operator string, token scimFilterToken) (bson.D, error) {
+	if !token.quoted {
+		return nil, ErrInvalidScimFilter
+	}
+
+	value, err := time.Parse(time.RFC3339, token.value)
+
+	if err != nil {
+		return nil, ErrInvalidScimFilter
+	}
+
+	lowest := objectIDFromSeconds(value.Unix())
+	highest := objectIDFromSeconds(value.Unix() + 1)
+
+	switch operator {
+	case "gt":
+		return bson.D{{attribute.field, bson.D{{"$gte", highest}}}}, nil
+	case "ge":
+		return bson.D{{attribute.field, bson.D{{"$gte", lowest}}}}, nil
+	case "lt":
+		return bson.D{{attribute.field, bson.D{{"$lt", lowest}}}}, nil
+	case "le":
+		return bson.D{{attribute.field, bson.D{{"$lt", highest}}}}, nil
+	}
+
+	return nil, ErrInvalidScimFilter
+}
+
+// objectIDFromSeconds returns the lowest object id created at the given Unix
+// time. Unlike primitive.NewObjectIDFromTimestamp, the remaining bytes are
+// zero so the id can be used as a range bound.
+func objectIDFromSeconds(seconds int64) primitive.ObjectID {
+	var objID primitive.ObjectID
+	binary.BigEndian.PutUint32(objID[0:4], uint32(seconds))
+	return objID
+}
@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SearchTerms splits a text search query into the words to highlight. Quotes
+// of phrases are dropped and negated words are left out.
+func SearchTerms(query string) []string {
+	terms := []string{}
+
+	for _, term := range strings.Fields(strings.ReplaceAll(query, `"`, " ")) {
+		if !strings.HasPrefix(term, "-") {
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+// Highlight wraps the parts of value matching one of the terms, ignoring
+// case, in <em> tags and escapes the rest for HTML. It reports whether
+// anything matched.
+func Highlight(value string, terms []string) (string, bool) {
+	if len(terms) == 0 {
+		return html.EscapeString(value), false
+	}
+
+	// Longer terms first so that a term is not cut short by its own prefix.
+	sorted := append([]string{}, terms...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	patterns := make([]string, len(sorted))
+	for i, term := range sorted {
+		patterns[i] = regexp.QuoteMeta(term)
+	}
+
+	matches := regexp.MustCompile("(?i)"+strings.Join(patterns, "|")).FindAllStringIndex(value, -1)
+
+	if len(matches) == 0 {
+		return html.EscapeString(value), false
+	}
+
+	var builder strings.Builder
+	last := 0
+
+	for _, match := range matches {
+		builder.WriteString(html.EscapeString(value[last:match[0]]))
+		builder.WriteString("<em>")
+		builder.WriteString(html.EscapeString(value[match[0]:match[1]]))
+		builder.WriteString("</em>")
+		last = match[1]
+	}
+
+	builder.WriteString(html.EscapeString(value[last:]))
+
+	return builder.String(), true
+}
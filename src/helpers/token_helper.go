@@ -0,0 +1,362 @@
+package helpers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"user-management-service/src/configuration"
+	"user-management-service/src/models"
+)
+
+const (
+	HS256Algorithm = "HS256"
+	RS256Algorithm = "RS256"
+	EdDSAAlgorithm = "EdDSA"
+
+	defaultAccessTokenTtl = 15 * time.Minute
+	// DefaultAccessTokenAudience is the audience of access tokens when none
+	// is configured. Access tokens always carry one, so that purpose and ID
+	// tokens signed with the same key can never pass for them.
+	DefaultAccessTokenAudience = "user-management-service"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type AccessTokenClaims struct {
+	Email       string       `json:"email,omitempty"`
+	Roles       []string     `json:"roles,omitempty"`
+	Permissions []string     `json:"permissions,omitempty"`
+	Scope       string       `json:"scope,omitempty"`
+	SessionId   string       `json:"sid,omitempty"`
+	Actor       *ActorClaims `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ActorClaims identify who is really acting when a token is issued to
+// impersonate another user, following the "act" claim of RFC 8693.
+type ActorClaims struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// PurposeTokenClaims are carried by single-purpose tokens such as email
+// verification links. The purpose is used as the audience, so these tokens
+// are never accepted as access tokens and vice versa.
+type PurposeTokenClaims struct {
+	Email string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IdTokenClaims are carried by OpenID Connect ID tokens. The issuer and the
+// audience are set by the caller, as they differ from those of access tokens.
+type IdTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type ITokenHelper interface {
+	IssueAccessToken(user models.UserEntity, sessionId string) (token string, claims AccessTokenClaims, err error)
+	IssueRestrictedAccessToken(user models.UserEntity, scope string) (token string, claims AccessTokenClaims,
+		err error)
+	IssueImpersonationToken(user models.UserEntity, actor ActorClaims, ttl time.Duration) (token string,
+		claims AccessTokenClaims, err error)
+	ParseAccessToken(token string) (*AccessTokenClaims, error)
+	IssuePurposeToken(purpose string, subject string, email string, ttl time.Duration) (string, error)
+	ParsePurposeToken(token string, purpose string) (*PurposeTokenClaims, error)
+	IssueIdToken(claims IdTokenClaims) (string, error)
+	Algorithm() string
+	Jwks() models.JwksModel
+}
+
+// TokenHelper signs and validates JWTs with the key material configured in
+// configuration.JwtConfigurations.
+type TokenHelper struct {
+	issuer          string
+	audience        string
+	accessTokenTtl  time.Duration
+	signingMethod   jwt.SigningMethod
+	signingKey      interface{}
+	verificationKey interface{}
+}
+
+func NewTokenHelper(config configuration.JwtConfigurations) (*TokenHelper, error) {
+	helper := &TokenHelper{
+		issuer:         config.Issuer,
+		audience:       config.Audience,
+		accessTokenTtl: config.AccessTokenTtl,
+	}
+
+	if helper.accessTokenTtl <= 0 {
+		helper.accessTokenTtl = defaultAccessTokenTtl
+	}
+	if helper.audience == "" {
+		helper.audience = DefaultAccessTokenAudience
+	}
+
+	algorithm := config.Algorithm
+	if algorithm == "" {
+		algorithm = HS256Algorithm
+	}
+
+	switch strings.ToUpper(algorithm) {
+	case HS256Algorithm:
+		if config.Secret == "" {
+			return nil, errors.New("jwt secret is required for HS256")
+		}
+		helper.signingMethod = jwt.SigningMethodHS256
+		helper.signingKey = []byte(config.Secret)
+		helper.verificationKey = []byte(config.Secret)
+	case RS256Algorithm:
+		privatePem, err := readKey(config.PrivateKey, config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePem)
+		if err != nil {
+			return nil, err
+		}
+		helper.signingMethod = jwt.SigningMethodRS256
+		helper.signingKey = privateKey
+		helper.verificationKey = &privateKey.PublicKey
+
+		publicPem, err := readKey(config.PublicKey, config.PublicKeyFile)
+		if err == nil {
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPem)
+			if err != nil {
+				return nil, err
+			}
+			helper.verificationKey = publicKey
+		}
+	case strings.ToUpper(EdDSAAlgorithm):
+		privatePem, err := readKey(config.PrivateKey, config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(privatePem)
+		if err != nil {
+			return nil, err
+		}
+		helper.signingMethod = jwt.SigningMethodEdDSA
+		helper.signingKey = privateKey
+		helper.verificationKey = privateKey.(ed25519.PrivateKey).Public()
+
+		publicPem, err := readKey(config.PublicKey, config.PublicKeyFile)
+		if err == nil {
+			publicKey, err := jwt.ParseEdPublicKeyFromPEM(publicPem)
+			if err != nil {
+				return nil, err
+			}
+			helper.verificationKey = publicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", config.Algorithm)
+	}
+
+	return helper, nil
+}
+
+func readKey(inline string, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, errors.New("jwt key is not configured")
+}
+
+// IssueAccessToken issues a token for the session it belongs to; sessionId
+// is empty for tokens issued outside a session.
+func (h *TokenHelper) IssueAccessToken(user models.UserEntity, sessionId string) (token string,
+	claims AccessTokenClaims, err error) {
+	return h.issueAccessToken(user, AccessTokenClaims{
+		Email:       user.Email,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		SessionId:   sessionId,
+	}, h.accessTokenTtl)
+}
+
+// IssueRestrictedAccessToken issues a token without roles or permissions;
+// its scope limits it to the routes the middleware allows for that scope.
+func (h *TokenHelper) IssueRestrictedAccessToken(user models.UserEntity, scope string) (token string,
+	claims AccessTokenClaims, err error) {
+	return h.issueAccessToken(user, AccessTokenClaims{Email: user.Email, Scope: scope}, h.accessTokenTtl)
+}
+
+// IssueImpersonationToken issues a token acting as user on behalf of actor.
+// It belongs to no session and is never longer lived than an access token.
+func (h *TokenHelper) IssueImpersonationToken(user models.UserEntity, actor ActorClaims, ttl time.Duration) (
+	token string, claims AccessTokenClaims, err error) {
+	if ttl <= 0 || ttl > h.accessTokenTtl {
+		ttl = h.accessTokenTtl
+	}
+	return h.issueAccessToken(user, AccessTokenClaims{
+		Email:       user.Email,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		Actor:       &actor,
+	}, ttl)
+}
+
+func (h *TokenHelper) issueAccessToken(user models.UserEntity, claims AccessTokenClaims, ttl time.Duration) (
+	token string, issued AccessTokenClaims, err error) {
+	now := time.Now().UTC()
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        primitive.NewObjectID().Hex(),
+		Subject:   user.Id.Hex(),
+		Issuer:    h.issuer,
+		Audience:  jwt.ClaimStrings{h.audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+
+	token, err = jwt.NewWithClaims(h.signingMethod, claims).SignedString(h.signingKey)
+
+	return token, claims, err
+}
+
+// ParseAccessToken accepts only tokens whose sole audience is the access
+// token audience. Purpose tokens use their purpose and ID tokens the client
+// id as audience, so neither is taken for an access token.
+func (h *TokenHelper) ParseAccessToken(token string) (*AccessTokenClaims, error) {
+	claims := &AccessTokenClaims{}
+
+	if err := h.parse(token, claims); err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(h.issuer, h.issuer != "") ||
+		len(claims.Audience) != 1 || !claims.VerifyAudience(h.audience, true) ||
+		claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (h *TokenHelper) IssuePurposeToken(purpose string, subject string, email string,
+	ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+
+	claims := PurposeTokenClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Subject:   subject,
+			Issuer:    h.issuer,
+			Audience:  jwt.ClaimStrings{purpose},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	return jwt.NewWithClaims(h.signingMethod, claims).SignedString(h.signingKey)
+}
+
+func (h *TokenHelper) ParsePurposeToken(token string, purpose string) (*PurposeTokenClaims, error) {
+	claims := &PurposeTokenClaims{}
+
+	if err := h.parse(token, claims); err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(h.issuer, h.issuer != "") ||
+		!claims.VerifyAudience(purpose, true) ||
+		claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// IssueIdToken signs claims after setting the token id and a lifetime equal
+// to that of access tokens.
+func (h *TokenHelper) IssueIdToken(claims IdTokenClaims) (string, error) {
+	now := time.Now().UTC()
+
+	claims.ID = primitive.NewObjectID().Hex()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(h.accessTokenTtl))
+
+	return jwt.NewWithClaims(h.signingMethod, claims).SignedString(h.signingKey)
+}
+
+func (h *TokenHelper) Algorithm() string {
+	return h.signingMethod.Alg()
+}
+
+// Jwks publishes the verification key. It is empty for HS256, whose shared
+// secret must never be published.
+func (h *TokenHelper) Jwks() models.JwksModel {
+	jwks := models.JwksModel{Keys: []models.JwkModel{}}
+
+	switch key := h.verificationKey.(type) {
+	case *rsa.PublicKey:
+		jwks.Keys = append(jwks.Keys, models.JwkModel{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: h.Algorithm(),
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	case ed25519.PublicKey:
+		jwks.Keys = append(jwks.Keys, models.JwkModel{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: h.Algorithm(),
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		})
+	}
+
+	return jwks
+}
+
+func (h *TokenHelper) parse(token string, claims jwt.Claims) error {
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != h.signingMethod.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return h.verificationKey, nil
+	})
+
+	if err != nil || !parsed.Valid {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// GenerateOpaqueToken returns a random URL-safe token suitable for refresh
+// and other single-use tokens. Only its HashOpaqueToken value is persisted.
+func GenerateOpaqueToken() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,221 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+const PrincipalKey = "Principal"
+
+// AuthMiddleware validates bearer tokens or API keys for every route it is
+// attached to, except the routes registered through Public which may also be
+// called anonymously. API keys are sent in the X-API-Key header, or as a
+// bearer token by clients such as SCIM provisioning that cannot send custom
+// headers. Tokens carrying a scope are only accepted on the routes registered
+// for that scope through AllowScope. Impersonation tokens are refused on the
+// routes registered through BlockImpersonation, and every request made with
+// one is logged with both the admin and the impersonated user.
+type AuthMiddleware struct {
+	tokenHelper                helpers.ITokenHelper
+	apiKeyService              services.IApiKeyService
+	logger                     *logrus.Logger
+	publicRoutes               map[string]bool
+	scopeRoutes                map[string]bool
+	impersonationBlockedRoutes map[string]bool
+}
+
+func NewAuthMiddleware(tokenHelper helpers.ITokenHelper, apiKeyService services.IApiKeyService,
+	logger *logrus.Logger) *AuthMiddleware {
+	return &AuthMiddleware{tokenHelper: tokenHelper, apiKeyService: apiKeyService, logger: logger,
+		publicRoutes: map[string]bool{}, scopeRoutes: map[string]bool{}, impersonationBlockedRoutes: map[string]bool{}}
+}
+
+// Public marks the route registered with method and the full path pattern,
+// e.g. "/users/:id", as not requiring authentication.
+func (m *AuthMiddleware) Public(method string, path string) {
+	m.publicRoutes[method+" "+path] = true
+}
+
+// AllowScope lets tokens restricted to scope call the route registered with
+// method and the full path pattern.
+func (m *AuthMiddleware) AllowScope(scope string, method string, path string) {
+	m.scopeRoutes[scope+" "+method+" "+path] = true
+}
+
+// BlockImpersonation refuses impersonation tokens on the route registered
+// with method and the full path pattern.
+func (m *AuthMiddleware) BlockImpersonation(method string, path string) {
+	m.impersonationBlockedRoutes[method+" "+path] = true
+}
+
+func (m *AuthMiddleware) Authenticate(c *gin.Context) {
+	public := m.publicRoutes[c.Request.Method+" "+c.FullPath()]
+
+	header := c.GetHeader("Authorization")
+
+	if header == "" && c.GetHeader(models.ApiKeyHeader) != "" {
+		m.authenticateApiKey(c, c.GetHeader(models.ApiKeyHeader))
+		return
+	}
+
+	if header == "" {
+		if public {
+			c.Next()
+			return
+		}
+		m.abort(c, "Authorization header missing")
+		return
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+
+	if len(parts) != 2 || !strings.EqualFold(parts[0], models.BearerTokenType) || strings.TrimSpace(parts[1]) == "" {
+		m.abort(c, "Authorization header malformed")
+		return
+	}
+
+	token := strings.TrimSpace(parts[1])
+
+	if _, ok := helpers.ParseApiKeyPrefix(token); ok {
+		m.authenticateApiKey(c, token)
+		return
+	}
+
+	claims, err := m.tokenHelper.ParseAccessToken(token)
+
+	if err != nil {
+		m.abort(c, "Access token invalid")
+		return
+	}
+
+	if claims.Scope != "" && !m.scopeRoutes[claims.Scope+" "+c.Request.Method+" "+c.FullPath()] {
+		if public {
+			c.Next()
+			return
+		}
+		m.forbid(c, claims.Scope)
+		return
+	}
+
+	principal := models.Principal{
+		UserId:      claims.Subject,
+		Email:       claims.Email,
+		TokenId:     claims.ID,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		Scope:       claims.Scope,
+		SessionId:   claims.SessionId,
+	}
+
+	if claims.Actor != nil {
+		principal.ActorId = claims.Actor.Subject
+		principal.ActorEmail = claims.Actor.Email
+		m.authenticateImpersonation(c, principal)
+		return
+	}
+
+	m.setPrincipal(c, principal)
+}
+
+func (m *AuthMiddleware) authenticateImpersonation(c *gin.Context, principal models.Principal) {
+	route := c.Request.Method + " " + c.FullPath()
+
+	logger := m.logger.
+		WithField("Service", "AuthMiddleware").
+		WithField("Method", "Authenticate").
+		WithField("ActorId", principal.ActorId).
+		WithField("ActorEmail", principal.ActorEmail).
+		WithField("UserId", principal.UserId).
+		WithField("Route", route).
+		WithField("ClientIp", c.ClientIP())
+
+	if m.impersonationBlockedRoutes[route] {
+		logger.Warn("Impersonated request blocked")
+		c.AbortWithStatusJSON(http.StatusForbidden, models.ImpersonationForbiddenMessage)
+		return
+	}
+
+	m.setPrincipal(c, principal)
+
+	logger.
+		WithField("Path", c.Request.URL.Path).
+		WithField("Status", c.Writer.Status()).
+		Info("Impersonated request")
+}
+
+func (m *AuthMiddleware) authenticateApiKey(c *gin.Context, key string) {
+	principal, errorModel := m.apiKeyService.Authenticate(c.Request.Context(), key)
+
+	if errorModel != nil {
+		if errorModel.StatusCode == http.StatusUnauthorized {
+			m.abort(c, "API key invalid")
+			return
+		}
+		c.AbortWithStatusJSON(errorModel.StatusCode, errorModel.Error)
+		return
+	}
+
+	m.setPrincipal(c, principal)
+}
+
+func (m *AuthMiddleware) setPrincipal(c *gin.Context, principal models.Principal) {
+	c.Set(PrincipalKey, principal)
+	c.Request = c.Request.WithContext(helpers.WithPrincipal(c.Request.Context(), principal))
+
+	c.Next()
+}
+
+func (m *AuthMiddleware) abort(c *gin.Context, reason string) {
+	m.logger.
+		WithField("Service", "AuthMiddleware").
+		WithField("Method", "Authenticate").
+		WithField("Route", c.Request.Method+" "+c.FullPath()).
+		WithField("ClientIp", c.ClientIP()).
+		Warn(reason)
+
+	errorModel := models.ErrorModel{
+		Error:      models.UnauthorizedErrorMessage,
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	c.Header("WWW-Authenticate", models.BearerTokenType)
+	c.AbortWithStatusJSON(errorModel.StatusCode, errorModel.Error)
+}
+
+// forbid rejects a valid token whose scope does not cover the route.
+func (m *AuthMiddleware) forbid(c *gin.Context, scope string) {
+	m.logger.
+		WithField("Service", "AuthMiddleware").
+		WithField("Method", "Authenticate").
+		WithField("Route", c.Request.Method+" "+c.FullPath()).
+		WithField("Scope", scope).
+		WithField("ClientIp", c.ClientIP()).
+		Warn("Access token scope does not cover route")
+
+	errorModel := models.ErrorModel{
+		Error:      models.ForbiddenErrorMessage,
+		StatusCode: http.StatusForbidden,
+	}
+
+	if scope == models.PasswordChangeScope {
+		errorModel.Error = models.PasswordChangeRequiredMessage
+	}
+
+	c.AbortWithStatusJSON(errorModel.StatusCode, errorModel.Error)
+}
+
+// GetPrincipal returns the principal stored by Authenticate.
+func GetPrincipal(c *gin.Context) (models.Principal, bool) {
+	value, exists := c.Get(PrincipalKey)
+	if !exists {
+		return models.Principal{}, false
+	}
+	principal, ok := value.(models.Principal)
+	return principal, ok
+}
@@ -12,6 +12,7 @@ func CORSMiddleware(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Headers", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
+	c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 	if c.Request.Method == "OPTIONS" {
 		c.Status(http.StatusOK)
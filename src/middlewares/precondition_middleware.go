@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"user-management-service/src/configuration"
+	"user-management-service/src/models"
+)
+
+// PreconditionMiddleware refuses writes to a user that do not send the ETag
+// the user was read with in If-Match, so that concurrent changes are never
+// overwritten unnoticed. When the configuration does not require it the
+// header stays optional and is only checked when sent.
+type PreconditionMiddleware struct {
+	requireIfMatch bool
+	logger         *logrus.Logger
+}
+
+func NewPreconditionMiddleware(config configuration.ConcurrencyConfigurations,
+	logger *logrus.Logger) *PreconditionMiddleware {
+	return &PreconditionMiddleware{requireIfMatch: config.RequireIfMatch, logger: logger}
+}
+
+func (m *PreconditionMiddleware) RequireIfMatch(c *gin.Context) {
+	if !m.requireIfMatch || c.GetHeader("If-Match") != "" {
+		c.Next()
+		return
+	}
+
+	m.logger.
+		WithField("Middleware", "PreconditionMiddleware").
+		WithField("Method", c.Request.Method).
+		WithField("Path", c.FullPath()).
+		Warn("If-Match header missing")
+
+	c.AbortWithStatusJSON(http.StatusPreconditionRequired, models.PreconditionRequiredMessage)
+}
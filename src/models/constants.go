@@ -2,8 +2,165 @@ package models
 
 //Error Messages
 const (
-	EmailExistMessage        = "User with that email already exists"
-	InternalErrorMessage     = "server error"
-	BadRequestErrorMessage   = "Bad request"
-	UserNotFoundErrorMessage = "User with that id does not exist"
+	EmailExistMessage               = "User with that email already exists"
+	InternalErrorMessage            = "server error"
+	BadRequestErrorMessage          = "Bad request"
+	UserNotFoundErrorMessage        = "User with that id does not exist"
+	InvalidCredentialsErrorMessage  = "Invalid email or password"
+	InvalidRefreshTokenMessage      = "Refresh token is invalid or expired"
+	UnauthorizedErrorMessage        = "Unauthorized"
+	ForbiddenErrorMessage           = "You are not allowed to perform this operation"
+	LastAdminErrorMessage           = "The last admin cannot lose the admin role"
+	InvalidResetTokenMessage        = "Password reset token is invalid or expired"
+	InvalidVerificationTokenMessage = "Email verification token is invalid or expired"
+	EmailNotVerifiedErrorMessage    = "Email address has not been verified"
+	TwoFactorRequiredMessage        = "Two-factor authentication code required"
+	InvalidTwoFactorCodeMessage     = "Two-factor authentication code is invalid"
+	TwoFactorAlreadyEnabledMessage  = "Two-factor authentication is already enabled"
+	TwoFactorNotEnabledMessage      = "Two-factor authentication is not enabled"
+	TwoFactorNotEnrollingMessage    = "Two-factor enrollment has not been started"
+	TooManyLoginAttemptsMessage     = "Too many failed login attempts, try again later"
+	PasswordPolicyErrorMessage      = "Password does not meet the password policy"
+	PasswordReusedErrorMessage      = "Password was used recently and cannot be reused"
+	InvalidCurrentPasswordMessage   = "Current password is incorrect"
+	PasswordChangeRequiredMessage   = "Password has expired and must be changed"
+	InvalidApiKeyScopeMessage       = "API key scope is not valid"
+	InvalidApiKeyExpiryMessage      = "API key expiry must be in the future and within the allowed lifetime"
+	ApiKeyNotFoundErrorMessage      = "API key with that id does not exist"
+	OAuthClientNotFoundErrorMessage = "OAuth client with that id does not exist"
+	SessionNotFoundErrorMessage     = "Session with that id does not exist"
+	ImpersonationForbiddenMessage   = "This operation is not allowed while impersonating a user"
+	AdminImpersonationMessage       = "Admins and users who can impersonate cannot be impersonated"
+	InvalidMagicLinkMessage         = "Sign-in link is invalid or expired"
+	TooManyMagicLinksMessage        = "Too many sign-in links requested, try again later"
+	AccountDisabledMessage          = "Account is disabled"
+	ExternalIdExistMessage          = "User with that externalId already exists"
+	InvalidScimFilterMessage        = "Filter is not valid or uses an unsupported attribute"
+	InvalidScimPatchMessage         = "Patch operation is not valid or uses an unsupported path"
+	ScimResourceNotFoundMessage     = "Resource with that id does not exist"
+	InvalidRedirectUrisMessage      = "Redirect URIs must be absolute https or loopback http URIs without a fragment"
+	InvalidCursorMessage            = "Cursor is not valid"
+	InvalidPatchMessage             = "Patch is not valid or changes a field that cannot be updated"
+	PatchTestFailedMessage          = "Patch test operation failed"
+	UnsupportedMediaTypeMessage     = "Content type is not supported"
+	InvalidEmailChangeTokenMessage  = "Email change token is invalid or expired"
+	PreconditionFailedMessage       = "User has been modified since it was read"
+	PreconditionRequiredMessage     = "If-Match header is required"
+)
+
+//Token Types
+const (
+	BearerTokenType = "Bearer"
+)
+
+//Roles
+const (
+	AdminRole = "admin"
+	UserRole  = "user"
+)
+
+//User Statuses
+const (
+	ActiveUserStatus     = "active"
+	DisabledUserStatus   = "disabled"
+	UnverifiedUserStatus = "unverified"
+)
+
+//User Sort Fields
+const (
+	NameSortField      = "name"
+	EmailSortField     = "email"
+	CreatedAtSortField = "createdAt"
+)
+
+//Patch Content Types
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JsonPatchContentType  = "application/json-patch+json"
+)
+
+//Pagination Modes
+const (
+	OffsetPagination = "offset"
+	CursorPagination = "cursor"
+)
+
+//Permissions
+const (
+	ReadUsersPermission          = "users:read"
+	ListUsersPermission          = "users:list"
+	UpdateUsersPermission        = "users:update"
+	DeleteUsersPermission        = "users:delete"
+	ManageRolesPermission        = "roles:manage"
+	ResetTwoFactorPermission     = "two_factor:reset"
+	UnlockUsersPermission        = "users:unlock"
+	ExpirePasswordsPermission    = "passwords:expire"
+	ManageApiKeysPermission      = "api_keys:manage"
+	ManageOAuthClientsPermission = "oauth_clients:manage"
+	ManageSessionsPermission     = "sessions:manage"
+	ImpersonateUsersPermission   = "users:impersonate"
+	ProvisionUsersPermission     = "users:provision"
+	RestoreUsersPermission       = "users:restore"
+)
+
+//API Keys
+const (
+	ApiKeyHeader = "X-API-Key"
+	ApiKeyPrefix = "umk"
+)
+
+//OAuth
+const (
+	CodeResponseType           = "code"
+	AuthorizationCodeGrantType = "authorization_code"
+	RefreshTokenGrantType      = "refresh_token"
+	S256CodeChallengeMethod    = "S256"
+	OpenIdScope                = "openid"
+	ProfileScope               = "profile"
+	EmailScope                 = "email"
+)
+
+//OAuth Errors
+const (
+	OAuthInvalidRequestError          = "invalid_request"
+	OAuthInvalidClientError           = "invalid_client"
+	OAuthInvalidGrantError            = "invalid_grant"
+	OAuthInvalidScopeError            = "invalid_scope"
+	OAuthAccessDeniedError            = "access_denied"
+	OAuthUnsupportedGrantTypeError    = "unsupported_grant_type"
+	OAuthUnsupportedResponseTypeError = "unsupported_response_type"
+	OAuthServerError                  = "server_error"
+)
+
+//SCIM
+const (
+	ScimContentType                 = "application/scim+json"
+	ScimUserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimPatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ScimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ScimResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	ScimSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	ScimUserResourceType            = "User"
+)
+
+//SCIM Errors
+const (
+	ScimInvalidFilterError = "invalidFilter"
+	ScimInvalidSyntaxError = "invalidSyntax"
+	ScimInvalidPathError   = "invalidPath"
+	ScimInvalidValueError  = "invalidValue"
+	ScimUniquenessError    = "uniqueness"
+)
+
+//Token Scopes
+const (
+	PasswordChangeScope = "password_change"
+)
+
+//Token Purposes
+const (
+	EmailVerificationPurpose = "email_verification"
+	EmailChangePurpose       = "email_change"
 )
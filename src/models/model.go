@@ -1,6 +1,9 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
 
 type AddUserModel struct {
 	Name     string `json:"name"`
@@ -18,16 +21,46 @@ type UpdateUserModel struct {
 	Id       string `json:"id"`
 	Name     string `json:"name"`
 	Password string `json:"password"`
+	// Version is the version from the If-Match header; the update fails when
+	// the user has changed since. Nil updates whatever version is current.
+	Version *int64 `json:"-"`
+}
+
+// PatchUserModel is a partial update of the user, given either as a JSON Merge
+// Patch document or as JSON Patch operations.
+type PatchUserModel struct {
+	Id         string
+	MergePatch map[string]interface{}
+	Operations []JsonPatchOperationModel
+	Version    *int64
+}
+
+type JsonPatchOperationModel struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
 }
 
 type UpdateUserResponseModel struct {
-	Id    string `json:"id"`
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	Id      string `json:"id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
 }
 
 type DeleteUserModel struct {
-	Id string `json:"id"`
+	Id      string `json:"id"`
+	Version *int64 `json:"-"`
+}
+
+type RestoreUserModel struct {
+	Id string `json:"-"`
+}
+
+type ListDeletedUsersModel struct {
+	Page  int `form:"page"`
+	Limit int `form:"limit"`
 }
 
 type GetUserModel struct {
@@ -35,19 +68,648 @@ type GetUserModel struct {
 }
 
 type GetUserResponseModel struct {
-	Id    primitive.ObjectID `bson:"_id" json:"id"`
-	Name  string             `json:"name"`
-	Email string             `json:"email"`
+	Id                primitive.ObjectID `bson:"_id" json:"id"`
+	Name              string             `json:"name" bson:"Name"`
+	Email             string             `json:"email" bson:"Email"`
+	Roles             []string           `json:"roles" bson:"Roles"`
+	EmailVerified     bool               `json:"emailVerified" bson:"EmailVerified"`
+	PendingEmail      string             `json:"pendingEmail,omitempty" bson:"PendingEmail"`
+	TwoFactorEnabled  bool               `json:"twoFactorEnabled" bson:"TwoFactorEnabled"`
+	Disabled          bool               `json:"disabled" bson:"Disabled"`
+	CreatedAt         time.Time          `json:"createdAt" bson:"-"`
+	PasswordExpiresAt *time.Time         `json:"passwordExpiresAt,omitempty" bson:"-"`
+	DeletedAt         *time.Time         `json:"deletedAt,omitempty" bson:"DeletedAt,omitempty"`
+	Version           int64              `json:"version" bson:"Version"`
+}
+
+type ListUsersModel struct {
+	Page        int    `form:"page"`
+	Limit       int    `form:"limit"`
+	Sort        string `form:"sort"`
+	Name        string `form:"name"`
+	EmailDomain string `form:"emailDomain"`
+	Status      string `form:"status"`
+	Pagination  string `form:"pagination"`
+	Cursor      string `form:"cursor"`
+}
+
+// UserListResponseModel is one page of users. Total and Page are only set in
+// offset mode; in cursor mode NextCursor and PrevCursor lead to the pages
+// around this one.
+type UserListResponseModel struct {
+	Items      []GetUserResponseModel `json:"items"`
+	Total      *int64                 `json:"total,omitempty"`
+	Page       int                    `json:"page,omitempty"`
+	Limit      int                    `json:"limit"`
+	NextCursor string                 `json:"nextCursor,omitempty"`
+	PrevCursor string                 `json:"prevCursor,omitempty"`
+	Links      PaginationLinksModel   `json:"links"`
+}
+
+type SearchUsersModel struct {
+	Query string `form:"q"`
+	Page  int    `form:"page"`
+	Limit int    `form:"limit"`
+}
+
+// UserSearchResultModel is a user found by a search. Score is the text search
+// relevance and Highlights holds the matched fields with the matches wrapped
+// in <em> tags.
+type UserSearchResultModel struct {
+	GetUserResponseModel `bson:",inline"`
+	Score                float64           `json:"score,omitempty" bson:"Score,omitempty"`
+	Highlights           map[string]string `json:"highlights,omitempty" bson:"-"`
+}
+
+type UserSearchResponseModel struct {
+	Items []UserSearchResultModel `json:"items"`
+	Total int64                   `json:"total"`
+	Page  int                     `json:"page"`
+	Limit int                     `json:"limit"`
+	Links PaginationLinksModel    `json:"links"`
+}
+
+type PaginationLinksModel struct {
+	Self  string `json:"self"`
+	First string `json:"first,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
+type ChangePasswordModel struct {
+	Id              string `json:"-"`
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type ExpirePasswordModel struct {
+	Id string `json:"-"`
+}
+
+type VerifyEmailModel struct {
+	Token string `json:"token" form:"token"`
+}
+
+type ResendVerificationModel struct {
+	Email string `json:"email"`
+}
+
+type EmailChangeModel struct {
+	Id    string `json:"-"`
+	Email string `json:"email"`
+}
+
+type UserRoleModel struct {
+	Id   string `json:"-"`
+	Role string `json:"role"`
+}
+
+type TwoFactorModel struct {
+	Id string `json:"-"`
+}
+
+type TwoFactorCodeModel struct {
+	Id   string `json:"-"`
+	Code string `json:"code"`
+}
+
+type TwoFactorEnrollmentResponseModel struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type RecoveryCodesResponseModel struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type LoginModel struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Code      string `json:"code"`
+	ClientIp  string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type LoginResponseModel struct {
+	AccessToken            string    `json:"accessToken"`
+	TokenType              string    `json:"tokenType"`
+	ExpiresIn              int64     `json:"expiresIn"`
+	ExpiresAt              time.Time `json:"expiresAt"`
+	RefreshToken           string    `json:"refreshToken"`
+	RefreshTokenExpiresAt  time.Time `json:"refreshTokenExpiresAt"`
+	Issuer                 string    `json:"issuer"`
+	Audience               []string  `json:"audience"`
+	UserId                 string    `json:"userId"`
+	Scope                  string    `json:"scope,omitempty"`
+	PasswordChangeRequired bool      `json:"passwordChangeRequired,omitempty"`
+	ImpersonatedBy         string    `json:"impersonatedBy,omitempty"`
+}
+
+type ImpersonateModel struct {
+	UserId     string `json:"-"`
+	ActorId    string `json:"-"`
+	ActorEmail string `json:"-"`
+	Reason     string `json:"reason"`
+}
+
+type RefreshTokenModel struct {
+	RefreshToken string `json:"refreshToken"`
+	ClientIp     string `json:"-"`
+	UserAgent    string `json:"-"`
+}
+
+// ClientInfoModel describes the client a session was started or last used
+// from. ClientId is the OAuth client the tokens are issued to, zero for the
+// service's own login.
+type ClientInfoModel struct {
+	ClientIp  string
+	UserAgent string
+	ClientId  primitive.ObjectID
+}
+
+type ListSessionsModel struct {
+	UserId           string `json:"-"`
+	CurrentSessionId string `json:"-"`
+}
+
+type RevokeSessionModel struct {
+	UserId string `json:"-"`
+	Id     string `json:"-"`
+}
+
+type RevokeSessionsModel struct {
+	UserId           string `json:"-"`
+	CurrentSessionId string `json:"-"`
+}
+
+type SessionResponseModel struct {
+	Id         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"userAgent"`
+	ClientIp   string    `json:"clientIp"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Current    bool      `json:"current"`
+}
+
+type LogoutModel struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type ForgotPasswordModel struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordModel struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type MagicLinkModel struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkLoginModel struct {
+	Token     string `json:"token"`
+	Code      string `json:"code"`
+	ClientIp  string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type NotificationMessage struct {
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Principal struct {
+	UserId      string
+	Email       string
+	TokenId     string
+	Roles       []string
+	Permissions []string
+	Scope       string
+	SessionId   string
+
+	// ActorId is the admin acting through an impersonation token; UserId is
+	// the impersonated user.
+	ActorId    string
+	ActorEmail string
+
+	ApiKeyId     string
+	ApiKeyScopes []string
+}
+
+type UnlockUserModel struct {
+	Id string `json:"-"`
+}
+
+type CreateApiKeyModel struct {
+	UserId    string     `json:"-"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type ListApiKeysModel struct {
+	UserId string `json:"-"`
+}
+
+type RevokeApiKeyModel struct {
+	UserId string `json:"-"`
+	Id     string `json:"-"`
+}
+
+type ApiKeyResponseModel struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type CreateApiKeyResponseModel struct {
+	ApiKeyResponseModel
+	Key string `json:"key"`
+}
+
+type CreateOAuthClientModel struct {
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirectUris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type OAuthClientModel struct {
+	Id string `json:"-"`
+}
+
+type OAuthClientResponseModel struct {
+	ClientId     string    `json:"clientId"`
+	ClientSecret string    `json:"clientSecret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectUris []string  `json:"redirectUris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type AuthorizeModel struct {
+	ResponseType        string `form:"response_type"`
+	ClientId            string `form:"client_id"`
+	RedirectUri         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	UserId              string `form:"-"`
+	ClientIp            string `form:"-"`
+	UserAgent           string `form:"-"`
+}
+
+type AuthorizeResponseModel struct {
+	RedirectUrl string
+}
+
+type OAuthTokenModel struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientId     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	ClientIp     string `form:"-"`
+	UserAgent    string `form:"-"`
+}
+
+type OAuthTokenResponseModel struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type OAuthErrorResponseModel struct {
+	Error string `json:"error"`
+}
+
+type UserInfoModel struct {
+	UserId string
+}
+
+type UserInfoResponseModel struct {
+	Sub           string `json:"sub"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+type OpenIdConfigurationModel struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type JwksModel struct {
+	Keys []JwkModel `json:"keys"`
+}
+
+type JwkModel struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type ScimUserModel struct {
+	Schemas     []string         `json:"schemas"`
+	Id          string           `json:"id,omitempty"`
+	ExternalId  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *ScimNameModel   `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []ScimEmailModel `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Password    string           `json:"password,omitempty"`
+	Meta        *ScimMetaModel   `json:"meta,omitempty"`
+}
+
+type ScimNameModel struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimEmailModel struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimMetaModel struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+type ScimListModel struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      *int   `form:"count"`
+}
+
+type ScimListResponseModel struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimPatchModel struct {
+	Schemas    []string                  `json:"schemas"`
+	Operations []ScimPatchOperationModel `json:"Operations"`
+}
+
+type ScimPatchOperationModel struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type ScimErrorModel struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+type ScimServiceProviderConfigModel struct {
+	Schemas               []string                  `json:"schemas"`
+	DocumentationUri      string                    `json:"documentationUri,omitempty"`
+	Patch                 ScimSupportedModel        `json:"patch"`
+	Bulk                  ScimBulkModel             `json:"bulk"`
+	Filter                ScimFilterSupportModel    `json:"filter"`
+	ChangePassword        ScimSupportedModel        `json:"changePassword"`
+	Sort                  ScimSupportedModel        `json:"sort"`
+	Etag                  ScimSupportedModel        `json:"etag"`
+	AuthenticationSchemes []ScimAuthenticationModel `json:"authenticationSchemes"`
+	Meta                  *ScimMetaModel            `json:"meta,omitempty"`
+}
+
+type ScimSupportedModel struct {
+	Supported bool `json:"supported"`
+}
+
+type ScimBulkModel struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type ScimFilterSupportModel struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type ScimAuthenticationModel struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+type ScimResourceTypeModel struct {
+	Schemas     []string       `json:"schemas"`
+	Id          string         `json:"id"`
+	Name        string         `json:"name"`
+	Endpoint    string         `json:"endpoint"`
+	Description string         `json:"description,omitempty"`
+	Schema      string         `json:"schema"`
+	Meta        *ScimMetaModel `json:"meta,omitempty"`
+}
+
+type ScimSchemaModel struct {
+	Schemas     []string                   `json:"schemas"`
+	Id          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	Attributes  []ScimSchemaAttributeModel `json:"attributes"`
+	Meta        *ScimMetaModel             `json:"meta,omitempty"`
+}
+
+type ScimSchemaAttributeModel struct {
+	Name          string                     `json:"name"`
+	Type          string                     `json:"type"`
+	MultiValued   bool                       `json:"multiValued"`
+	Description   string                     `json:"description,omitempty"`
+	Required      bool                       `json:"required"`
+	CaseExact     bool                       `json:"caseExact"`
+	Mutability    string                     `json:"mutability"`
+	Returned      string                     `json:"returned"`
+	Uniqueness    string                     `json:"uniqueness"`
+	SubAttributes []ScimSchemaAttributeModel `json:"subAttributes,omitempty"`
 }
 
 type ErrorModel struct {
-	Error      string `json:"error"`
-	StatusCode int    `json:"-"`
+	Error      string        `json:"error"`
+	StatusCode int           `json:"-"`
+	RetryAfter time.Duration `json:"-"`
 }
 
 type UserEntity struct {
-	Id       primitive.ObjectID `bson:"_id" json:"id"`
-	Name     string             `json:"name" bson:"Name"`
-	Password string             `json:"password" bson:"Password"`
-	Email    string             `json:"email" bson:"Email"`
+	Id              primitive.ObjectID `bson:"_id" json:"id"`
+	Name            string             `json:"name" bson:"Name"`
+	Password        string             `json:"password" bson:"Password"`
+	PasswordHistory []string           `json:"-" bson:"PasswordHistory,omitempty"`
+	Email           string             `json:"email" bson:"Email"`
+	GivenName       string             `json:"-" bson:"GivenName,omitempty"`
+	FamilyName      string             `json:"-" bson:"FamilyName,omitempty"`
+	ExternalId      string             `json:"-" bson:"ExternalId,omitempty"`
+	Disabled        bool               `json:"-" bson:"Disabled,omitempty"`
+	Roles           []string           `json:"roles" bson:"Roles"`
+	Permissions     []string           `json:"permissions" bson:"Permissions,omitempty"`
+	EmailVerified   bool               `json:"emailVerified" bson:"EmailVerified"`
+	EmailVerifiedAt *time.Time         `json:"emailVerifiedAt" bson:"EmailVerifiedAt,omitempty"`
+	// PendingEmail is the address the user asked to change to. Email stays in
+	// use until the new address is confirmed.
+	PendingEmail string `json:"-" bson:"PendingEmail,omitempty"`
+	// DeletedAt is set when the user is soft deleted. Deleted users are hidden
+	// and cannot sign in until restored, and are purged after the retention
+	// period.
+	DeletedAt *time.Time `json:"-" bson:"DeletedAt,omitempty"`
+	// Version is incremented by every write to the user and is returned as
+	// the ETag, so clients can detect concurrent changes. Users written
+	// before versions were tracked have none, which reads as 0.
+	Version int64 `json:"-" bson:"Version"`
+
+	PasswordChangedAt  *time.Time `json:"passwordChangedAt" bson:"PasswordChangedAt,omitempty"`
+	MustChangePassword bool       `json:"mustChangePassword" bson:"MustChangePassword"`
+
+	TwoFactorEnabled       bool     `json:"twoFactorEnabled" bson:"TwoFactorEnabled"`
+	TwoFactorSecret        string   `json:"-" bson:"TwoFactorSecret,omitempty"`
+	TwoFactorPendingSecret string   `json:"-" bson:"TwoFactorPendingSecret,omitempty"`
+	TwoFactorLastUsedStep  int64    `json:"-" bson:"TwoFactorLastUsedStep,omitempty"`
+	RecoveryCodes          []string `json:"-" bson:"RecoveryCodes,omitempty"`
+}
+
+type RefreshTokenEntity struct {
+	Id         primitive.ObjectID  `bson:"_id"`
+	UserId     primitive.ObjectID  `bson:"UserId"`
+	FamilyId   primitive.ObjectID  `bson:"FamilyId"`
+	ClientId   primitive.ObjectID  `bson:"ClientId,omitempty"`
+	TokenHash  string              `bson:"TokenHash"`
+	CreatedAt  time.Time           `bson:"CreatedAt"`
+	ExpiresAt  time.Time           `bson:"ExpiresAt"`
+	UsedAt     *time.Time          `bson:"UsedAt,omitempty"`
+	ReplacedBy *primitive.ObjectID `bson:"ReplacedBy,omitempty"`
+	RevokedAt  *time.Time          `bson:"RevokedAt,omitempty"`
+}
+
+// SessionEntity describes the login that started a refresh token family and
+// shares the family's id. A session is active while its family still has a
+// refresh token that is unused, unrevoked and unexpired.
+type SessionEntity struct {
+	Id         primitive.ObjectID `bson:"_id"`
+	UserId     primitive.ObjectID `bson:"UserId"`
+	Device     string             `bson:"Device"`
+	UserAgent  string             `bson:"UserAgent"`
+	ClientIp   string             `bson:"ClientIp"`
+	CreatedAt  time.Time          `bson:"CreatedAt"`
+	LastSeenAt time.Time          `bson:"LastSeenAt"`
+}
+
+type PasswordResetTokenEntity struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	UserId    primitive.ObjectID `bson:"UserId"`
+	TokenHash string             `bson:"TokenHash"`
+	CreatedAt time.Time          `bson:"CreatedAt"`
+	ExpiresAt time.Time          `bson:"ExpiresAt"`
+	UsedAt    *time.Time         `bson:"UsedAt,omitempty"`
+}
+
+// MagicLinkTokenEntity records every sign-in link request, so requests for
+// unknown emails count towards the rate limit too. Only requests for an
+// existing user carry a token hash.
+type MagicLinkTokenEntity struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Email     string             `bson:"Email"`
+	UserId    primitive.ObjectID `bson:"UserId,omitempty"`
+	TokenHash string             `bson:"TokenHash,omitempty"`
+	CreatedAt time.Time          `bson:"CreatedAt"`
+	ExpiresAt time.Time          `bson:"ExpiresAt"`
+	UsedAt    *time.Time         `bson:"UsedAt,omitempty"`
+}
+
+// LoginAttemptEntity counts recent failed logins for one key, either an
+// account email or a client IP.
+type LoginAttemptEntity struct {
+	Id            string     `bson:"_id"`
+	Failures      int        `bson:"Failures"`
+	LastFailureAt time.Time  `bson:"LastFailureAt"`
+	LockedUntil   *time.Time `bson:"LockedUntil,omitempty"`
+}
+
+// ApiKeyEntity stores an API key by the hash of its secret. Prefix is the
+// public part of the key used to find the entity without the secret.
+type ApiKeyEntity struct {
+	Id         primitive.ObjectID `bson:"_id"`
+	UserId     primitive.ObjectID `bson:"UserId"`
+	Name       string             `bson:"Name"`
+	Prefix     string             `bson:"Prefix"`
+	KeyHash    string             `bson:"KeyHash"`
+	Scopes     []string           `bson:"Scopes"`
+	CreatedAt  time.Time          `bson:"CreatedAt"`
+	ExpiresAt  time.Time          `bson:"ExpiresAt"`
+	LastUsedAt *time.Time         `bson:"LastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"RevokedAt,omitempty"`
+}
+
+// OAuthClientEntity is an application registered to sign users in through
+// the OAuth endpoints. Public clients have no secret and rely on PKCE alone.
+type OAuthClientEntity struct {
+	Id           primitive.ObjectID `bson:"_id"`
+	Name         string             `bson:"Name"`
+	SecretHash   string             `bson:"SecretHash,omitempty"`
+	RedirectUris []string           `bson:"RedirectUris"`
+	CreatedAt    time.Time          `bson:"CreatedAt"`
+}
+
+type AuthorizationCodeEntity struct {
+	Id            primitive.ObjectID `bson:"_id"`
+	CodeHash      string             `bson:"CodeHash"`
+	ClientId      primitive.ObjectID `bson:"ClientId"`
+	UserId        primitive.ObjectID `bson:"UserId"`
+	RedirectUri   string             `bson:"RedirectUri"`
+	Scope         string             `bson:"Scope"`
+	Nonce         string             `bson:"Nonce,omitempty"`
+	CodeChallenge string             `bson:"CodeChallenge"`
+	ClientIp      string             `bson:"ClientIp,omitempty"`
+	UserAgent     string             `bson:"UserAgent,omitempty"`
+	CreatedAt     time.Time          `bson:"CreatedAt"`
+	ExpiresAt     time.Time          `bson:"ExpiresAt"`
+	UsedAt        *time.Time         `bson:"UsedAt,omitempty"`
+	// RedirectUriRequired is set when the authorization request named the
+	// redirect URI, which the token request must then repeat.
+	RedirectUriRequired bool `bson:"RedirectUriRequired,omitempty"`
 }
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const (
+	defaultApiKeyTtl    = 90 * 24 * time.Hour
+	defaultApiKeyMaxTtl = 365 * 24 * time.Hour
+)
+
+type IApiKeyService interface {
+	Create(context context.Context, model models.CreateApiKeyModel) (
+		responseModel models.CreateApiKeyResponseModel, errorModel *models.ErrorModel)
+	List(context context.Context, model models.ListApiKeysModel) (
+		responseModel []models.ApiKeyResponseModel, errorModel *models.ErrorModel)
+	Revoke(context context.Context, model models.RevokeApiKeyModel) (errorModel *models.ErrorModel)
+	Authenticate(context context.Context, key string) (principal models.Principal, errorModel *models.ErrorModel)
+}
+
+// ApiKeyService issues long-lived keys for services that cannot log in
+// interactively. A key acts as the user it was issued to, limited to its
+// scopes; the key itself is only shown once and stored hashed. There are no
+// separate service accounts: a service gets a key issued to a user created
+// for it.
+type ApiKeyService struct {
+	validator             validators.IUserValidator
+	defaultTtl            time.Duration
+	maxTtl                time.Duration
+	allowCredentialScopes bool
+	logger                *logrus.Logger
+}
+
+func NewApiKeyService(validator validators.IUserValidator, config configuration.ApiKeyConfigurations,
+	logger *logrus.Logger) *ApiKeyService {
+	service := &ApiKeyService{validator: validator, defaultTtl: config.DefaultTtl, maxTtl: config.MaxTtl,
+		allowCredentialScopes: config.AllowCredentialScopes, logger: logger}
+	if service.maxTtl <= 0 {
+		service.maxTtl = defaultApiKeyMaxTtl
+	}
+	if service.defaultTtl <= 0 {
+		service.defaultTtl = defaultApiKeyTtl
+	}
+	if service.defaultTtl > service.maxTtl {
+		service.defaultTtl = service.maxTtl
+	}
+	return service
+}
+
+func (c *ApiKeyService) Create(context context.Context, model models.CreateApiKeyModel) (
+	responseModel models.CreateApiKeyResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateCreateApiKeyModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	for _, scope := range model.Scopes {
+		if !c.scopeAllowed(scope) {
+			c.logger.
+				WithField("Scope", scope).
+				WithField("Service", "ApiKeyService").
+				WithField("Method", "Create").
+				Warn("Unknown API key scope")
+			return responseModel, &models.ErrorModel{
+				Error:      models.InvalidApiKeyScopeMessage,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(c.defaultTtl)
+
+	if model.ExpiresAt != nil {
+		expiresAt = model.ExpiresAt.UTC()
+
+		if !expiresAt.After(now) || expiresAt.After(now.Add(c.maxTtl)) {
+			return responseModel, &models.ErrorModel{
+				Error:      models.InvalidApiKeyExpiryMessage,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+
+	count, err := helpers.UserCollection.CountDocuments(context, bson.D{{"_id", userId}, activeUser})
+
+	if err != nil {
+		return responseModel, c.internalError("Create", "CountDocuments", err)
+	}
+
+	if count == 0 {
+		return responseModel, &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	key, prefix, err := helpers.GenerateApiKey()
+
+	if err != nil {
+		return responseModel, c.internalError("Create", "GenerateApiKey", err)
+	}
+
+	apiKeyEntity := models.ApiKeyEntity{
+		Id:        primitive.NewObjectID(),
+		UserId:    userId,
+		Name:      model.Name,
+		Prefix:    prefix,
+		KeyHash:   helpers.HashOpaqueToken(key),
+		Scopes:    model.Scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	_, err = helpers.ApiKeyCollection.InsertOne(context, apiKeyEntity)
+
+	if err != nil {
+		return responseModel, c.internalError("Create", "InsertOne", err)
+	}
+
+	c.logger.
+		WithField("UserId", model.UserId).
+		WithField("ApiKeyId", apiKeyEntity.Id.Hex()).
+		WithField("Scopes", model.Scopes).
+		WithField("Service", "ApiKeyService").
+		WithField("Method", "Create").
+		Info("API key created")
+
+	return models.CreateApiKeyResponseModel{
+		ApiKeyResponseModel: toApiKeyResponseModel(apiKeyEntity),
+		Key:                 key,
+	}, nil
+}
+
+// List returns the user's keys that have not been revoked, including
+// expired ones so they can be recognised and cleaned up.
+func (c *ApiKeyService) List(context context.Context, model models.ListApiKeysModel) (
+	responseModel []models.ApiKeyResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateListApiKeysModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+
+	cursor, err := helpers.ApiKeyCollection.Find(context,
+		bson.D{{"UserId", userId}, {"RevokedAt", nil}},
+		options.Find().SetSort(bson.D{{"CreatedAt", 1}}))
+
+	if err != nil {
+		return responseModel, c.internalError("List", "Find", err)
+	}
+
+	var apiKeys []models.ApiKeyEntity
+
+	if err = cursor.All(context, &apiKeys); err != nil {
+		return responseModel, c.internalError("List", "All", err)
+	}
+
+	responseModel = []models.ApiKeyResponseModel{}
+
+	for _, apiKey := range apiKeys {
+		responseModel = append(responseModel, toApiKeyResponseModel(apiKey))
+	}
+
+	return responseModel, nil
+}
+
+func (c *ApiKeyService) Revoke(context context.Context, model models.RevokeApiKeyModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateRevokeApiKeyModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	updateResult, err := helpers.ApiKeyCollection.UpdateOne(context,
+		bson.D{{"_id", objID}, {"UserId", userId}, {"RevokedAt", nil}},
+		bson.D{{"$set", bson.D{{"RevokedAt", time.Now().UTC()}}}})
+
+	if err != nil {
+		return c.internalError("Revoke", "UpdateOne", err)
+	}
+
+	if updateResult.MatchedCount == 0 {
+		return &models.ErrorModel{
+			Error:      models.ApiKeyNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	c.logger.
+		WithField("UserId", model.UserId).
+		WithField("ApiKeyId", model.Id).
+		WithField("Service", "ApiKeyService").
+		WithField("Method", "Revoke").
+		Info("API key revoked")
+
+	return nil
+}
+
+// Authenticate resolves a key to the principal of its user. The user's roles
+// and permissions are read on every call, so revoking them also limits the
+// user's keys immediately.
+func (c *ApiKeyService) Authenticate(context context.Context, key string) (principal models.Principal,
+	errorModel *models.ErrorModel) {
+
+	invalidKey := &models.ErrorModel{
+		Error:      models.UnauthorizedErrorMessage,
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	prefix, ok := helpers.ParseApiKeyPrefix(key)
+
+	if !ok {
+		return principal, invalidKey
+	}
+
+	now := time.Now().UTC()
+
+	var apiKeyEntity models.ApiKeyEntity
+
+	err := helpers.ApiKeyCollection.FindOne(context, bson.D{
+		{"Prefix", prefix},
+		{"RevokedAt", nil},
+		{"ExpiresAt", bson.D{{"$gt", now}}}}).
+		Decode(&apiKeyEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return principal, invalidKey
+	}
+
+	if err != nil {
+		return principal, c.internalError("Authenticate", "FindOne", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKeyEntity.KeyHash), []byte(helpers.HashOpaqueToken(key))) != 1 {
+		return principal, invalidKey
+	}
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOne(context, bson.D{{"_id", apiKeyEntity.UserId}, activeUser}).
+		Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments {
+		c.logger.
+			WithField("UserId", apiKeyEntity.UserId.Hex()).
+			WithField("ApiKeyId", apiKeyEntity.Id.Hex()).
+			WithField("Service", "ApiKeyService").
+			WithField("Method", "Authenticate").
+			Warn("UserNotFound")
+		return principal, invalidKey
+	}
+
+	if err != nil {
+		return principal, c.internalError("Authenticate", "FindOne", err)
+	}
+
+	if userEntity.Disabled {
+		c.logger.
+			WithField("UserId", apiKeyEntity.UserId.Hex()).
+			WithField("ApiKeyId", apiKeyEntity.Id.Hex()).
+			WithField("Service", "ApiKeyService").
+			WithField("Method", "Authenticate").
+			Warn("Account disabled")
+		return principal, invalidKey
+	}
+
+	// Usage tracking must not fail the request it is tracking.
+	_, err = helpers.ApiKeyCollection.UpdateOne(context, bson.D{{"_id", apiKeyEntity.Id}},
+		bson.D{{"$set", bson.D{{"LastUsedAt", now}}}})
+
+	if err != nil {
+		c.internalError("Authenticate", "UpdateOne", err)
+	}
+
+	// Keys created while credential scopes were allowed lose them once the
+	// configuration stops allowing them.
+	scopes := []string{}
+
+	for _, scope := range apiKeyEntity.Scopes {
+		if c.scopeAllowed(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return models.Principal{
+		UserId:       userEntity.Id.Hex(),
+		Email:        userEntity.Email,
+		Roles:        userEntity.Roles,
+		Permissions:  userEntity.Permissions,
+		ApiKeyId:     apiKeyEntity.Id.Hex(),
+		ApiKeyScopes: scopes,
+	}, nil
+}
+
+func (c *ApiKeyService) scopeAllowed(scope string) bool {
+	return containsString(ApiKeyScopes, scope) ||
+		(c.allowCredentialScopes && containsString(CredentialApiKeyScopes, scope))
+}
+
+func (c *ApiKeyService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "ApiKeyService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
+
+func toApiKeyResponseModel(apiKeyEntity models.ApiKeyEntity) models.ApiKeyResponseModel {
+	return models.ApiKeyResponseModel{
+		Id:         apiKeyEntity.Id.Hex(),
+		Name:       apiKeyEntity.Name,
+		Prefix:     apiKeyEntity.Prefix,
+		Scopes:     apiKeyEntity.Scopes,
+		CreatedAt:  apiKeyEntity.CreatedAt,
+		ExpiresAt:  apiKeyEntity.ExpiresAt,
+		LastUsedAt: apiKeyEntity.LastUsedAt,
+	}
+}
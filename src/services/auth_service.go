@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"user-management-service/src/configuration"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+type IAuthService interface {
+	Login(context context.Context, model models.LoginModel) (responseModel models.LoginResponseModel,
+		errorModel *models.ErrorModel)
+	Refresh(context context.Context, model models.RefreshTokenModel) (responseModel models.LoginResponseModel,
+		errorModel *models.ErrorModel)
+	Logout(context context.Context, model models.LogoutModel) (errorModel *models.ErrorModel)
+}
+
+type AuthService struct {
+	validator            validators.IAuthValidator
+	userService          IUserService
+	tokenService         ITokenService
+	twoFactorService     ITwoFactorService
+	loginAttemptService  ILoginAttemptService
+	requireVerifiedEmail bool
+	logger               *logrus.Logger
+}
+
+func NewAuthService(validator validators.IAuthValidator, userService IUserService, tokenService ITokenService,
+	twoFactorService ITwoFactorService, loginAttemptService ILoginAttemptService,
+	emailVerificationConfig configuration.EmailVerificationConfigurations, logger *logrus.Logger) *AuthService {
+	return &AuthService{validator: validator, userService: userService, tokenService: tokenService,
+		twoFactorService: twoFactorService, loginAttemptService: loginAttemptService,
+		requireVerifiedEmail: emailVerificationConfig.Required, logger: logger}
+}
+
+func (c *AuthService) Login(context context.Context, model models.LoginModel) (responseModel models.
+	LoginResponseModel,
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateLoginModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	error = c.loginAttemptService.Check(context, model.Email, model.ClientIp)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	userEntity, error := c.userService.VerifyCredentials(context, model.Email, model.Password)
+
+	if error == nil && userEntity.TwoFactorEnabled {
+		error = c.twoFactorService.VerifyLoginCode(context, userEntity, model.Code)
+	}
+
+	if error != nil {
+		// A missing second factor is a prompt, not a failed guess.
+		if error.StatusCode == http.StatusUnauthorized && error.Error != models.TwoFactorRequiredMessage {
+			c.loginAttemptService.RegisterFailure(context, model.Email, model.ClientIp)
+		}
+		return responseModel, error
+	}
+
+	if c.requireVerifiedEmail && !userEntity.EmailVerified {
+		c.logger.
+			WithField("Service", "AuthService").
+			WithField("Method", "Login").
+			WithField("UserId", userEntity.Id.Hex()).
+			Warn("Email not verified")
+		return responseModel, &models.ErrorModel{
+			Error:      models.EmailNotVerifiedErrorMessage,
+			StatusCode: http.StatusForbidden,
+		}
+	}
+
+	// An expired password still proves who the user is, but the session it
+	// opens may only be used to choose a new password.
+	if c.userService.PasswordExpired(userEntity) {
+		responseModel, error = c.tokenService.IssueRestrictedToken(context, userEntity, models.PasswordChangeScope)
+	} else {
+		responseModel, error = c.tokenService.IssueTokens(context, userEntity, models.ClientInfoModel{
+			ClientIp:  model.ClientIp,
+			UserAgent: model.UserAgent,
+		})
+	}
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	c.loginAttemptService.RegisterSuccess(context, model.Email)
+
+	c.logger.
+		WithField("Service", "AuthService").
+		WithField("Method", "Login").
+		WithField("UserId", userEntity.Id.Hex()).
+		Info("User logged in")
+
+	return responseModel, nil
+}
+
+func (c *AuthService) Refresh(context context.Context, model models.RefreshTokenModel) (responseModel models.
+	LoginResponseModel,
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateRefreshTokenModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	return c.tokenService.Refresh(context, model.RefreshToken, models.ClientInfoModel{
+		ClientIp:  model.ClientIp,
+		UserAgent: model.UserAgent,
+	})
+}
+
+func (c *AuthService) Logout(context context.Context, model models.LogoutModel) (errorModel *models.ErrorModel) {
+	error := c.validator.ValidateLogoutModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	return c.tokenService.Revoke(context, model.RefreshToken)
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/url"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const defaultEmailVerificationTokenTtl = 24 * time.Hour
+
+type IEmailVerificationService interface {
+	SendVerification(context context.Context, userEntity models.UserEntity) (errorModel *models.ErrorModel)
+	VerifyEmail(context context.Context, model models.VerifyEmailModel) (errorModel *models.ErrorModel)
+	ResendVerification(context context.Context, model models.ResendVerificationModel) (
+		errorModel *models.ErrorModel)
+	RequestEmailChange(context context.Context, model models.EmailChangeModel) (errorModel *models.ErrorModel)
+	ConfirmEmailChange(context context.Context, model models.VerifyEmailModel) (errorModel *models.ErrorModel)
+}
+
+// EmailVerificationService sends signed verification links. The token names
+// the address it was issued for, so a link stops working once the user's
+// email changes.
+type EmailVerificationService struct {
+	validator   validators.IUserValidator
+	tokenHelper helpers.ITokenHelper
+	sender      helpers.INotificationSender
+	tokenTtl    time.Duration
+	verifyUrl   string
+	changeUrl   string
+	logger      *logrus.Logger
+}
+
+func NewEmailVerificationService(validator validators.IUserValidator, tokenHelper helpers.ITokenHelper,
+	sender helpers.INotificationSender, config configuration.EmailVerificationConfigurations,
+	logger *logrus.Logger) *EmailVerificationService {
+	tokenTtl := config.TokenTtl
+	if tokenTtl <= 0 {
+		tokenTtl = defaultEmailVerificationTokenTtl
+	}
+	return &EmailVerificationService{validator: validator, tokenHelper: tokenHelper, sender: sender,
+		tokenTtl: tokenTtl, verifyUrl: config.VerifyUrl, changeUrl: config.ChangeUrl, logger: logger}
+}
+
+func (c *EmailVerificationService) SendVerification(context context.Context, userEntity models.UserEntity) (
+	errorModel *models.ErrorModel) {
+
+	token, err := c.tokenHelper.IssuePurposeToken(models.EmailVerificationPurpose, userEntity.Id.Hex(),
+		userEntity.Email, c.tokenTtl)
+
+	if err == nil {
+		err = c.sender.Send(context, models.NotificationMessage{
+			To:      userEntity.Email,
+			Subject: "Verify your email address",
+			Body: fmt.Sprintf("Use the link below to verify your email address. It expires in %s.\n\n%s?token=%s",
+				c.tokenTtl, c.verifyUrl, url.QueryEscape(token)),
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+
+	if err != nil {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", "SendVerification").
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "EmailVerificationService").
+		WithField("Method", "SendVerification").
+		Info("Verification email sent")
+
+	return nil
+}
+
+func (c *EmailVerificationService) VerifyEmail(context context.Context, model models.VerifyEmailModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateVerifyEmailModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	invalidToken := &models.ErrorModel{
+		Error:      models.InvalidVerificationTokenMessage,
+		StatusCode: http.StatusBadRequest,
+	}
+
+	claims, err := c.tokenHelper.ParsePurposeToken(model.Token, models.EmailVerificationPurpose)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", "VerifyEmail").
+			WithField("Operation", "ParsePurposeToken").
+			Warn("Verification token invalid or expired")
+		return invalidToken
+	}
+
+	objID, err := primitive.ObjectIDFromHex(claims.Subject)
+
+	if err != nil {
+		return invalidToken
+	}
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOneAndUpdate(context,
+		bson.D{{"_id", objID}, {"Email", claims.Email}, activeUser},
+		bson.D{{"$set", bson.D{{"EmailVerified", true}, {"EmailVerifiedAt", time.Now().UTC()}}}, incrementVersion}).
+		Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("UserId", claims.Subject).
+				WithField("Service", "EmailVerificationService").
+				WithField("Method", "VerifyEmail").
+				WithField("Operation", "FindOneAndUpdate").
+				Warn("User not found or email changed")
+			return invalidToken
+		}
+
+		c.logger.
+			WithField("UserId", claims.Subject).
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", "VerifyEmail").
+			WithField("Operation", "FindOneAndUpdate").
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	if !userEntity.EmailVerified {
+		c.logger.
+			WithField("UserId", claims.Subject).
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", "VerifyEmail").
+			Info("Email verified")
+	}
+
+	return nil
+}
+
+// ResendVerification answers the same way whether or not the email belongs
+// to an unverified account.
+func (c *EmailVerificationService) ResendVerification(context context.Context,
+	model models.ResendVerificationModel) (errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateResendVerificationModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	var userEntity models.UserEntity
+
+	err := helpers.UserCollection.FindOne(context,
+		bson.D{{"Email", model.Email}, {"EmailVerified", bson.D{{"$ne", true}}}, activeUser}).
+		Decode(&userEntity)
+
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			c.logger.
+				WithField("Service", "EmailVerificationService").
+				WithField("Method", "ResendVerification").
+				WithField("Operation", "FindOne").
+				WithField("Error", err.Error()).
+				Error("")
+		}
+		return nil
+	}
+
+	c.SendVerification(context, userEntity)
+
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to the new address and tells
+// the current one about the request. The user keeps signing in with the
+// current address until the link is used; a newer request replaces the
+// pending address and so invalidates earlier links.
+func (c *EmailVerificationService) RequestEmailChange(context context.Context, model models.EmailChangeModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateEmailChangeModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	var userEntity models.UserEntity
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	if err != nil {
+		return c.internalError("RequestEmailChange", "FindOne", err)
+	}
+
+	if model.Email == userEntity.Email {
+		c.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", "RequestEmailChange").
+			Warn("New email is the current email")
+		return &models.ErrorModel{
+			Error:      models.BadRequestErrorMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	error = c.checkEmailAvailable(context, objID, model.Email, "RequestEmailChange")
+
+	if error != nil {
+		return error
+	}
+
+	_, err = helpers.UserCollection.UpdateByID(context, objID,
+		bson.D{{"$set", bson.D{{"PendingEmail", model.Email}}}, incrementVersion})
+
+	if err != nil {
+		return c.internalError("RequestEmailChange", "UpdateByID", err)
+	}
+
+	token, err := c.tokenHelper.IssuePurposeToken(models.EmailChangePurpose, model.Id, model.Email, c.tokenTtl)
+
+	if err == nil {
+		err = c.sender.Send(context, models.NotificationMessage{
+			To:      model.Email,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf("Use the link below to make this your new email address. It expires in %s.\n\n"+
+				"%s?token=%s", c.tokenTtl, c.changeUrl, url.QueryEscape(token)),
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+
+	if err != nil {
+		return c.internalError("RequestEmailChange", "Send", err)
+	}
+
+	c.notify(context, userEntity, "Email address change requested",
+		fmt.Sprintf("A change of your email address to %s was requested. This address stays in use until "+
+			"the new one is confirmed. If you did not ask for it, change your password.", model.Email),
+		"RequestEmailChange")
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "EmailVerificationService").
+		WithField("Method", "RequestEmailChange").
+		Info("Email change requested")
+
+	return nil
+}
+
+// ConfirmEmailChange replaces the email with the pending address named by the
+// token in a single update, which also marks it verified.
+func (c *EmailVerificationService) ConfirmEmailChange(context context.Context, model models.VerifyEmailModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateVerifyEmailModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	invalidToken := &models.ErrorModel{
+		Error:      models.InvalidEmailChangeTokenMessage,
+		StatusCode: http.StatusBadRequest,
+	}
+
+	claims, err := c.tokenHelper.ParsePurposeToken(model.Token, models.EmailChangePurpose)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", "ConfirmEmailChange").
+			WithField("Operation", "ParsePurposeToken").
+			Warn("Email change token invalid or expired")
+		return invalidToken
+	}
+
+	objID, err := primitive.ObjectIDFromHex(claims.Subject)
+
+	if err != nil {
+		return invalidToken
+	}
+
+	// Another account may have taken the address since the request.
+	error = c.checkEmailAvailable(context, objID, claims.Email, "ConfirmEmailChange")
+
+	if error != nil {
+		return error
+	}
+
+	now := time.Now().UTC()
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOneAndUpdate(context,
+		bson.D{{"_id", objID}, {"PendingEmail", claims.Email}, activeUser},
+		bson.D{
+			{"$set", bson.D{{"Email", claims.Email}, {"EmailVerified", true}, {"EmailVerifiedAt", now}}},
+			{"$unset", bson.D{{"PendingEmail", ""}}},
+			incrementVersion,
+		}).
+		Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("UserId", claims.Subject).
+				WithField("Service", "EmailVerificationService").
+				WithField("Method", "ConfirmEmailChange").
+				WithField("Operation", "FindOneAndUpdate").
+				Warn("User not found or pending email changed")
+			return invalidToken
+		}
+
+		return c.internalError("ConfirmEmailChange", "FindOneAndUpdate", err)
+	}
+
+	c.notify(context, userEntity, "Email address changed",
+		fmt.Sprintf("Your email address was changed to %s. If you did not ask for it, contact support.",
+			claims.Email),
+		"ConfirmEmailChange")
+
+	c.logger.
+		WithField("UserId", claims.Subject).
+		WithField("Service", "EmailVerificationService").
+		WithField("Method", "ConfirmEmailChange").
+		Info("Email changed")
+
+	return nil
+}
+
+func (c *EmailVerificationService) checkEmailAvailable(context context.Context, userId primitive.ObjectID,
+	email string, method string) (errorModel *models.ErrorModel) {
+
+	count, err := helpers.UserCollection.CountDocuments(context,
+		bson.D{{"Email", email}, {"_id", bson.D{{"$ne", userId}}}, activeUser})
+
+	if err != nil {
+		return c.internalError(method, "CountDocuments", err)
+	}
+
+	if count > 0 {
+		c.logger.
+			WithField("UserId", userId.Hex()).
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", method).
+			WithField("Operation", "CountDocuments").
+			Warn("User already exist for the email")
+		return &models.ErrorModel{
+			Error:      models.EmailExistMessage,
+			StatusCode: http.StatusForbidden,
+		}
+	}
+
+	return nil
+}
+
+// notify sends a notice to the current address of the user. Failures are only
+// logged since the change itself already happened.
+func (c *EmailVerificationService) notify(context context.Context, userEntity models.UserEntity, subject string,
+	body string, method string) {
+
+	err := c.sender.Send(context, models.NotificationMessage{
+		To:        userEntity.Email,
+		Subject:   subject,
+		Body:      body,
+		CreatedAt: time.Now().UTC(),
+	})
+
+	if err != nil {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "EmailVerificationService").
+			WithField("Method", method).
+			WithField("Operation", "Send").
+			WithField("Error", err.Error()).
+			Error("")
+	}
+}
+
+func (c *EmailVerificationService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "EmailVerificationService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const defaultImpersonationTokenTtl = 10 * time.Minute
+
+type IImpersonationService interface {
+	Impersonate(context context.Context, model models.ImpersonateModel) (responseModel models.LoginResponseModel,
+		errorModel *models.ErrorModel)
+}
+
+// ImpersonationService lets support staff act as a user. The token it issues
+// names the admin in its "act" claim, cannot be refreshed and is refused by
+// the middleware on sensitive routes. Admins and other users who may
+// impersonate cannot be impersonated, so nobody borrows their privileges.
+type ImpersonationService struct {
+	validator   validators.IUserValidator
+	tokenHelper helpers.ITokenHelper
+	tokenTtl    time.Duration
+	logger      *logrus.Logger
+}
+
+func NewImpersonationService(validator validators.IUserValidator, tokenHelper helpers.ITokenHelper,
+	config configuration.ImpersonationConfigurations, logger *logrus.Logger) *ImpersonationService {
+	tokenTtl := config.TokenTtl
+	if tokenTtl <= 0 {
+		tokenTtl = defaultImpersonationTokenTtl
+	}
+	return &ImpersonationService{validator: validator, tokenHelper: tokenHelper, tokenTtl: tokenTtl, logger: logger}
+}
+
+func (c *ImpersonationService) Impersonate(context context.Context, model models.ImpersonateModel) (
+	responseModel models.LoginResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateImpersonateModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.UserId)
+
+	var userEntity models.UserEntity
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return responseModel, &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("FindOne", err)
+	}
+
+	if canImpersonate(userEntity) {
+		c.logger.
+			WithField("ActorId", model.ActorId).
+			WithField("UserId", model.UserId).
+			WithField("Service", "ImpersonationService").
+			WithField("Method", "Impersonate").
+			Warn("Impersonation target is privileged")
+		return responseModel, &models.ErrorModel{
+			Error:      models.AdminImpersonationMessage,
+			StatusCode: http.StatusForbidden,
+		}
+	}
+
+	accessToken, claims, err := c.tokenHelper.IssueImpersonationToken(userEntity,
+		helpers.ActorClaims{Subject: model.ActorId, Email: model.ActorEmail}, c.tokenTtl)
+
+	if err != nil {
+		return responseModel, c.internalError("IssueImpersonationToken", err)
+	}
+
+	c.logger.
+		WithField("ActorId", model.ActorId).
+		WithField("ActorEmail", model.ActorEmail).
+		WithField("UserId", model.UserId).
+		WithField("Reason", model.Reason).
+		WithField("TokenId", claims.ID).
+		WithField("ExpiresAt", claims.ExpiresAt.Time).
+		WithField("Service", "ImpersonationService").
+		WithField("Method", "Impersonate").
+		Info("Impersonation started")
+
+	return models.LoginResponseModel{
+		AccessToken:    accessToken,
+		TokenType:      models.BearerTokenType,
+		ExpiresIn:      int64(claims.ExpiresAt.Sub(claims.IssuedAt.Time).Seconds()),
+		ExpiresAt:      claims.ExpiresAt.Time,
+		Issuer:         claims.Issuer,
+		Audience:       claims.Audience,
+		UserId:         model.UserId,
+		ImpersonatedBy: model.ActorId,
+	}, nil
+}
+
+func canImpersonate(userEntity models.UserEntity) bool {
+	if containsString(userEntity.Roles, models.AdminRole) ||
+		containsString(userEntity.Permissions, models.ImpersonateUsersPermission) {
+		return true
+	}
+
+	for _, role := range userEntity.Roles {
+		if containsString(RolePermissions[role], models.ImpersonateUsersPermission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ImpersonationService) internalError(operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "ImpersonationService").
+		WithField("Method", "Impersonate").
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"strings"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const (
+	defaultAccountLockoutThreshold = 5
+	defaultIpLockoutThreshold      = 20
+	defaultLockoutBaseDelay        = time.Minute
+	defaultLockoutMaxDelay         = time.Hour
+	defaultLockoutResetAfter       = 24 * time.Hour
+)
+
+type ILoginAttemptService interface {
+	Check(context context.Context, email string, clientIp string) (errorModel *models.ErrorModel)
+	RegisterFailure(context context.Context, email string, clientIp string)
+	RegisterSuccess(context context.Context, email string)
+	Unlock(context context.Context, model models.UnlockUserModel) (errorModel *models.ErrorModel)
+}
+
+// LoginAttemptService counts failed logins per account and per client IP.
+// Once a counter reaches its threshold the key is locked, and every further
+// failure doubles the lockout up to the configured maximum.
+type LoginAttemptService struct {
+	validator        validators.IUserValidator
+	accountThreshold int
+	ipThreshold      int
+	baseDelay        time.Duration
+	maxDelay         time.Duration
+	resetAfter       time.Duration
+	logger           *logrus.Logger
+}
+
+func NewLoginAttemptService(validator validators.IUserValidator, config configuration.LockoutConfigurations,
+	logger *logrus.Logger) *LoginAttemptService {
+	service := &LoginAttemptService{validator: validator, accountThreshold: config.AccountThreshold,
+		ipThreshold: config.IpThreshold, baseDelay: config.BaseDelay, maxDelay: config.MaxDelay,
+		resetAfter: config.ResetAfter, logger: logger}
+	if service.accountThreshold <= 0 {
+		service.accountThreshold = defaultAccountLockoutThreshold
+	}
+	if service.ipThreshold <= 0 {
+		service.ipThreshold = defaultIpLockoutThreshold
+	}
+	if service.baseDelay <= 0 {
+		service.baseDelay = defaultLockoutBaseDelay
+	}
+	if service.maxDelay < service.baseDelay {
+		service.maxDelay = defaultLockoutMaxDelay
+	}
+	if service.resetAfter <= 0 {
+		service.resetAfter = defaultLockoutResetAfter
+	}
+	return service
+}
+
+// Check returns 429 with the remaining lockout when the account or the
+// client IP is locked.
+func (c *LoginAttemptService) Check(context context.Context, email string, clientIp string) (
+	errorModel *models.ErrorModel) {
+
+	now := time.Now().UTC()
+
+	cursor, err := helpers.LoginAttemptCollection.Find(context, bson.D{
+		{"_id", bson.D{{"$in", c.keys(email, clientIp)}}},
+		{"LockedUntil", bson.D{{"$gt", now}}}})
+
+	if err != nil {
+		return c.internalError("Check", "Find", err)
+	}
+
+	var attempts []models.LoginAttemptEntity
+
+	if err = cursor.All(context, &attempts); err != nil {
+		return c.internalError("Check", "All", err)
+	}
+
+	var lockedUntil time.Time
+
+	for _, attempt := range attempts {
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = *attempt.LockedUntil
+		}
+	}
+
+	if lockedUntil.IsZero() {
+		return nil
+	}
+
+	c.logger.
+		WithField("Email", email).
+		WithField("ClientIp", clientIp).
+		WithField("Service", "LoginAttemptService").
+		WithField("Method", "Check").
+		Warn("Login attempt while locked")
+
+	return &models.ErrorModel{
+		Error:      models.TooManyLoginAttemptsMessage,
+		StatusCode: http.StatusTooManyRequests,
+		RetryAfter: lockedUntil.Sub(now),
+	}
+}
+
+// RegisterFailure only logs errors; a failing counter must not change the
+// answer the caller gives for the login itself.
+func (c *LoginAttemptService) RegisterFailure(context context.Context, email string, clientIp string) {
+	c.registerFailure(context, accountKey(email), c.accountThreshold)
+
+	if clientIp != "" {
+		c.registerFailure(context, ipKey(clientIp), c.ipThreshold)
+	}
+}
+
+// RegisterSuccess clears the account counter. The IP counter is kept, so a
+// client that owns one account cannot use it to reset guesses at others.
+func (c *LoginAttemptService) RegisterSuccess(context context.Context, email string) {
+	_, err := helpers.LoginAttemptCollection.DeleteOne(context, bson.D{{"_id", accountKey(email)}})
+
+	if err != nil {
+		c.internalError("RegisterSuccess", "DeleteOne", err)
+	}
+}
+
+func (c *LoginAttemptService) Unlock(context context.Context, model models.UnlockUserModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateUnlockUserModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	var userEntity models.UserEntity
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &models.ErrorModel{
+				Error:      models.UserNotFoundErrorMessage,
+				StatusCode: http.StatusNotFound,
+			}
+		}
+		return c.internalError("Unlock", "FindOne", err)
+	}
+
+	_, err = helpers.LoginAttemptCollection.DeleteOne(context, bson.D{{"_id", accountKey(userEntity.Email)}})
+
+	if err != nil {
+		return c.internalError("Unlock", "DeleteOne", err)
+	}
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "LoginAttemptService").
+		WithField("Method", "Unlock").
+		Info("Account unlocked")
+
+	return nil
+}
+
+func (c *LoginAttemptService) registerFailure(context context.Context, key string, threshold int) {
+	now := time.Now().UTC()
+
+	// Counters without a failure inside the reset window start over.
+	_, err := helpers.LoginAttemptCollection.UpdateOne(context,
+		bson.D{{"_id", key}, {"LastFailureAt", bson.D{{"$lt", now.Add(-c.resetAfter)}}}},
+		bson.D{{"$set", bson.D{{"Failures", 0}}}, {"$unset", bson.D{{"LockedUntil", ""}}}})
+
+	if err != nil {
+		c.internalError("RegisterFailure", "UpdateOne", err)
+		return
+	}
+
+	var attempt models.LoginAttemptEntity
+
+	err = helpers.LoginAttemptCollection.FindOneAndUpdate(context,
+		bson.D{{"_id", key}},
+		bson.D{{"$inc", bson.D{{"Failures", 1}}}, {"$set", bson.D{{"LastFailureAt", now}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).
+		Decode(&attempt)
+
+	if err != nil {
+		c.internalError("RegisterFailure", "FindOneAndUpdate", err)
+		return
+	}
+
+	if attempt.Failures < threshold {
+		return
+	}
+
+	lockedUntil := now.Add(c.lockoutDelay(attempt.Failures - threshold))
+
+	_, err = helpers.LoginAttemptCollection.UpdateOne(context,
+		bson.D{{"_id", key}},
+		bson.D{{"$set", bson.D{{"LockedUntil", lockedUntil}}}})
+
+	if err != nil {
+		c.internalError("RegisterFailure", "UpdateOne", err)
+		return
+	}
+
+	c.logger.
+		WithField("Key", key).
+		WithField("Failures", attempt.Failures).
+		WithField("LockedUntil", lockedUntil).
+		WithField("Service", "LoginAttemptService").
+		WithField("Method", "RegisterFailure").
+		Warn("Locked after failed login attempts")
+}
+
+// lockoutDelay doubles the base delay for every failure past the threshold.
+func (c *LoginAttemptService) lockoutDelay(excessFailures int) time.Duration {
+	delay := c.baseDelay
+
+	for i := 0; i < excessFailures && delay < c.maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+
+	return delay
+}
+
+func (c *LoginAttemptService) keys(email string, clientIp string) bson.A {
+	keys := bson.A{accountKey(email)}
+
+	if clientIp != "" {
+		keys = append(keys, ipKey(clientIp))
+	}
+
+	return keys
+}
+
+func (c *LoginAttemptService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "LoginAttemptService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipKey(clientIp string) string {
+	return "ip:" + clientIp
+}
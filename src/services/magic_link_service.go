@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/url"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const (
+	defaultMagicLinkTokenTtl    = 15 * time.Minute
+	defaultMagicLinkMaxRequests = 3
+	defaultMagicLinkWindow      = 15 * time.Minute
+)
+
+type IMagicLinkService interface {
+	RequestLink(context context.Context, model models.MagicLinkModel) (errorModel *models.ErrorModel)
+	Login(context context.Context, model models.MagicLinkLoginModel) (responseModel models.LoginResponseModel,
+		errorModel *models.ErrorModel)
+}
+
+// MagicLinkService signs users in with a single-use link sent to their email
+// instead of a password. Like password reset tokens, only the hash of a link
+// token is stored. Like a password login, signing in counts towards the
+// lockout and only allows a password change once the password has expired.
+type MagicLinkService struct {
+	validator           validators.IAuthValidator
+	userService         IUserService
+	tokenService        ITokenService
+	twoFactorService    ITwoFactorService
+	loginAttemptService ILoginAttemptService
+	sender              helpers.INotificationSender
+	tokenTtl            time.Duration
+	loginUrl            string
+	maxRequests         int
+	window              time.Duration
+	logger              *logrus.Logger
+}
+
+func NewMagicLinkService(validator validators.IAuthValidator, userService IUserService, tokenService ITokenService,
+	twoFactorService ITwoFactorService, loginAttemptService ILoginAttemptService, sender helpers.INotificationSender,
+	config configuration.MagicLinkConfigurations, logger *logrus.Logger) *MagicLinkService {
+	service := &MagicLinkService{validator: validator, userService: userService, tokenService: tokenService,
+		twoFactorService: twoFactorService, loginAttemptService: loginAttemptService, sender: sender,
+		tokenTtl: config.TokenTtl, loginUrl: config.LoginUrl, maxRequests: config.MaxRequests, window: config.Window,
+		logger: logger}
+	if service.tokenTtl <= 0 {
+		service.tokenTtl = defaultMagicLinkTokenTtl
+	}
+	if service.maxRequests <= 0 {
+		service.maxRequests = defaultMagicLinkMaxRequests
+	}
+	if service.window <= 0 {
+		service.window = defaultMagicLinkWindow
+	}
+	return service
+}
+
+// RequestLink only fails for malformed or rate limited requests. Requests
+// for unknown emails are counted and logged but otherwise look the same, so
+// callers cannot tell whether an account exists.
+func (c *MagicLinkService) RequestLink(context context.Context, model models.MagicLinkModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateMagicLinkModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	now := time.Now().UTC()
+	// Emails are matched exactly everywhere else, so the rate limit counts
+	// the same value the account is looked up by.
+	email := model.Email
+
+	count, err := helpers.MagicLinkTokenCollection.CountDocuments(context, bson.D{
+		{"Email", email},
+		{"CreatedAt", bson.D{{"$gt", now.Add(-c.window)}}}})
+
+	if err != nil {
+		c.logError("CountDocuments", err)
+		return nil
+	}
+
+	if count >= int64(c.maxRequests) {
+		c.logger.
+			WithField("Email", email).
+			WithField("Service", "MagicLinkService").
+			WithField("Method", "RequestLink").
+			Warn("Too many sign-in links requested")
+		return &models.ErrorModel{
+			Error:      models.TooManyMagicLinksMessage,
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: c.window,
+		}
+	}
+
+	tokenEntity := models.MagicLinkTokenEntity{
+		Id:        primitive.NewObjectID(),
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.tokenTtl),
+	}
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOne(context, bson.D{{"Email", email}, activeUser}).Decode(&userEntity)
+
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.logError("FindOne", err)
+		return nil
+	}
+
+	if err == mongo.ErrNoDocuments {
+		c.logger.
+			WithField("Email", email).
+			WithField("Service", "MagicLinkService").
+			WithField("Method", "RequestLink").
+			WithField("Operation", "FindOne").
+			Warn("UserNotFound")
+
+		if _, err = helpers.MagicLinkTokenCollection.InsertOne(context, tokenEntity); err != nil {
+			c.logError("InsertOne", err)
+		}
+		return nil
+	}
+
+	token, err := helpers.GenerateOpaqueToken()
+
+	if err != nil {
+		c.logError("GenerateOpaqueToken", err)
+		return nil
+	}
+
+	_, err = helpers.MagicLinkTokenCollection.UpdateMany(context,
+		bson.D{{"UserId", userEntity.Id}, {"UsedAt", nil}},
+		bson.D{{"$set", bson.D{{"UsedAt", now}}}})
+
+	if err != nil {
+		c.logError("UpdateMany", err)
+		return nil
+	}
+
+	tokenEntity.UserId = userEntity.Id
+	tokenEntity.TokenHash = helpers.HashOpaqueToken(token)
+
+	_, err = helpers.MagicLinkTokenCollection.InsertOne(context, tokenEntity)
+
+	if err != nil {
+		c.logError("InsertOne", err)
+		return nil
+	}
+
+	err = c.sender.Send(context, models.NotificationMessage{
+		To:      userEntity.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Use the link below to sign in. It can be used once and expires in %s.\n\n%s?token=%s",
+			c.tokenTtl, c.loginUrl, url.QueryEscape(token)),
+		CreatedAt: now,
+	})
+
+	if err != nil {
+		c.logError("Send", err)
+		return nil
+	}
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "MagicLinkService").
+		WithField("Method", "RequestLink").
+		Info("Sign-in link requested")
+
+	return nil
+}
+
+// Login exchanges a link token for the usual access and refresh tokens. Users
+// with two-factor authentication still have to send their code; the link is
+// only used up once the code has been accepted.
+func (c *MagicLinkService) Login(context context.Context, model models.MagicLinkLoginModel) (
+	responseModel models.LoginResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateMagicLinkLoginModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	invalidLink := &models.ErrorModel{
+		Error:      models.InvalidMagicLinkMessage,
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	now := time.Now().UTC()
+
+	var tokenEntity models.MagicLinkTokenEntity
+
+	err := helpers.MagicLinkTokenCollection.FindOne(context, bson.D{
+		{"TokenHash", helpers.HashOpaqueToken(model.Token)},
+		{"UsedAt", nil},
+		{"ExpiresAt", bson.D{{"$gt", now}}}}).
+		Decode(&tokenEntity)
+
+	if err == mongo.ErrNoDocuments {
+		c.logger.
+			WithField("Service", "MagicLinkService").
+			WithField("Method", "Login").
+			WithField("Operation", "FindOne").
+			Warn("Sign-in link invalid, used or expired")
+		return responseModel, invalidLink
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("FindOne", err)
+	}
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOne(context, bson.D{{"_id", tokenEntity.UserId}, activeUser}).
+		Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments || (err == nil && userEntity.Disabled) {
+		return responseModel, invalidLink
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("FindOne", err)
+	}
+
+	error = c.loginAttemptService.Check(context, userEntity.Email, model.ClientIp)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	if userEntity.TwoFactorEnabled {
+		error = c.twoFactorService.VerifyLoginCode(context, userEntity, model.Code)
+
+		if error != nil {
+			// A missing second factor is a prompt, not a failed guess.
+			if error.StatusCode == http.StatusUnauthorized && error.Error != models.TwoFactorRequiredMessage {
+				c.loginAttemptService.RegisterFailure(context, userEntity.Email, model.ClientIp)
+			}
+			return responseModel, error
+		}
+	}
+
+	// Consuming the link only matches while it is unused, so concurrent
+	// requests with the same link cannot both sign in.
+	updateResult, err := helpers.MagicLinkTokenCollection.UpdateOne(context,
+		bson.D{{"_id", tokenEntity.Id}, {"UsedAt", nil}},
+		bson.D{{"$set", bson.D{{"UsedAt", now}}}})
+
+	if err != nil {
+		return responseModel, c.internalError("UpdateOne", err)
+	}
+
+	if updateResult.ModifiedCount == 0 {
+		return responseModel, invalidLink
+	}
+
+	if c.userService.PasswordExpired(userEntity) {
+		responseModel, error = c.tokenService.IssueRestrictedToken(context, userEntity, models.PasswordChangeScope)
+	} else {
+		responseModel, error = c.tokenService.IssueTokens(context, userEntity, models.ClientInfoModel{
+			ClientIp:  model.ClientIp,
+			UserAgent: model.UserAgent,
+		})
+	}
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	c.loginAttemptService.RegisterSuccess(context, userEntity.Email)
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "MagicLinkService").
+		WithField("Method", "Login").
+		Info("User logged in with sign-in link")
+
+	return responseModel, nil
+}
+
+func (c *MagicLinkService) logError(operation string, err error) {
+	c.logger.
+		WithField("Service", "MagicLinkService").
+		WithField("Method", "RequestLink").
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+}
+
+func (c *MagicLinkService) internalError(operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "MagicLinkService").
+		WithField("Method", "Login").
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
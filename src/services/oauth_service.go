@@ -0,0 +1,611 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const defaultAuthorizationCodeTtl = time.Minute
+
+// OAuthScopes lists the scopes clients may request.
+var OAuthScopes = []string{models.OpenIdScope, models.ProfileScope, models.EmailScope}
+
+type IOAuthService interface {
+	CreateClient(context context.Context, model models.CreateOAuthClientModel) (
+		responseModel models.OAuthClientResponseModel, errorModel *models.ErrorModel)
+	GetAllClients(context context.Context) (responseModel []models.OAuthClientResponseModel,
+		errorModel *models.ErrorModel)
+	DeleteClient(context context.Context, model models.OAuthClientModel) (errorModel *models.ErrorModel)
+	Authorize(context context.Context, model models.AuthorizeModel) (responseModel models.AuthorizeResponseModel,
+		errorModel *models.ErrorModel)
+	Token(context context.Context, model models.OAuthTokenModel) (responseModel models.OAuthTokenResponseModel,
+		errorModel *models.ErrorModel)
+	UserInfo(context context.Context, model models.UserInfoModel) (responseModel models.UserInfoResponseModel,
+		errorModel *models.ErrorModel)
+	Discovery() models.OpenIdConfigurationModel
+	Jwks() models.JwksModel
+}
+
+// OAuthService makes this service an OpenID Connect provider using the
+// authorization code flow. PKCE with S256 is required from every client;
+// confidential clients must also authenticate with their secret.
+type OAuthService struct {
+	validator            validators.IOAuthValidator
+	userService          IUserService
+	tokenService         ITokenService
+	tokenHelper          helpers.ITokenHelper
+	issuer               string
+	authorizationCodeTtl time.Duration
+	logger               *logrus.Logger
+}
+
+func NewOAuthService(validator validators.IOAuthValidator, userService IUserService, tokenService ITokenService,
+	tokenHelper helpers.ITokenHelper, config configuration.OAuthConfigurations, logger *logrus.Logger) *OAuthService {
+	authorizationCodeTtl := config.AuthorizationCodeTtl
+	if authorizationCodeTtl <= 0 {
+		authorizationCodeTtl = defaultAuthorizationCodeTtl
+	}
+	return &OAuthService{validator: validator, userService: userService, tokenService: tokenService,
+		tokenHelper: tokenHelper, issuer: strings.TrimSuffix(config.Issuer, "/"),
+		authorizationCodeTtl: authorizationCodeTtl, logger: logger}
+}
+
+// CreateClient returns the client secret of confidential clients; it is
+// only shown once.
+func (c *OAuthService) CreateClient(context context.Context, model models.CreateOAuthClientModel) (
+	responseModel models.OAuthClientResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateCreateOAuthClientModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	clientEntity := models.OAuthClientEntity{
+		Id:           primitive.NewObjectID(),
+		Name:         model.Name,
+		RedirectUris: model.RedirectUris,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	var secret string
+
+	if model.Confidential {
+		generated, err := helpers.GenerateOpaqueToken()
+
+		if err != nil {
+			return responseModel, c.internalError("CreateClient", "GenerateOpaqueToken", err)
+		}
+
+		secret = generated
+		clientEntity.SecretHash = helpers.HashOpaqueToken(secret)
+	}
+
+	_, err := helpers.OAuthClientCollection.InsertOne(context, clientEntity)
+
+	if err != nil {
+		return responseModel, c.internalError("CreateClient", "InsertOne", err)
+	}
+
+	c.logger.
+		WithField("ClientId", clientEntity.Id.Hex()).
+		WithField("Service", "OAuthService").
+		WithField("Method", "CreateClient").
+		Info("OAuth client registered")
+
+	responseModel = toOAuthClientResponseModel(clientEntity)
+	responseModel.ClientSecret = secret
+
+	return responseModel, nil
+}
+
+func (c *OAuthService) GetAllClients(context context.Context) (responseModel []models.OAuthClientResponseModel,
+	errorModel *models.ErrorModel) {
+
+	cursor, err := helpers.OAuthClientCollection.Find(context, bson.D{},
+		options.Find().SetSort(bson.D{{"CreatedAt", 1}}))
+
+	if err != nil {
+		return responseModel, c.internalError("GetAllClients", "Find", err)
+	}
+
+	var clients []models.OAuthClientEntity
+
+	if err = cursor.All(context, &clients); err != nil {
+		return responseModel, c.internalError("GetAllClients", "All", err)
+	}
+
+	responseModel = []models.OAuthClientResponseModel{}
+
+	for _, client := range clients {
+		responseModel = append(responseModel, toOAuthClientResponseModel(client))
+	}
+
+	return responseModel, nil
+}
+
+// DeleteClient removes the client and its unused authorization codes.
+// Tokens already issued to it stay valid until they expire.
+func (c *OAuthService) DeleteClient(context context.Context, model models.OAuthClientModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateOAuthClientModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	deleteResult, err := helpers.OAuthClientCollection.DeleteOne(context, bson.D{{"_id", objID}})
+
+	if err != nil {
+		return c.internalError("DeleteClient", "DeleteOne", err)
+	}
+
+	if deleteResult.DeletedCount == 0 {
+		return &models.ErrorModel{
+			Error:      models.OAuthClientNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	_, err = helpers.AuthorizationCodeCollection.DeleteMany(context, bson.D{{"ClientId", objID}})
+
+	if err != nil {
+		return c.internalError("DeleteClient", "DeleteMany", err)
+	}
+
+	return nil
+}
+
+// Authorize issues an authorization code for the authenticated user. Until
+// the client and the redirect URI are known to be valid, errors are returned
+// to the caller; after that they are reported to the client through the
+// redirect URL, as are successful codes.
+func (c *OAuthService) Authorize(context context.Context, model models.AuthorizeModel) (
+	responseModel models.AuthorizeResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateAuthorizeModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	client, error := c.findClient(context, model.ClientId, "Authorize")
+
+	if error != nil {
+		if error.StatusCode == http.StatusUnauthorized {
+			error.StatusCode = http.StatusBadRequest
+		}
+		return responseModel, error
+	}
+
+	redirectUri := model.RedirectUri
+
+	if redirectUri == "" && len(client.RedirectUris) == 1 {
+		redirectUri = client.RedirectUris[0]
+	}
+
+	if !containsString(client.RedirectUris, redirectUri) {
+		c.logger.
+			WithField("ClientId", model.ClientId).
+			WithField("RedirectUri", model.RedirectUri).
+			WithField("Service", "OAuthService").
+			WithField("Method", "Authorize").
+			Warn("Redirect URI not registered")
+		return responseModel, &models.ErrorModel{
+			Error:      models.OAuthInvalidRequestError,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	redirect := func(params url.Values) (models.AuthorizeResponseModel, *models.ErrorModel) {
+		if model.State != "" {
+			params.Set("state", model.State)
+		}
+		return models.AuthorizeResponseModel{RedirectUrl: appendQuery(redirectUri, params)}, nil
+	}
+
+	if model.ResponseType != models.CodeResponseType {
+		return redirect(url.Values{"error": {models.OAuthUnsupportedResponseTypeError}})
+	}
+
+	if model.CodeChallenge == "" || model.CodeChallengeMethod != models.S256CodeChallengeMethod {
+		return redirect(url.Values{"error": {models.OAuthInvalidRequestError}})
+	}
+
+	scope, valid := normalizeScope(model.Scope)
+
+	if !valid {
+		return redirect(url.Values{"error": {models.OAuthInvalidScopeError}})
+	}
+
+	code, err := helpers.GenerateOpaqueToken()
+
+	if err != nil {
+		c.internalError("Authorize", "GenerateOpaqueToken", err)
+		return redirect(url.Values{"error": {models.OAuthServerError}})
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+	now := time.Now().UTC()
+
+	_, err = helpers.AuthorizationCodeCollection.InsertOne(context, models.AuthorizationCodeEntity{
+		Id:                  primitive.NewObjectID(),
+		CodeHash:            helpers.HashOpaqueToken(code),
+		ClientId:            client.Id,
+		UserId:              userId,
+		RedirectUri:         redirectUri,
+		RedirectUriRequired: model.RedirectUri != "",
+		Scope:               scope,
+		Nonce:               model.Nonce,
+		CodeChallenge:       model.CodeChallenge,
+		ClientIp:            model.ClientIp,
+		UserAgent:           model.UserAgent,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(c.authorizationCodeTtl),
+	})
+
+	if err != nil {
+		c.internalError("Authorize", "InsertOne", err)
+		return redirect(url.Values{"error": {models.OAuthServerError}})
+	}
+
+	return redirect(url.Values{"code": {code}})
+}
+
+// Token authenticates the client and redeems an authorization code or a
+// refresh token. Refresh tokens are bound to the client they were issued to.
+func (c *OAuthService) Token(context context.Context, model models.OAuthTokenModel) (
+	responseModel models.OAuthTokenResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateOAuthTokenModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	client, error := c.findClient(context, model.ClientId, "Token")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	if client.SecretHash != "" && subtle.ConstantTimeCompare([]byte(client.SecretHash),
+		[]byte(helpers.HashOpaqueToken(model.ClientSecret))) != 1 {
+		c.logger.
+			WithField("ClientId", model.ClientId).
+			WithField("Service", "OAuthService").
+			WithField("Method", "Token").
+			Warn("Client secret mismatch")
+		return responseModel, &models.ErrorModel{
+			Error:      models.OAuthInvalidClientError,
+			StatusCode: http.StatusUnauthorized,
+		}
+	}
+
+	switch model.GrantType {
+	case models.AuthorizationCodeGrantType:
+		return c.exchangeCode(context, client, model)
+	case models.RefreshTokenGrantType:
+		if model.RefreshToken == "" {
+			return responseModel, &models.ErrorModel{
+				Error:      models.OAuthInvalidRequestError,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+
+		tokens, error := c.tokenService.Refresh(context, model.RefreshToken, models.ClientInfoModel{
+			ClientIp:  model.ClientIp,
+			UserAgent: model.UserAgent,
+			ClientId:  client.Id,
+		})
+
+		if error != nil {
+			if error.StatusCode == http.StatusUnauthorized {
+				error = &models.ErrorModel{
+					Error:      models.OAuthInvalidGrantError,
+					StatusCode: http.StatusBadRequest,
+				}
+			}
+			return responseModel, error
+		}
+
+		// The password can only be changed through the service's own login.
+		if tokens.PasswordChangeRequired {
+			return responseModel, &models.ErrorModel{
+				Error:      models.OAuthInvalidGrantError,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+
+		return toOAuthTokenResponseModel(tokens), nil
+	default:
+		return responseModel, &models.ErrorModel{
+			Error:      models.OAuthUnsupportedGrantTypeError,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+}
+
+func (c *OAuthService) UserInfo(context context.Context, model models.UserInfoModel) (
+	responseModel models.UserInfoResponseModel, errorModel *models.ErrorModel) {
+
+	user, error := c.userService.GetUser(context, models.GetUserModel{Id: model.UserId})
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	return models.UserInfoResponseModel{
+		Sub:           user.Id.Hex(),
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	}, nil
+}
+
+func (c *OAuthService) Discovery() models.OpenIdConfigurationModel {
+	return models.OpenIdConfigurationModel{
+		Issuer:                            c.issuer,
+		AuthorizationEndpoint:             c.issuer + "/oauth/authorize",
+		TokenEndpoint:                     c.issuer + "/oauth/token",
+		UserInfoEndpoint:                  c.issuer + "/oauth/userinfo",
+		JwksUri:                           c.issuer + "/oauth/jwks",
+		ResponseTypesSupported:            []string{models.CodeResponseType},
+		GrantTypesSupported:               []string{models.AuthorizationCodeGrantType, models.RefreshTokenGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{c.tokenHelper.Algorithm()},
+		ScopesSupported:                   OAuthScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{models.S256CodeChallengeMethod},
+		ClaimsSupported:                   []string{"sub", "name", "email", "email_verified"},
+	}
+}
+
+func (c *OAuthService) Jwks() models.JwksModel {
+	return c.tokenHelper.Jwks()
+}
+
+func (c *OAuthService) exchangeCode(context context.Context, client models.OAuthClientEntity,
+	model models.OAuthTokenModel) (responseModel models.OAuthTokenResponseModel, errorModel *models.ErrorModel) {
+
+	invalidGrant := &models.ErrorModel{
+		Error:      models.OAuthInvalidGrantError,
+		StatusCode: http.StatusBadRequest,
+	}
+
+	if model.Code == "" || model.CodeVerifier == "" {
+		return responseModel, &models.ErrorModel{
+			Error:      models.OAuthInvalidRequestError,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	now := time.Now().UTC()
+
+	var codeEntity models.AuthorizationCodeEntity
+
+	// Codes are consumed before they are checked, so a code can never be
+	// redeemed twice even by concurrent requests.
+	err := helpers.AuthorizationCodeCollection.FindOneAndUpdate(context,
+		bson.D{
+			{"CodeHash", helpers.HashOpaqueToken(model.Code)},
+			{"UsedAt", nil},
+			{"ExpiresAt", bson.D{{"$gt", now}}}},
+		bson.D{{"$set", bson.D{{"UsedAt", now}}}}).
+		Decode(&codeEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return responseModel, invalidGrant
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("Token", "FindOneAndUpdate", err)
+	}
+
+	if codeEntity.ClientId != client.Id ||
+		((model.RedirectUri != "" || codeEntity.RedirectUriRequired) && model.RedirectUri != codeEntity.RedirectUri) ||
+		!verifyCodeChallenge(model.CodeVerifier, codeEntity.CodeChallenge) {
+		c.logger.
+			WithField("ClientId", client.Id.Hex()).
+			WithField("Service", "OAuthService").
+			WithField("Method", "Token").
+			Warn("Authorization code does not match the request")
+		return responseModel, invalidGrant
+	}
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOne(context, bson.D{{"_id", codeEntity.UserId}, activeUser}).
+		Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments || (err == nil && userEntity.Disabled) {
+		return responseModel, invalidGrant
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("Token", "FindOne", err)
+	}
+
+	// The password can only be changed through the service's own login, so
+	// clients get no tokens for a user who has to choose a new one.
+	if c.userService.PasswordExpired(userEntity) {
+		c.logger.
+			WithField("ClientId", client.Id.Hex()).
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "OAuthService").
+			WithField("Method", "Token").
+			Warn("Password expired")
+		return responseModel, invalidGrant
+	}
+
+	tokens, error := c.tokenService.IssueTokens(context, userEntity, models.ClientInfoModel{
+		ClientIp:  codeEntity.ClientIp,
+		UserAgent: codeEntity.UserAgent,
+		ClientId:  client.Id,
+	})
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	responseModel = toOAuthTokenResponseModel(tokens)
+	responseModel.Scope = codeEntity.Scope
+
+	if containsString(strings.Fields(codeEntity.Scope), models.OpenIdScope) {
+		responseModel.IdToken, err = c.issueIdToken(userEntity, client, codeEntity)
+
+		if err != nil {
+			return responseModel, c.internalError("Token", "IssueIdToken", err)
+		}
+	}
+
+	c.logger.
+		WithField("ClientId", client.Id.Hex()).
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "OAuthService").
+		WithField("Method", "Token").
+		Info("Authorization code redeemed")
+
+	return responseModel, nil
+}
+
+func (c *OAuthService) issueIdToken(userEntity models.UserEntity, client models.OAuthClientEntity,
+	codeEntity models.AuthorizationCodeEntity) (string, error) {
+	claims := helpers.IdTokenClaims{Nonce: codeEntity.Nonce}
+	claims.Issuer = c.issuer
+	claims.Subject = userEntity.Id.Hex()
+	claims.Audience = []string{client.Id.Hex()}
+
+	scopes := strings.Fields(codeEntity.Scope)
+
+	if containsString(scopes, models.ProfileScope) {
+		claims.Name = userEntity.Name
+	}
+	if containsString(scopes, models.EmailScope) {
+		emailVerified := userEntity.EmailVerified
+		claims.Email = userEntity.Email
+		claims.EmailVerified = &emailVerified
+	}
+
+	return c.tokenHelper.IssueIdToken(claims)
+}
+
+// findClient reports unknown clients as invalid_client with 401, the status
+// the token endpoint requires.
+func (c *OAuthService) findClient(context context.Context, clientId string, method string) (
+	client models.OAuthClientEntity, errorModel *models.ErrorModel) {
+
+	invalidClient := &models.ErrorModel{
+		Error:      models.OAuthInvalidClientError,
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	objID, err := primitive.ObjectIDFromHex(clientId)
+
+	if err != nil {
+		return client, invalidClient
+	}
+
+	err = helpers.OAuthClientCollection.FindOne(context, bson.D{{"_id", objID}}).Decode(&client)
+
+	if err == mongo.ErrNoDocuments {
+		c.logger.
+			WithField("ClientId", clientId).
+			WithField("Service", "OAuthService").
+			WithField("Method", method).
+			Warn("Unknown client")
+		return client, invalidClient
+	}
+
+	if err != nil {
+		return client, c.internalError(method, "FindOne", err)
+	}
+
+	return client, nil
+}
+
+func (c *OAuthService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "OAuthService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.OAuthServerError,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
+
+// normalizeScope drops duplicate scopes and rejects unknown ones.
+func normalizeScope(scope string) (string, bool) {
+	var scopes []string
+
+	for _, requested := range strings.Fields(scope) {
+		if !containsString(OAuthScopes, requested) {
+			return "", false
+		}
+		if !containsString(scopes, requested) {
+			scopes = append(scopes, requested)
+		}
+	}
+
+	return strings.Join(scopes, " "), true
+}
+
+func verifyCodeChallenge(codeVerifier string, codeChallenge string) bool {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
+
+func appendQuery(redirectUri string, params url.Values) string {
+	parsed, _ := url.Parse(redirectUri)
+	query := parsed.Query()
+
+	for key, values := range params {
+		query[key] = values
+	}
+
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String()
+}
+
+func toOAuthClientResponseModel(client models.OAuthClientEntity) models.OAuthClientResponseModel {
+	return models.OAuthClientResponseModel{
+		ClientId:     client.Id.Hex(),
+		Name:         client.Name,
+		RedirectUris: client.RedirectUris,
+		Confidential: client.SecretHash != "",
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func toOAuthTokenResponseModel(tokens models.LoginResponseModel) models.OAuthTokenResponseModel {
+	return models.OAuthTokenResponseModel{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+	}
+}
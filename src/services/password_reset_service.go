@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/url"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const defaultPasswordResetTokenTtl = 30 * time.Minute
+
+type IPasswordResetService interface {
+	ForgotPassword(context context.Context, model models.ForgotPasswordModel) (errorModel *models.ErrorModel)
+	ResetPassword(context context.Context, model models.ResetPasswordModel) (errorModel *models.ErrorModel)
+}
+
+// PasswordResetService issues single-use reset tokens. Only the token hash is
+// stored; the token itself is handed to the notification sender.
+type PasswordResetService struct {
+	validator   validators.IAuthValidator
+	userService IUserService
+	sender      helpers.INotificationSender
+	tokenTtl    time.Duration
+	resetUrl    string
+	logger      *logrus.Logger
+}
+
+func NewPasswordResetService(validator validators.IAuthValidator, userService IUserService,
+	sender helpers.INotificationSender, config configuration.PasswordResetConfigurations,
+	logger *logrus.Logger) *PasswordResetService {
+	tokenTtl := config.TokenTtl
+	if tokenTtl <= 0 {
+		tokenTtl = defaultPasswordResetTokenTtl
+	}
+	return &PasswordResetService{validator: validator, userService: userService, sender: sender,
+		tokenTtl: tokenTtl, resetUrl: config.ResetUrl, logger: logger}
+}
+
+// ForgotPassword only fails for malformed requests. Unknown emails and
+// internal errors are logged but not reported, so callers cannot tell
+// whether an account exists.
+func (c *PasswordResetService) ForgotPassword(context context.Context, model models.ForgotPasswordModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateForgotPasswordModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	var userEntity models.UserEntity
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"Email", model.Email}, activeUser}).Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("Email", model.Email).
+				WithField("Service", "PasswordResetService").
+				WithField("Method", "ForgotPassword").
+				WithField("Operation", "FindOne").
+				Warn("UserNotFound")
+			return nil
+		}
+
+		c.logError("FindOne", err)
+		return nil
+	}
+
+	token, err := helpers.GenerateOpaqueToken()
+
+	if err != nil {
+		c.logError("GenerateOpaqueToken", err)
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	_, err = helpers.PasswordResetTokenCollection.UpdateMany(context,
+		bson.D{{"UserId", userEntity.Id}, {"UsedAt", nil}},
+		bson.D{{"$set", bson.D{{"UsedAt", now}}}})
+
+	if err != nil {
+		c.logError("UpdateMany", err)
+		return nil
+	}
+
+	_, err = helpers.PasswordResetTokenCollection.InsertOne(context, models.PasswordResetTokenEntity{
+		Id:        primitive.NewObjectID(),
+		UserId:    userEntity.Id,
+		TokenHash: helpers.HashOpaqueToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.tokenTtl),
+	})
+
+	if err != nil {
+		c.logError("InsertOne", err)
+		return nil
+	}
+
+	err = c.sender.Send(context, models.NotificationMessage{
+		To:      userEntity.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use the link below to choose a new password. It expires in %s.\n\n%s?token=%s",
+			c.tokenTtl, c.resetUrl, url.QueryEscape(token)),
+		CreatedAt: now,
+	})
+
+	if err != nil {
+		c.logError("Send", err)
+		return nil
+	}
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "PasswordResetService").
+		WithField("Method", "ForgotPassword").
+		Info("Password reset requested")
+
+	return nil
+}
+
+func (c *PasswordResetService) ResetPassword(context context.Context, model models.ResetPasswordModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateResetPasswordModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	now := time.Now().UTC()
+
+	var tokenEntity models.PasswordResetTokenEntity
+
+	err := helpers.PasswordResetTokenCollection.FindOneAndUpdate(context,
+		bson.D{
+			{"TokenHash", helpers.HashOpaqueToken(model.Token)},
+			{"UsedAt", nil},
+			{"ExpiresAt", bson.D{{"$gt", now}}}},
+		bson.D{{"$set", bson.D{{"UsedAt", now}}}}).
+		Decode(&tokenEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("Service", "PasswordResetService").
+				WithField("Method", "ResetPassword").
+				WithField("Operation", "FindOneAndUpdate").
+				Warn("Reset token invalid, used or expired")
+			return &models.ErrorModel{
+				Error:      models.InvalidResetTokenMessage,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+
+		c.logger.
+			WithField("Service", "PasswordResetService").
+			WithField("Method", "ResetPassword").
+			WithField("Operation", "FindOneAndUpdate").
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	error = c.userService.SetPassword(context, tokenEntity.UserId, model.Password)
+
+	if error != nil && error.StatusCode == http.StatusBadRequest {
+		// A rejected password should not cost the user their reset link.
+		_, err = helpers.PasswordResetTokenCollection.UpdateOne(context,
+			bson.D{{"_id", tokenEntity.Id}},
+			bson.D{{"$unset", bson.D{{"UsedAt", ""}}}})
+
+		if err != nil {
+			c.logger.
+				WithField("Service", "PasswordResetService").
+				WithField("Method", "ResetPassword").
+				WithField("Operation", "UpdateOne").
+				WithField("Error", err.Error()).
+				Error("")
+		}
+	}
+
+	return error
+}
+
+func (c *PasswordResetService) logError(operation string, err error) {
+	c.logger.
+		WithField("Service", "PasswordResetService").
+		WithField("Method", "ForgotPassword").
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+}
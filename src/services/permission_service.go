@@ -0,0 +1,121 @@
+package services
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"user-management-service/src/models"
+)
+
+// RolePermissions lists the permissions granted by each role. Permissions
+// guard operations on other users' accounts; users may always read, update
+// and delete their own account.
+var RolePermissions = map[string][]string{
+	models.AdminRole: {
+		models.ReadUsersPermission,
+		models.ListUsersPermission,
+		models.UpdateUsersPermission,
+		models.DeleteUsersPermission,
+		models.ManageRolesPermission,
+		models.ResetTwoFactorPermission,
+		models.UnlockUsersPermission,
+		models.ExpirePasswordsPermission,
+		models.ManageApiKeysPermission,
+		models.ManageOAuthClientsPermission,
+		models.ManageSessionsPermission,
+		models.ImpersonateUsersPermission,
+		models.ProvisionUsersPermission,
+		models.RestoreUsersPermission,
+	},
+	models.UserRole: {},
+}
+
+// ApiKeyScopes lists the operations an API key may be scoped to. Managing
+// API keys and impersonation are left out, and so are the
+// CredentialApiKeyScopes unless enabled, so that by default a key can never
+// be used to mint another credential.
+var ApiKeyScopes = []string{
+	models.ReadUsersPermission,
+	models.ListUsersPermission,
+	models.DeleteUsersPermission,
+	models.ResetTwoFactorPermission,
+	models.UnlockUsersPermission,
+	models.ExpirePasswordsPermission,
+	models.ManageSessionsPermission,
+}
+
+// CredentialApiKeyScopes set passwords or grant roles, so a key scoped to
+// them can take over accounts. Keys only get them when the configuration
+// explicitly allows it, e.g. for SCIM provisioning clients.
+var CredentialApiKeyScopes = []string{
+	models.UpdateUsersPermission,
+	models.ManageRolesPermission,
+	models.ProvisionUsersPermission,
+}
+
+type IPermissionService interface {
+	Authorize(principal models.Principal, permission string, ownerId string) *models.ErrorModel
+	HasPermission(principal models.Principal, permission string) bool
+}
+
+type PermissionService struct {
+	logger *logrus.Logger
+}
+
+func NewPermissionService(logger *logrus.Logger) *PermissionService {
+	return &PermissionService{logger: logger}
+}
+
+// Authorize allows the operation when the principal owns the target account
+// or holds permission. ownerId is empty for operations without a single
+// target, such as listing users. Principals authenticated with an API key
+// are further limited to the key's scopes, on their own account as well.
+func (c *PermissionService) Authorize(principal models.Principal, permission string,
+	ownerId string) *models.ErrorModel {
+	if principal.ApiKeyId == "" || containsString(principal.ApiKeyScopes, permission) {
+		if principal.UserId != "" && ownerId != "" && principal.UserId == ownerId {
+			return nil
+		}
+
+		if c.HasPermission(principal, permission) {
+			return nil
+		}
+	}
+
+	c.logger.
+		WithField("Service", "PermissionService").
+		WithField("Method", "Authorize").
+		WithField("UserId", principal.UserId).
+		WithField("Permission", permission).
+		WithField("OwnerId", ownerId).
+		WithField("ApiKeyId", principal.ApiKeyId).
+		Warn("Permission denied")
+
+	return &models.ErrorModel{
+		Error:      models.ForbiddenErrorMessage,
+		StatusCode: http.StatusForbidden,
+	}
+}
+
+func (c *PermissionService) HasPermission(principal models.Principal, permission string) bool {
+	if containsString(principal.Permissions, permission) {
+		return true
+	}
+
+	for _, role := range principal.Roles {
+		if containsString(RolePermissions[role], permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
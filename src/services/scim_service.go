@@ -0,0 +1,796 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"strings"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const defaultScimMaxResults = 100
+
+type IScimService interface {
+	GetUsers(context context.Context, model models.ScimListModel) (responseModel models.ScimListResponseModel,
+		errorModel *models.ErrorModel)
+	GetUser(context context.Context, id string) (responseModel models.ScimUserModel, errorModel *models.ErrorModel)
+	CreateUser(context context.Context, model models.ScimUserModel) (responseModel models.ScimUserModel,
+		errorModel *models.ErrorModel)
+	ReplaceUser(context context.Context, id string, model models.ScimUserModel) (responseModel models.ScimUserModel,
+		errorModel *models.ErrorModel)
+	PatchUser(context context.Context, id string, model models.ScimPatchModel) (responseModel models.ScimUserModel,
+		errorModel *models.ErrorModel)
+	DeleteUser(context context.Context, id string) (errorModel *models.ErrorModel)
+	GetServiceProviderConfig() models.ScimServiceProviderConfigModel
+	GetResourceTypes() models.ScimListResponseModel
+	GetResourceType(id string) (responseModel models.ScimResourceTypeModel, errorModel *models.ErrorModel)
+	GetSchemas() models.ScimListResponseModel
+	GetSchema(id string) (responseModel models.ScimSchemaModel, errorModel *models.ErrorModel)
+}
+
+// ScimService exposes users through the SCIM 2.0 protocol (RFC 7643 and RFC
+// 7644) so identity providers can provision and deprovision accounts.
+// userName is the user's email and emails is derived from it; deactivating a
+// user blocks every way of signing in and revokes their sessions. The
+// identity provider is trusted for email ownership, so provisioned emails are
+// marked as verified.
+type ScimService struct {
+	validator    validators.IUserValidator
+	hasher       helpers.IPasswordHasher
+	userService  IUserService
+	tokenService ITokenService
+	baseUrl      string
+	maxResults   int
+	logger       *logrus.Logger
+}
+
+func NewScimService(validator validators.IUserValidator, hasher helpers.IPasswordHasher, userService IUserService,
+	tokenService ITokenService, config configuration.ScimConfigurations, logger *logrus.Logger) *ScimService {
+	maxResults := config.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultScimMaxResults
+	}
+	return &ScimService{validator: validator, hasher: hasher, userService: userService, tokenService: tokenService,
+		baseUrl: strings.TrimSuffix(config.BaseUrl, "/"), maxResults: maxResults, logger: logger}
+}
+
+// GetUsers returns one page of the users matching the filter, ordered by
+// creation. startIndex is 1-based and count is capped at the configured
+// maximum.
+func (c *ScimService) GetUsers(context context.Context, model models.ScimListModel) (
+	responseModel models.ScimListResponseModel, errorModel *models.ErrorModel) {
+
+	filter := bson.D{}
+
+	if model.Filter != "" {
+		var err error
+		filter, err = helpers.ParseScimFilter(model.Filter)
+
+		if err != nil {
+			c.logger.
+				WithField("Filter", model.Filter).
+				WithField("Service", "ScimService").
+				WithField("Method", "GetUsers").
+				WithField("Operation", "ParseScimFilter").
+				Warn("Invalid filter")
+			return responseModel, &models.ErrorModel{
+				Error:      models.InvalidScimFilterMessage,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+	}
+
+	filter = append(filter, activeUser)
+
+	startIndex := model.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+
+	count := c.maxResults
+	if model.Count != nil && *model.Count < count {
+		count = *model.Count
+	}
+	if count < 0 {
+		count = 0
+	}
+
+	totalResults, err := helpers.UserCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		return responseModel, c.internalError("GetUsers", "CountDocuments", err)
+	}
+
+	resources := []models.ScimUserModel{}
+
+	// A count of zero only asks for the number of matching users.
+	if count > 0 {
+		findOptions := options.Find().
+			SetSort(bson.D{{"_id", 1}}).
+			SetSkip(int64(startIndex - 1)).
+			SetLimit(int64(count))
+
+		cursor, err := helpers.UserCollection.Find(context, filter, findOptions)
+
+		if err != nil {
+			return responseModel, c.internalError("GetUsers", "Find", err)
+		}
+
+		var userEntities []models.UserEntity
+
+		if err = cursor.All(context, &userEntities); err != nil {
+			return responseModel, c.internalError("GetUsers", "All", err)
+		}
+
+		for _, userEntity := range userEntities {
+			resources = append(resources, c.toScimUser(userEntity))
+		}
+	}
+
+	return models.ScimListResponseModel{
+		Schemas:      []string{models.ScimListResponseSchema},
+		TotalResults: totalResults,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+func (c *ScimService) GetUser(context context.Context, id string) (responseModel models.ScimUserModel,
+	errorModel *models.ErrorModel) {
+
+	userEntity, error := c.findUser(context, id, "GetUser")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	return c.toScimUser(userEntity), nil
+}
+
+func (c *ScimService) CreateUser(context context.Context, model models.ScimUserModel) (
+	responseModel models.ScimUserModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateScimUserModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	error = c.checkUniqueness(context, model, primitive.NilObjectID, "CreateUser")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	now := time.Now().UTC()
+
+	userEntity := models.UserEntity{
+		Id:              primitive.NewObjectID(),
+		Email:           model.UserName,
+		Roles:           []string{models.UserRole},
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Version:         1,
+	}
+
+	applyScimUser(&userEntity, model)
+
+	// Users provisioned without a password sign in through the identity
+	// provider or with a sign-in link.
+	if model.Password != "" {
+		error = c.validator.ValidatePassword(model.Password, userEntity.Name, userEntity.Email)
+
+		if error != nil {
+			return responseModel, error
+		}
+
+		passwordHash, err := c.hasher.Hash(model.Password)
+
+		if err != nil {
+			return responseModel, c.internalError("CreateUser", "Hash", err)
+		}
+
+		userEntity.Password = passwordHash
+		userEntity.PasswordChangedAt = &now
+	}
+
+	_, err := helpers.UserCollection.InsertOne(context, userEntity)
+
+	if err != nil {
+		return responseModel, c.internalError("CreateUser", "InsertOne", err)
+	}
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "ScimService").
+		WithField("Method", "CreateUser").
+		Info("User provisioned")
+
+	return c.toScimUser(userEntity), nil
+}
+
+// ReplaceUser overwrites the attributes of a user. active is left unchanged
+// when it is not sent.
+func (c *ScimService) ReplaceUser(context context.Context, id string, model models.ScimUserModel) (
+	responseModel models.ScimUserModel, errorModel *models.ErrorModel) {
+
+	userEntity, error := c.findUser(context, id, "ReplaceUser")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	return c.replaceUser(context, userEntity, model, "ReplaceUser")
+}
+
+// PatchUser applies the operations to the current representation of the
+// user and stores the result like ReplaceUser. Either every operation is
+// applied or none is.
+func (c *ScimService) PatchUser(context context.Context, id string, model models.ScimPatchModel) (
+	responseModel models.ScimUserModel, errorModel *models.ErrorModel) {
+
+	invalidPatch := &models.ErrorModel{
+		Error:      models.InvalidScimPatchMessage,
+		StatusCode: http.StatusBadRequest,
+	}
+
+	if !containsString(model.Schemas, models.ScimPatchOpSchema) || len(model.Operations) == 0 {
+		c.logger.
+			WithField("Service", "ScimService").
+			WithField("Method", "PatchUser").
+			Warn("PatchOp schema or operations missing")
+		return responseModel, invalidPatch
+	}
+
+	userEntity, error := c.findUser(context, id, "PatchUser")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	scimUser := c.toScimUser(userEntity)
+
+	for _, operation := range model.Operations {
+		if !applyScimPatchOperation(&scimUser, operation) {
+			c.logger.
+				WithField("UserId", userEntity.Id.Hex()).
+				WithField("Op", operation.Op).
+				WithField("Path", operation.Path).
+				WithField("Service", "ScimService").
+				WithField("Method", "PatchUser").
+				Warn("Unsupported patch operation")
+			return responseModel, invalidPatch
+		}
+	}
+
+	return c.replaceUser(context, userEntity, scimUser, "PatchUser")
+}
+
+func (c *ScimService) DeleteUser(context context.Context, id string) (errorModel *models.ErrorModel) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return c.notFound(id, "DeleteUser")
+	}
+
+	return c.userService.DeleteUser(context, models.DeleteUserModel{Id: id})
+}
+
+func (c *ScimService) GetServiceProviderConfig() models.ScimServiceProviderConfigModel {
+	return models.ScimServiceProviderConfigModel{
+		Schemas:        []string{models.ScimServiceProviderConfigSchema},
+		Patch:          models.ScimSupportedModel{Supported: true},
+		Bulk:           models.ScimBulkModel{Supported: false},
+		Filter:         models.ScimFilterSupportModel{Supported: true, MaxResults: c.maxResults},
+		ChangePassword: models.ScimSupportedModel{Supported: true},
+		Sort:           models.ScimSupportedModel{Supported: false},
+		Etag:           models.ScimSupportedModel{Supported: false},
+		AuthenticationSchemes: []models.ScimAuthenticationModel{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "An access token, or an API key when credential scopes are allowed, with the users:provision permission",
+			Primary:     true,
+		}},
+		Meta: &models.ScimMetaModel{
+			ResourceType: "ServiceProviderConfig",
+			Location:     c.baseUrl + "/ServiceProviderConfig",
+		},
+	}
+}
+
+func (c *ScimService) GetResourceTypes() models.ScimListResponseModel {
+	resources := []models.ScimResourceTypeModel{c.userResourceType()}
+
+	return models.ScimListResponseModel{
+		Schemas:      []string{models.ScimListResponseSchema},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func (c *ScimService) GetResourceType(id string) (responseModel models.ScimResourceTypeModel,
+	errorModel *models.ErrorModel) {
+
+	if id != models.ScimUserResourceType {
+		return responseModel, c.notFound(id, "GetResourceType")
+	}
+
+	return c.userResourceType(), nil
+}
+
+func (c *ScimService) GetSchemas() models.ScimListResponseModel {
+	resources := []models.ScimSchemaModel{c.userSchema()}
+
+	return models.ScimListResponseModel{
+		Schemas:      []string{models.ScimListResponseSchema},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func (c *ScimService) GetSchema(id string) (responseModel models.ScimSchemaModel, errorModel *models.ErrorModel) {
+	if id != models.ScimUserSchema {
+		return responseModel, c.notFound(id, "GetSchema")
+	}
+
+	return c.userSchema(), nil
+}
+
+func (c *ScimService) userResourceType() models.ScimResourceTypeModel {
+	return models.ScimResourceTypeModel{
+		Schemas:     []string{models.ScimResourceTypeSchema},
+		Id:          models.ScimUserResourceType,
+		Name:        models.ScimUserResourceType,
+		Endpoint:    "/Users",
+		Description: "User Account",
+		Schema:      models.ScimUserSchema,
+		Meta: &models.ScimMetaModel{
+			ResourceType: "ResourceType",
+			Location:     c.baseUrl + "/ResourceTypes/" + models.ScimUserResourceType,
+		},
+	}
+}
+
+func (c *ScimService) userSchema() models.ScimSchemaModel {
+	stringAttribute := func(name string, required bool, caseExact bool, uniqueness string) models.
+		ScimSchemaAttributeModel {
+		return models.ScimSchemaAttributeModel{Name: name, Type: "string", Required: required, CaseExact: caseExact,
+			Mutability: "readWrite", Returned: "default", Uniqueness: uniqueness}
+	}
+
+	return models.ScimSchemaModel{
+		Schemas:     []string{models.ScimSchemaSchema},
+		Id:          models.ScimUserSchema,
+		Name:        models.ScimUserResourceType,
+		Description: "User Account",
+		Attributes: []models.ScimSchemaAttributeModel{
+			stringAttribute("userName", true, false, "server"),
+			stringAttribute("externalId", false, true, "server"),
+			{Name: "name", Type: "complex", Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+				SubAttributes: []models.ScimSchemaAttributeModel{
+					stringAttribute("formatted", false, false, "none"),
+					stringAttribute("givenName", false, false, "none"),
+					stringAttribute("familyName", false, false, "none"),
+				}},
+			stringAttribute("displayName", false, false, "none"),
+			{Name: "emails", Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default",
+				Uniqueness: "none",
+				SubAttributes: []models.ScimSchemaAttributeModel{
+					stringAttribute("value", false, false, "none"),
+					stringAttribute("type", false, false, "none"),
+					{Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default",
+						Uniqueness: "none"},
+				}},
+			{Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "password", Type: "string", Mutability: "writeOnly", Returned: "never", Uniqueness: "none"},
+		},
+		Meta: &models.ScimMetaModel{
+			ResourceType: "Schema",
+			Location:     c.baseUrl + "/Schemas/" + models.ScimUserSchema,
+		},
+	}
+}
+
+func (c *ScimService) replaceUser(context context.Context, userEntity models.UserEntity, model models.ScimUserModel,
+	method string) (responseModel models.ScimUserModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateScimUserModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	error = c.checkUniqueness(context, model, userEntity.Id, method)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	wasDisabled := userEntity.Disabled
+
+	if model.UserName != userEntity.Email {
+		now := time.Now().UTC()
+		userEntity.Email = model.UserName
+		userEntity.EmailVerified = true
+		userEntity.EmailVerifiedAt = &now
+	}
+
+	applyScimUser(&userEntity, model)
+
+	deactivated := userEntity.Disabled && !wasDisabled
+
+	if deactivated && containsString(userEntity.Roles, models.AdminRole) {
+		error = c.userService.CheckNotLastAdmin(context, userEntity.Id, method)
+
+		if error != nil {
+			return responseModel, error
+		}
+	}
+
+	set := bson.D{
+		{"Name", userEntity.Name},
+		{"Email", userEntity.Email},
+		{"EmailVerified", userEntity.EmailVerified},
+		{"EmailVerifiedAt", userEntity.EmailVerifiedAt},
+		{"GivenName", userEntity.GivenName},
+		{"FamilyName", userEntity.FamilyName},
+		{"ExternalId", userEntity.ExternalId},
+		{"Disabled", userEntity.Disabled}}
+
+	var passwordUpdate bson.D
+
+	// The password is checked before anything is written, so a rejected
+	// password leaves the user unchanged.
+	if model.Password != "" {
+		var passwordSet bson.D
+
+		passwordSet, passwordUpdate, error = c.userService.PreparePassword(context, userEntity, model.Password)
+
+		if error != nil {
+			return responseModel, error
+		}
+
+		set = append(set, passwordSet...)
+	}
+
+	_, err := helpers.UserCollection.UpdateOne(context, bson.D{{"_id", userEntity.Id}},
+		append(bson.D{{"$set", set}, incrementVersion}, passwordUpdate...))
+
+	if err != nil {
+		return responseModel, c.internalError(method, "UpdateOne", err)
+	}
+
+	if model.Password != "" || deactivated {
+		error = c.tokenService.RevokeUserTokens(context, userEntity.Id)
+
+		if error != nil {
+			return responseModel, error
+		}
+	}
+
+	if deactivated {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "ScimService").
+			WithField("Method", method).
+			Info("User deactivated")
+	}
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "ScimService").
+		WithField("Method", method).
+		Info("User updated")
+
+	return c.toScimUser(userEntity), nil
+}
+
+// checkUniqueness rejects a userName or externalId already used by another
+// user than excludeId.
+func (c *ScimService) checkUniqueness(context context.Context, model models.ScimUserModel,
+	excludeId primitive.ObjectID, method string) (errorModel *models.ErrorModel) {
+
+	count, err := helpers.UserCollection.CountDocuments(context, bson.D{
+		{"Email", model.UserName},
+		{"_id", bson.D{{"$ne", excludeId}}},
+		activeUser})
+
+	if err != nil {
+		return c.internalError(method, "CountDocuments", err)
+	}
+
+	if count > 0 {
+		c.logger.
+			WithField("UserName", model.UserName).
+			WithField("Service", "ScimService").
+			WithField("Method", method).
+			WithField("Operation", "CountDocuments").
+			Warn("User already exist for the email")
+		return &models.ErrorModel{
+			Error:      models.EmailExistMessage,
+			StatusCode: http.StatusConflict,
+		}
+	}
+
+	if model.ExternalId == "" {
+		return nil
+	}
+
+	count, err = helpers.UserCollection.CountDocuments(context, bson.D{
+		{"ExternalId", model.ExternalId},
+		{"_id", bson.D{{"$ne", excludeId}}},
+		activeUser})
+
+	if err != nil {
+		return c.internalError(method, "CountDocuments", err)
+	}
+
+	if count > 0 {
+		c.logger.
+			WithField("ExternalId", model.ExternalId).
+			WithField("Service", "ScimService").
+			WithField("Method", method).
+			WithField("Operation", "CountDocuments").
+			Warn("User already exist for the externalId")
+		return &models.ErrorModel{
+			Error:      models.ExternalIdExistMessage,
+			StatusCode: http.StatusConflict,
+		}
+	}
+
+	return nil
+}
+
+func (c *ScimService) findUser(context context.Context, id string, method string) (userEntity models.UserEntity,
+	errorModel *models.ErrorModel) {
+
+	objID, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return userEntity, c.notFound(id, method)
+	}
+
+	err = helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return userEntity, c.notFound(id, method)
+	}
+
+	if err != nil {
+		return userEntity, c.internalError(method, "FindOne", err)
+	}
+
+	return userEntity, nil
+}
+
+func (c *ScimService) toScimUser(userEntity models.UserEntity) models.ScimUserModel {
+	created := userEntity.Id.Timestamp().UTC()
+	active := !userEntity.Disabled
+
+	return models.ScimUserModel{
+		Schemas:    []string{models.ScimUserSchema},
+		Id:         userEntity.Id.Hex(),
+		ExternalId: userEntity.ExternalId,
+		UserName:   userEntity.Email,
+		Name: &models.ScimNameModel{
+			Formatted:  userEntity.Name,
+			GivenName:  userEntity.GivenName,
+			FamilyName: userEntity.FamilyName,
+		},
+		DisplayName: userEntity.Name,
+		Emails:      []models.ScimEmailModel{{Value: userEntity.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &models.ScimMetaModel{
+			ResourceType: models.ScimUserResourceType,
+			Created:      &created,
+			Location:     c.baseUrl + "/Users/" + userEntity.Id.Hex(),
+		},
+	}
+}
+
+func (c *ScimService) notFound(id string, method string) *models.ErrorModel {
+	c.logger.
+		WithField("Id", id).
+		WithField("Service", "ScimService").
+		WithField("Method", method).
+		Warn("Resource not found")
+	return &models.ErrorModel{
+		Error:      models.ScimResourceNotFoundMessage,
+		StatusCode: http.StatusNotFound,
+	}
+}
+
+func (c *ScimService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "ScimService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
+
+// applyScimUser copies the writable SCIM attributes other than userName and
+// password onto the entity. The stored name is the displayName, falling back
+// to name.formatted, the given and family names and finally the userName.
+func applyScimUser(userEntity *models.UserEntity, model models.ScimUserModel) {
+	name := model.DisplayName
+	userEntity.GivenName = ""
+	userEntity.FamilyName = ""
+
+	if model.Name != nil {
+		userEntity.GivenName = model.Name.GivenName
+		userEntity.FamilyName = model.Name.FamilyName
+
+		if name == "" {
+			name = model.Name.Formatted
+		}
+	}
+
+	if name == "" {
+		name = strings.TrimSpace(userEntity.GivenName + " " + userEntity.FamilyName)
+	}
+
+	if name == "" {
+		name = model.UserName
+	}
+
+	userEntity.Name = name
+	userEntity.ExternalId = model.ExternalId
+
+	if model.Active != nil {
+		userEntity.Disabled = !*model.Active
+	}
+}
+
+// applyScimPatchOperation applies one add, replace or remove operation and
+// reports whether it was valid. Operations without a path carry an object of
+// attributes to add or replace.
+func applyScimPatchOperation(model *models.ScimUserModel, operation models.ScimPatchOperationModel) bool {
+	path := strings.ToLower(strings.TrimPrefix(operation.Path, models.ScimUserSchema+":"))
+
+	switch strings.ToLower(operation.Op) {
+	case "add", "replace":
+		if path != "" {
+			return setScimUserAttribute(model, path, operation.Value)
+		}
+
+		attributes, ok := operation.Value.(map[string]interface{})
+
+		if !ok {
+			return false
+		}
+
+		for attribute, value := range attributes {
+			attribute = strings.ToLower(strings.TrimPrefix(attribute, models.ScimUserSchema+":"))
+
+			if !setScimUserAttribute(model, attribute, value) {
+				return false
+			}
+		}
+		return true
+	case "remove":
+		return removeScimUserAttribute(model, path)
+	}
+
+	return false
+}
+
+func setScimUserAttribute(model *models.ScimUserModel, path string, value interface{}) bool {
+	if model.Name == nil {
+		model.Name = &models.ScimNameModel{}
+	}
+
+	text, isText := value.(string)
+
+	switch {
+	case path == "username" || path == "emails.value" ||
+		strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+		model.UserName = text
+		return isText
+	case path == "displayname" || path == "name.formatted":
+		// Both are stored as the user's name.
+		model.DisplayName = text
+		model.Name.Formatted = text
+		return isText
+	case path == "name.givenname":
+		model.Name.GivenName = text
+		return isText
+	case path == "name.familyname":
+		model.Name.FamilyName = text
+		return isText
+	case path == "externalid":
+		model.ExternalId = text
+		return isText
+	case path == "password":
+		model.Password = text
+		return isText
+	case path == "active":
+		// Some identity providers send booleans as strings.
+		active, isBool := value.(bool)
+
+		if isText && (strings.EqualFold(text, "true") || strings.EqualFold(text, "false")) {
+			active, isBool = strings.EqualFold(text, "true"), true
+		}
+
+		model.Active = &active
+		return isBool
+	case path == "name":
+		attributes, ok := value.(map[string]interface{})
+
+		if !ok {
+			return false
+		}
+
+		for attribute, attributeValue := range attributes {
+			if !setScimUserAttribute(model, "name."+strings.ToLower(attribute), attributeValue) {
+				return false
+			}
+		}
+		return true
+	case path == "emails":
+		emails, ok := value.([]interface{})
+
+		if !ok || len(emails) == 0 {
+			return false
+		}
+
+		// The primary email, or else the first one, becomes the userName.
+		email, _ := emails[0].(map[string]interface{})
+
+		for _, item := range emails {
+			if candidate, ok := item.(map[string]interface{}); ok && candidate["primary"] == true {
+				email = candidate
+			}
+		}
+
+		text, isText = email["value"].(string)
+		model.UserName = text
+		return isText
+	}
+
+	return false
+}
+
+// removeScimUserAttribute clears an optional attribute. userName, active and
+// password cannot be removed.
+func removeScimUserAttribute(model *models.ScimUserModel, path string) bool {
+	switch path {
+	case "externalid":
+		model.ExternalId = ""
+	case "displayname", "name.formatted":
+		model.DisplayName = ""
+		if model.Name != nil {
+			model.Name.Formatted = ""
+		}
+	case "name.givenname":
+		if model.Name != nil {
+			model.Name.GivenName = ""
+		}
+	case "name.familyname":
+		if model.Name != nil {
+			model.Name.FamilyName = ""
+		}
+	case "name":
+		model.Name = nil
+		model.DisplayName = ""
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+type ISessionService interface {
+	GetSessions(context context.Context, model models.ListSessionsModel) (
+		responseModel []models.SessionResponseModel, errorModel *models.ErrorModel)
+	RevokeSession(context context.Context, model models.RevokeSessionModel) (errorModel *models.ErrorModel)
+	RevokeOtherSessions(context context.Context, model models.RevokeSessionsModel) (errorModel *models.ErrorModel)
+}
+
+// SessionService lists and ends a user's logins. Ending a session revokes its
+// refresh tokens; access tokens already issued for it remain valid until
+// they expire.
+type SessionService struct {
+	validator    validators.IUserValidator
+	tokenService ITokenService
+	logger       *logrus.Logger
+}
+
+func NewSessionService(validator validators.IUserValidator, tokenService ITokenService,
+	logger *logrus.Logger) *SessionService {
+	return &SessionService{validator: validator, tokenService: tokenService, logger: logger}
+}
+
+// GetSessions returns the sessions that can still be refreshed, most
+// recently used first.
+func (c *SessionService) GetSessions(context context.Context, model models.ListSessionsModel) (
+	responseModel []models.SessionResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateListSessionsModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+
+	familyIds, err := helpers.RefreshTokenCollection.Distinct(context, "FamilyId", bson.D{
+		{"UserId", userId},
+		{"UsedAt", nil},
+		{"RevokedAt", nil},
+		{"ExpiresAt", bson.D{{"$gt", time.Now().UTC()}}}})
+
+	if err != nil {
+		return responseModel, c.internalError("GetSessions", "Distinct", err)
+	}
+
+	responseModel = []models.SessionResponseModel{}
+
+	if len(familyIds) == 0 {
+		return responseModel, nil
+	}
+
+	cursor, err := helpers.SessionCollection.Find(context,
+		bson.D{{"_id", bson.D{{"$in", familyIds}}}, {"UserId", userId}},
+		options.Find().SetSort(bson.D{{"LastSeenAt", -1}}))
+
+	if err != nil {
+		return responseModel, c.internalError("GetSessions", "Find", err)
+	}
+
+	var sessions []models.SessionEntity
+
+	if err = cursor.All(context, &sessions); err != nil {
+		return responseModel, c.internalError("GetSessions", "All", err)
+	}
+
+	for _, session := range sessions {
+		responseModel = append(responseModel, models.SessionResponseModel{
+			Id:         session.Id.Hex(),
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			ClientIp:   session.ClientIp,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.Id.Hex() == model.CurrentSessionId,
+		})
+	}
+
+	return responseModel, nil
+}
+
+func (c *SessionService) RevokeSession(context context.Context, model models.RevokeSessionModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateRevokeSessionModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	var session models.SessionEntity
+
+	err := helpers.SessionCollection.FindOne(context, bson.D{{"_id", objID}, {"UserId", userId}}).Decode(&session)
+
+	if err == mongo.ErrNoDocuments {
+		return &models.ErrorModel{
+			Error:      models.SessionNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	if err != nil {
+		return c.internalError("RevokeSession", "FindOne", err)
+	}
+
+	error = c.tokenService.RevokeSession(context, session.Id)
+
+	if error != nil {
+		return error
+	}
+
+	c.logger.
+		WithField("UserId", model.UserId).
+		WithField("SessionId", model.Id).
+		WithField("Service", "SessionService").
+		WithField("Method", "RevokeSession").
+		Info("Session revoked")
+
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except the session the
+// request was made from. Without a current session every session ends.
+func (c *SessionService) RevokeOtherSessions(context context.Context, model models.RevokeSessionsModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateRevokeSessionsModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	userId, _ := primitive.ObjectIDFromHex(model.UserId)
+	currentSessionId, _ := primitive.ObjectIDFromHex(model.CurrentSessionId)
+
+	error = c.tokenService.RevokeOtherSessions(context, userId, currentSessionId)
+
+	if error != nil {
+		return error
+	}
+
+	c.logger.
+		WithField("UserId", model.UserId).
+		WithField("CurrentSessionId", model.CurrentSessionId).
+		WithField("Service", "SessionService").
+		WithField("Method", "RevokeOtherSessions").
+		Info("Other sessions revoked")
+
+	return nil
+}
+
+func (c *SessionService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "SessionService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
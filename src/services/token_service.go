@@ -0,0 +1,434 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+)
+
+const defaultRefreshTokenTtl = 30 * 24 * time.Hour
+
+type ITokenService interface {
+	IssueTokens(context context.Context, userEntity models.UserEntity, client models.ClientInfoModel) (
+		responseModel models.LoginResponseModel, errorModel *models.ErrorModel)
+	IssueRestrictedToken(context context.Context, userEntity models.UserEntity, scope string) (
+		responseModel models.LoginResponseModel, errorModel *models.ErrorModel)
+	Refresh(context context.Context, refreshToken string, client models.ClientInfoModel) (
+		responseModel models.LoginResponseModel, errorModel *models.ErrorModel)
+	Revoke(context context.Context, refreshToken string) (errorModel *models.ErrorModel)
+	RevokeUserTokens(context context.Context, userId primitive.ObjectID) (errorModel *models.ErrorModel)
+	RevokeSession(context context.Context, sessionId primitive.ObjectID) (errorModel *models.ErrorModel)
+	RevokeOtherSessions(context context.Context, userId primitive.ObjectID, keepSessionId primitive.ObjectID) (
+		errorModel *models.ErrorModel)
+}
+
+// TokenService issues access tokens together with rotating refresh tokens.
+// Every refresh token belongs to a family started at login; presenting a
+// token that was already rotated revokes the whole family. A family is also
+// the user's session: its id is the session id carried in access tokens.
+type TokenService struct {
+	tokenHelper     helpers.ITokenHelper
+	refreshTokenTtl time.Duration
+	passwordMaxAge  time.Duration
+	logger          *logrus.Logger
+}
+
+func NewTokenService(tokenHelper helpers.ITokenHelper, config configuration.JwtConfigurations,
+	passwordPolicyConfig configuration.PasswordPolicyConfigurations, logger *logrus.Logger) *TokenService {
+	refreshTokenTtl := config.RefreshTokenTtl
+	if refreshTokenTtl <= 0 {
+		refreshTokenTtl = defaultRefreshTokenTtl
+	}
+	return &TokenService{tokenHelper: tokenHelper, refreshTokenTtl: refreshTokenTtl,
+		passwordMaxAge: passwordPolicyConfig.MaxAge, logger: logger}
+}
+
+// IssueTokens starts a new session for the client and issues its first pair
+// of tokens.
+func (c *TokenService) IssueTokens(context context.Context, userEntity models.UserEntity,
+	client models.ClientInfoModel) (responseModel models.LoginResponseModel, errorModel *models.ErrorModel) {
+
+	now := time.Now().UTC()
+
+	sessionEntity := models.SessionEntity{
+		Id:         primitive.NewObjectID(),
+		UserId:     userEntity.Id,
+		Device:     helpers.DescribeDevice(client.UserAgent),
+		UserAgent:  client.UserAgent,
+		ClientIp:   client.ClientIp,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	_, err := helpers.SessionCollection.InsertOne(context, sessionEntity)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "IssueTokens").
+			WithField("Operation", "InsertOne").
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return c.issueTokens(context, userEntity, sessionEntity.Id, primitive.NewObjectID(), client.ClientId)
+}
+
+// IssueRestrictedToken issues only a scoped access token. No refresh token is
+// created, so the session ends once the scoped work is done or it expires.
+func (c *TokenService) IssueRestrictedToken(context context.Context, userEntity models.UserEntity, scope string) (
+	responseModel models.LoginResponseModel, errorModel *models.ErrorModel) {
+
+	accessToken, claims, err := c.tokenHelper.IssueRestrictedAccessToken(userEntity, scope)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "IssueRestrictedToken").
+			WithField("Operation", "IssueRestrictedAccessToken").
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return models.LoginResponseModel{
+		AccessToken:            accessToken,
+		TokenType:              models.BearerTokenType,
+		ExpiresIn:              int64(claims.ExpiresAt.Sub(claims.IssuedAt.Time).Seconds()),
+		ExpiresAt:              claims.ExpiresAt.Time,
+		Issuer:                 claims.Issuer,
+		Audience:               claims.Audience,
+		UserId:                 userEntity.Id.Hex(),
+		Scope:                  scope,
+		PasswordChangeRequired: scope == models.PasswordChangeScope,
+	}, nil
+}
+
+func (c *TokenService) issueTokens(context context.Context, userEntity models.UserEntity, familyId primitive.ObjectID,
+	refreshTokenId primitive.ObjectID, clientId primitive.ObjectID) (responseModel models.LoginResponseModel,
+	errorModel *models.ErrorModel) {
+
+	accessToken, claims, err := c.tokenHelper.IssueAccessToken(userEntity, familyId.Hex())
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "IssueTokens").
+			WithField("Operation", "IssueAccessToken").
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	refreshToken, err := helpers.GenerateOpaqueToken()
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "IssueTokens").
+			WithField("Operation", "GenerateOpaqueToken").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	now := time.Now().UTC()
+
+	refreshTokenEntity := models.RefreshTokenEntity{
+		Id:        refreshTokenId,
+		UserId:    userEntity.Id,
+		FamilyId:  familyId,
+		ClientId:  clientId,
+		TokenHash: helpers.HashOpaqueToken(refreshToken),
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.refreshTokenTtl),
+	}
+
+	_, err = helpers.RefreshTokenCollection.InsertOne(context, refreshTokenEntity)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "IssueTokens").
+			WithField("Operation", "InsertOne").
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return models.LoginResponseModel{
+		AccessToken:           accessToken,
+		TokenType:             models.BearerTokenType,
+		ExpiresIn:             int64(claims.ExpiresAt.Sub(claims.IssuedAt.Time).Seconds()),
+		ExpiresAt:             claims.ExpiresAt.Time,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshTokenEntity.ExpiresAt,
+		Issuer:                claims.Issuer,
+		Audience:              claims.Audience,
+		UserId:                userEntity.Id.Hex(),
+	}, nil
+}
+
+// Refresh rotates a refresh token. Tokens are bound to the client they were
+// issued to: OAuth clients can only refresh their own tokens, and the
+// service's own refresh only takes tokens issued without a client.
+func (c *TokenService) Refresh(context context.Context, refreshToken string, client models.ClientInfoModel) (
+	responseModel models.LoginResponseModel, errorModel *models.ErrorModel) {
+
+	invalidToken := &models.ErrorModel{
+		Error:      models.InvalidRefreshTokenMessage,
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	now := time.Now().UTC()
+	tokenHash := helpers.HashOpaqueToken(refreshToken)
+	replacementId := primitive.NewObjectID()
+
+	sameClient := bson.E{"ClientId", client.ClientId}
+	if client.ClientId.IsZero() {
+		sameClient = bson.E{"ClientId", bson.D{{"$exists", false}}}
+	}
+
+	var refreshTokenEntity models.RefreshTokenEntity
+
+	err := helpers.RefreshTokenCollection.FindOneAndUpdate(context,
+		bson.D{
+			{"TokenHash", tokenHash},
+			sameClient,
+			{"UsedAt", nil},
+			{"RevokedAt", nil},
+			{"ExpiresAt", bson.D{{"$gt", now}}}},
+		bson.D{{"$set", bson.D{
+			{"UsedAt", now},
+			{"ReplacedBy", replacementId}}}}).
+		Decode(&refreshTokenEntity)
+
+	if err == mongo.ErrNoDocuments {
+		c.detectReuse(context, tokenHash)
+		return responseModel, invalidToken
+	}
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Refresh").
+			WithField("Operation", "FindOneAndUpdate").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	var userEntity models.UserEntity
+
+	err = helpers.UserCollection.FindOne(context, bson.D{{"_id", refreshTokenEntity.UserId}, activeUser}).
+		Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("Service", "TokenService").
+				WithField("Method", "Refresh").
+				WithField("Operation", "FindOne").
+				WithField("UserId", refreshTokenEntity.UserId.Hex()).
+				Warn("UserNotFound")
+			c.revokeFamily(context, refreshTokenEntity.FamilyId)
+			return responseModel, invalidToken
+		}
+
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Refresh").
+			WithField("Operation", "FindOne").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	if userEntity.Disabled {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Refresh").
+			WithField("UserId", userEntity.Id.Hex()).
+			Warn("Account disabled")
+		c.revokeFamily(context, refreshTokenEntity.FamilyId)
+		return responseModel, invalidToken
+	}
+
+	// Like a login, the session of a user whose password expired may only be
+	// used to choose a new password. It ends here; the restricted token has
+	// no refresh token to keep it going.
+	if passwordExpired(userEntity, c.passwordMaxAge) {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Refresh").
+			WithField("UserId", userEntity.Id.Hex()).
+			Warn("Password expired")
+		c.revokeFamily(context, refreshTokenEntity.FamilyId)
+		return c.IssueRestrictedToken(context, userEntity, models.PasswordChangeScope)
+	}
+
+	// Session activity is informational and must not fail the refresh.
+	_, err = helpers.SessionCollection.UpdateOne(context, bson.D{{"_id", refreshTokenEntity.FamilyId}},
+		bson.D{{"$set", bson.D{{"LastSeenAt", now}, {"ClientIp", client.ClientIp}}}})
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Refresh").
+			WithField("Operation", "UpdateOne").
+			WithField("FamilyId", refreshTokenEntity.FamilyId.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+	}
+
+	return c.issueTokens(context, userEntity, refreshTokenEntity.FamilyId, replacementId, refreshTokenEntity.ClientId)
+}
+
+// detectReuse revokes the family of a refresh token that was presented after
+// it had already been rotated or revoked.
+func (c *TokenService) detectReuse(context context.Context, tokenHash string) {
+	var refreshTokenEntity models.RefreshTokenEntity
+
+	err := helpers.RefreshTokenCollection.FindOne(context, bson.D{{"TokenHash", tokenHash}}).
+		Decode(&refreshTokenEntity)
+
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			c.logger.
+				WithField("Service", "TokenService").
+				WithField("Method", "Refresh").
+				WithField("Operation", "FindOne").
+				WithField("Error", err.Error()).
+				Error("")
+		}
+		return
+	}
+
+	if refreshTokenEntity.UsedAt == nil && refreshTokenEntity.RevokedAt == nil {
+		return
+	}
+
+	c.logger.
+		WithField("Service", "TokenService").
+		WithField("Method", "Refresh").
+		WithField("UserId", refreshTokenEntity.UserId.Hex()).
+		WithField("FamilyId", refreshTokenEntity.FamilyId.Hex()).
+		Warn("Refresh token reuse detected")
+
+	c.revokeFamily(context, refreshTokenEntity.FamilyId)
+}
+
+func (c *TokenService) Revoke(context context.Context, refreshToken string) (errorModel *models.ErrorModel) {
+	var refreshTokenEntity models.RefreshTokenEntity
+
+	err := helpers.RefreshTokenCollection.FindOne(context,
+		bson.D{{"TokenHash", helpers.HashOpaqueToken(refreshToken)}}).
+		Decode(&refreshTokenEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Revoke").
+			WithField("Operation", "FindOne").
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return c.revokeFamily(context, refreshTokenEntity.FamilyId)
+}
+
+func (c *TokenService) revokeFamily(context context.Context, familyId primitive.ObjectID) (
+	errorModel *models.ErrorModel) {
+	return c.revokeMany(context, bson.D{{"FamilyId", familyId}, {"RevokedAt", nil}}, "FamilyId", familyId)
+}
+
+func (c *TokenService) RevokeUserTokens(context context.Context, userId primitive.ObjectID) (
+	errorModel *models.ErrorModel) {
+	return c.revokeMany(context, bson.D{{"UserId", userId}, {"RevokedAt", nil}}, "UserId", userId)
+}
+
+// RevokeSession ends a session by revoking its refresh token family. Access
+// tokens already issued for it stay valid until they expire.
+func (c *TokenService) RevokeSession(context context.Context, sessionId primitive.ObjectID) (
+	errorModel *models.ErrorModel) {
+	return c.revokeFamily(context, sessionId)
+}
+
+func (c *TokenService) RevokeOtherSessions(context context.Context, userId primitive.ObjectID,
+	keepSessionId primitive.ObjectID) (errorModel *models.ErrorModel) {
+	return c.revokeMany(context, bson.D{
+		{"UserId", userId},
+		{"RevokedAt", nil},
+		{"FamilyId", bson.D{{"$ne", keepSessionId}}}}, "UserId", userId)
+}
+
+func (c *TokenService) revokeMany(context context.Context, filter bson.D, field string,
+	id primitive.ObjectID) (errorModel *models.ErrorModel) {
+
+	updateResult, err := helpers.RefreshTokenCollection.UpdateMany(context, filter,
+		bson.D{{"$set", bson.D{{"RevokedAt", time.Now().UTC()}}}})
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "TokenService").
+			WithField("Method", "Revoke").
+			WithField("Operation", "UpdateMany").
+			WithField(field, id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	c.logger.
+		WithField("Service", "TokenService").
+		WithField("Method", "Revoke").
+		WithField(field, id.Hex()).
+		WithField("RevokedCount", updateResult.ModifiedCount).
+		Info("Refresh tokens revoked")
+
+	return nil
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+const defaultRecoveryCodeCount = 10
+
+type ITwoFactorService interface {
+	Enroll(context context.Context, model models.TwoFactorModel) (
+		responseModel models.TwoFactorEnrollmentResponseModel, errorModel *models.ErrorModel)
+	ConfirmEnrollment(context context.Context, model models.TwoFactorCodeModel) (
+		responseModel models.RecoveryCodesResponseModel, errorModel *models.ErrorModel)
+	RegenerateRecoveryCodes(context context.Context, model models.TwoFactorCodeModel) (
+		responseModel models.RecoveryCodesResponseModel, errorModel *models.ErrorModel)
+	Reset(context context.Context, model models.TwoFactorModel) (errorModel *models.ErrorModel)
+	VerifyLoginCode(context context.Context, userEntity models.UserEntity, code string) (
+		errorModel *models.ErrorModel)
+}
+
+// TwoFactorService manages TOTP enrollment. Secrets are stored encrypted and
+// recovery codes are stored hashed; both are only shown to the user once.
+type TwoFactorService struct {
+	validator         validators.IUserValidator
+	encryptor         helpers.ISecretEncryptor
+	issuer            string
+	recoveryCodeCount int
+	logger            *logrus.Logger
+}
+
+func NewTwoFactorService(validator validators.IUserValidator, encryptor helpers.ISecretEncryptor,
+	config configuration.TwoFactorConfigurations, logger *logrus.Logger) *TwoFactorService {
+	recoveryCodeCount := config.RecoveryCodeCount
+	if recoveryCodeCount <= 0 {
+		recoveryCodeCount = defaultRecoveryCodeCount
+	}
+	return &TwoFactorService{validator: validator, encryptor: encryptor, issuer: config.Issuer,
+		recoveryCodeCount: recoveryCodeCount, logger: logger}
+}
+
+// Enroll starts enrollment with a new pending secret. Calling it again
+// replaces the pending secret until the enrollment is confirmed.
+func (c *TwoFactorService) Enroll(context context.Context, model models.TwoFactorModel) (
+	responseModel models.TwoFactorEnrollmentResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateTwoFactorModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	userEntity, error := c.findUser(context, model.Id, "Enroll")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	if userEntity.TwoFactorEnabled {
+		return responseModel, &models.ErrorModel{
+			Error:      models.TwoFactorAlreadyEnabledMessage,
+			StatusCode: http.StatusConflict,
+		}
+	}
+
+	secret, err := helpers.GenerateTotpSecret()
+
+	if err != nil {
+		return responseModel, c.internalError("Enroll", "GenerateTotpSecret", err)
+	}
+
+	encrypted, err := c.encryptor.Encrypt(secret)
+
+	if err != nil {
+		return responseModel, c.internalError("Enroll", "Encrypt", err)
+	}
+
+	_, err = helpers.UserCollection.UpdateOne(context,
+		bson.D{{"_id", userEntity.Id}},
+		bson.D{{"$set", bson.D{{"TwoFactorPendingSecret", encrypted}}}, incrementVersion})
+
+	if err != nil {
+		return responseModel, c.internalError("Enroll", "UpdateOne", err)
+	}
+
+	responseModel.Secret = secret
+	responseModel.Uri = helpers.TotpUri(c.issuer, userEntity.Email, secret)
+
+	return responseModel, nil
+}
+
+func (c *TwoFactorService) ConfirmEnrollment(context context.Context, model models.TwoFactorCodeModel) (
+	responseModel models.RecoveryCodesResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateTwoFactorCodeModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	userEntity, error := c.findUser(context, model.Id, "ConfirmEnrollment")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	if userEntity.TwoFactorEnabled {
+		return responseModel, &models.ErrorModel{
+			Error:      models.TwoFactorAlreadyEnabledMessage,
+			StatusCode: http.StatusConflict,
+		}
+	}
+
+	notEnrolling := &models.ErrorModel{
+		Error:      models.TwoFactorNotEnrollingMessage,
+		StatusCode: http.StatusConflict,
+	}
+
+	if userEntity.TwoFactorPendingSecret == "" {
+		return responseModel, notEnrolling
+	}
+
+	secret, err := c.encryptor.Decrypt(userEntity.TwoFactorPendingSecret)
+
+	if err != nil {
+		return responseModel, c.internalError("ConfirmEnrollment", "Decrypt", err)
+	}
+
+	step, valid := helpers.ValidateTotpCode(secret, model.Code, time.Now())
+
+	if !valid {
+		c.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "TwoFactorService").
+			WithField("Method", "ConfirmEnrollment").
+			Warn("Invalid code")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InvalidTwoFactorCodeMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	codes, hashes, err := c.generateRecoveryCodes()
+
+	if err != nil {
+		return responseModel, c.internalError("ConfirmEnrollment", "GenerateRecoveryCodes", err)
+	}
+
+	// Matching on the pending secret keeps a concurrent Enroll from being
+	// confirmed with a code for the secret it replaced.
+	updateResult, err := helpers.UserCollection.UpdateOne(context,
+		bson.D{{"_id", userEntity.Id}, {"TwoFactorPendingSecret", userEntity.TwoFactorPendingSecret}},
+		bson.D{
+			{"$set", bson.D{
+				{"TwoFactorEnabled", true},
+				{"TwoFactorSecret", userEntity.TwoFactorPendingSecret},
+				{"TwoFactorLastUsedStep", step},
+				{"RecoveryCodes", hashes}}},
+			{"$unset", bson.D{{"TwoFactorPendingSecret", ""}}},
+			incrementVersion})
+
+	if err != nil {
+		return responseModel, c.internalError("ConfirmEnrollment", "UpdateOne", err)
+	}
+
+	if updateResult.MatchedCount == 0 {
+		return responseModel, notEnrolling
+	}
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "TwoFactorService").
+		WithField("Method", "ConfirmEnrollment").
+		Info("Two-factor authentication enabled")
+
+	responseModel.RecoveryCodes = codes
+
+	return responseModel, nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a
+// current code, so a stolen access token alone cannot read new codes.
+func (c *TwoFactorService) RegenerateRecoveryCodes(context context.Context, model models.TwoFactorCodeModel) (
+	responseModel models.RecoveryCodesResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateTwoFactorCodeModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	userEntity, error := c.findUser(context, model.Id, "RegenerateRecoveryCodes")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	if !userEntity.TwoFactorEnabled {
+		return responseModel, &models.ErrorModel{
+			Error:      models.TwoFactorNotEnabledMessage,
+			StatusCode: http.StatusConflict,
+		}
+	}
+
+	error = c.verifyCode(context, userEntity, model.Code, "RegenerateRecoveryCodes")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	codes, hashes, err := c.generateRecoveryCodes()
+
+	if err != nil {
+		return responseModel, c.internalError("RegenerateRecoveryCodes", "GenerateRecoveryCodes", err)
+	}
+
+	_, err = helpers.UserCollection.UpdateOne(context,
+		bson.D{{"_id", userEntity.Id}},
+		bson.D{{"$set", bson.D{{"RecoveryCodes", hashes}}}, incrementVersion})
+
+	if err != nil {
+		return responseModel, c.internalError("RegenerateRecoveryCodes", "UpdateOne", err)
+	}
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "TwoFactorService").
+		WithField("Method", "RegenerateRecoveryCodes").
+		Info("Recovery codes regenerated")
+
+	responseModel.RecoveryCodes = codes
+
+	return responseModel, nil
+}
+
+// Reset disables two-factor authentication and discards the secret and
+// recovery codes. It is meant for administrators helping a locked out user.
+func (c *TwoFactorService) Reset(context context.Context, model models.TwoFactorModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateTwoFactorModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	updateResult, err := helpers.UserCollection.UpdateOne(context,
+		bson.D{{"_id", objID}},
+		bson.D{
+			{"$set", bson.D{{"TwoFactorEnabled", false}}},
+			{"$unset", bson.D{
+				{"TwoFactorSecret", ""},
+				{"TwoFactorPendingSecret", ""},
+				{"TwoFactorLastUsedStep", ""},
+				{"RecoveryCodes", ""}}},
+			incrementVersion})
+
+	if err != nil {
+		return c.internalError("Reset", "UpdateOne", err)
+	}
+
+	if updateResult.MatchedCount == 0 {
+		return &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "TwoFactorService").
+		WithField("Method", "Reset").
+		Info("Two-factor authentication reset")
+
+	return nil
+}
+
+func (c *TwoFactorService) VerifyLoginCode(context context.Context, userEntity models.UserEntity, code string) (
+	errorModel *models.ErrorModel) {
+
+	if code == "" {
+		return &models.ErrorModel{
+			Error:      models.TwoFactorRequiredMessage,
+			StatusCode: http.StatusUnauthorized,
+		}
+	}
+
+	error := c.verifyCode(context, userEntity, code, "VerifyLoginCode")
+
+	if error != nil && error.StatusCode == http.StatusBadRequest {
+		error.StatusCode = http.StatusUnauthorized
+	}
+
+	return error
+}
+
+// verifyCode accepts a TOTP code or an unused recovery code. Both are consumed
+// with a conditional update so the same code cannot be used twice.
+func (c *TwoFactorService) verifyCode(context context.Context, userEntity models.UserEntity, code string,
+	method string) *models.ErrorModel {
+
+	invalidCode := &models.ErrorModel{
+		Error:      models.InvalidTwoFactorCodeMessage,
+		StatusCode: http.StatusBadRequest,
+	}
+
+	secret, err := c.encryptor.Decrypt(userEntity.TwoFactorSecret)
+
+	if err != nil {
+		return c.internalError(method, "Decrypt", err)
+	}
+
+	var updateResult *mongo.UpdateResult
+
+	if step, valid := helpers.ValidateTotpCode(secret, code, time.Now()); valid {
+		updateResult, err = helpers.UserCollection.UpdateOne(context,
+			bson.D{{"_id", userEntity.Id}, {"$or", bson.A{
+				bson.D{{"TwoFactorLastUsedStep", bson.D{{"$lt", step}}}},
+				bson.D{{"TwoFactorLastUsedStep", bson.D{{"$exists", false}}}}}}},
+			bson.D{{"$set", bson.D{{"TwoFactorLastUsedStep", step}}}, incrementVersion})
+	} else {
+		hash := helpers.HashRecoveryCode(code)
+		updateResult, err = helpers.UserCollection.UpdateOne(context,
+			bson.D{{"_id", userEntity.Id}, {"RecoveryCodes", hash}},
+			bson.D{{"$pull", bson.D{{"RecoveryCodes", hash}}}, incrementVersion})
+	}
+
+	if err != nil {
+		return c.internalError(method, "UpdateOne", err)
+	}
+
+	if updateResult.MatchedCount == 0 {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "TwoFactorService").
+			WithField("Method", method).
+			Warn("Invalid or reused code")
+		return invalidCode
+	}
+
+	return nil
+}
+
+func (c *TwoFactorService) findUser(context context.Context, id string, method string) (
+	userEntity models.UserEntity, errorModel *models.ErrorModel) {
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return userEntity, &models.ErrorModel{
+				Error:      models.UserNotFoundErrorMessage,
+				StatusCode: http.StatusNotFound,
+			}
+		}
+		return userEntity, c.internalError(method, "FindOne", err)
+	}
+
+	return userEntity, nil
+}
+
+func (c *TwoFactorService) generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	codes, err = helpers.GenerateRecoveryCodes(c.recoveryCodeCount)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes = make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = helpers.HashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+func (c *TwoFactorService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "TwoFactorService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
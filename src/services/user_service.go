@@ -2,16 +2,51 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"user-management-service/src/configuration"
 	"user-management-service/src/helpers"
 	"user-management-service/src/models"
 	"user-management-service/src/validators"
 )
 
+const (
+	defaultPageLimit    = 20
+	defaultMaxPageLimit = 100
+	userSearchIndexName = "UserSearchIndex"
+	// Deleted users can be restored for 30 days by default.
+	defaultDeletionRetentionPeriod = 30 * 24 * time.Hour
+	defaultPurgeInterval           = time.Hour
+	// indexNotFoundCode is returned by $text queries when the collection has
+	// no text index.
+	indexNotFoundCode = 27
+)
+
+// activeUser matches the users that have not been soft deleted. Lookups of
+// users include it everywhere except in the deleted users endpoints.
+var activeUser = bson.E{Key: "DeletedAt", Value: bson.D{{"$exists", false}}}
+
+// incrementVersion is added to every update of a user, so the version sent
+// as the ETag changes with each write.
+var incrementVersion = bson.E{Key: "$inc", Value: bson.D{{"Version", 1}}}
+
+// userSortFields maps the sort fields accepted by GetAllUsers to the stored
+// fields. Users are created in _id order, so it doubles as the creation time.
+var userSortFields = map[string]string{
+	models.NameSortField:      "Name",
+	models.EmailSortField:     "Email",
+	models.CreatedAtSortField: "_id",
+}
+
 type IUserService interface {
 	AddUser(context context.Context, model models.AddUserModel) (responseModel models.
 		AddUserResponseModel,
@@ -19,22 +54,70 @@ type IUserService interface {
 	UpdateUser(context context.Context, model models.UpdateUserModel) (responseModel models.
 		UpdateUserResponseModel,
 		errorModel *models.ErrorModel)
-	GetAllUsers(ctx context.Context) (responseModel []models.GetUserResponseModel,
+	PatchUser(context context.Context, model models.PatchUserModel) (responseModel models.UpdateUserResponseModel,
 		errorModel *models.ErrorModel)
+	GetAllUsers(context context.Context, model models.ListUsersModel) (responseModel models.UserListResponseModel,
+		errorModel *models.ErrorModel)
+	SearchUsers(context context.Context, model models.SearchUsersModel) (
+		responseModel models.UserSearchResponseModel, errorModel *models.ErrorModel)
 	GetUser(context context.Context, model models.GetUserModel) (responseModel models.
 		GetUserResponseModel,
 		errorModel *models.ErrorModel)
 	DeleteUser(context context.Context, model models.DeleteUserModel) (
 		errorModel *models.ErrorModel)
+	RestoreUser(context context.Context, model models.RestoreUserModel) (
+		responseModel models.GetUserResponseModel, errorModel *models.ErrorModel)
+	ListDeletedUsers(context context.Context, model models.ListDeletedUsersModel) (
+		responseModel models.UserListResponseModel, errorModel *models.ErrorModel)
+	VerifyCredentials(context context.Context, email string, password string) (userEntity models.UserEntity,
+		errorModel *models.ErrorModel)
+	GrantRole(context context.Context, model models.UserRoleModel) (responseModel models.GetUserResponseModel,
+		errorModel *models.ErrorModel)
+	RevokeRole(context context.Context, model models.UserRoleModel) (responseModel models.GetUserResponseModel,
+		errorModel *models.ErrorModel)
+	SetPassword(context context.Context, userId primitive.ObjectID, password string) (
+		errorModel *models.ErrorModel)
+	PreparePassword(context context.Context, userEntity models.UserEntity, password string) (set bson.D,
+		update bson.D, errorModel *models.ErrorModel)
+	CheckNotLastAdmin(context context.Context, userId primitive.ObjectID, method string) (
+		errorModel *models.ErrorModel)
+	ChangePassword(context context.Context, model models.ChangePasswordModel) (errorModel *models.ErrorModel)
+	ExpirePassword(context context.Context, model models.ExpirePasswordModel) (errorModel *models.ErrorModel)
+	PasswordExpired(userEntity models.UserEntity) bool
 }
 
 type UserService struct {
-	validator validators.IUserValidator
-	logger    *logrus.Logger
+	validator                validators.IUserValidator
+	hasher                   helpers.IPasswordHasher
+	tokenService             ITokenService
+	emailVerificationService IEmailVerificationService
+	passwordHistorySize      int
+	passwordMaxAge           time.Duration
+	defaultPageLimit         int
+	maxPageLimit             int
+	cursorSigner             helpers.ICursorSigner
+	logger                   *logrus.Logger
 }
 
-func NewUserService(validator validators.IUserValidator, logger *logrus.Logger) *UserService {
-	return &UserService{validator: validator, logger: logger}
+func NewUserService(validator validators.IUserValidator, hasher helpers.IPasswordHasher, tokenService ITokenService,
+	emailVerificationService IEmailVerificationService,
+	passwordPolicyConfig configuration.PasswordPolicyConfigurations,
+	paginationConfig configuration.PaginationConfigurations, logger *logrus.Logger) *UserService {
+	service := &UserService{validator: validator, hasher: hasher, tokenService: tokenService,
+		emailVerificationService: emailVerificationService, passwordHistorySize: passwordPolicyConfig.HistorySize,
+		passwordMaxAge: passwordPolicyConfig.MaxAge, defaultPageLimit: paginationConfig.DefaultLimit,
+		maxPageLimit: paginationConfig.MaxLimit, cursorSigner: helpers.NewCursorSigner(paginationConfig.CursorSecret),
+		logger: logger}
+	if service.maxPageLimit <= 0 {
+		service.maxPageLimit = defaultMaxPageLimit
+	}
+	if service.defaultPageLimit <= 0 {
+		service.defaultPageLimit = defaultPageLimit
+	}
+	if service.defaultPageLimit > service.maxPageLimit {
+		service.defaultPageLimit = service.maxPageLimit
+	}
+	return service
 }
 
 func (c *UserService) AddUser(context context.Context, model models.AddUserModel) (responseModel models.
@@ -49,11 +132,11 @@ func (c *UserService) AddUser(context context.Context, model models.AddUserModel
 
 	var user models.UserEntity
 
-	err := helpers.UserCollection.FindOne(context, bson.D{{"Email", model.Email}}).Decode(&user)
+	err := helpers.UserCollection.FindOne(context, bson.D{{"Email", model.Email}, activeUser}).Decode(&user)
 
 	if err == nil {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("Email", model.Email).
 			WithField("Service", "UserService").
 			WithField("Method", "AddUser").
 			WithField("Operation", "CountDocuments").
@@ -66,7 +149,7 @@ func (c *UserService) AddUser(context context.Context, model models.AddUserModel
 
 	if err != mongo.ErrNoDocuments {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("Email", model.Email).
 			WithField("Service", "UserService").
 			WithField("Method", "AddUser").
 			WithField("Operation", "FindOne").
@@ -78,18 +161,38 @@ func (c *UserService) AddUser(context context.Context, model models.AddUserModel
 		}
 	}
 
+	passwordHash, err := c.hasher.Hash(model.Password)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "AddUser").
+			WithField("Operation", "Hash").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	now := time.Now().UTC()
+
 	userEntity := models.UserEntity{
-		Id:       primitive.NewObjectID(),
-		Name:     model.Name,
-		Password: model.Password,
-		Email:    model.Email,
+		Id:                primitive.NewObjectID(),
+		Name:              model.Name,
+		Password:          passwordHash,
+		Email:             model.Email,
+		Roles:             []string{models.UserRole},
+		PasswordChangedAt: &now,
+		Version:           1,
 	}
 
 	_, err = helpers.UserCollection.InsertOne(context, userEntity)
 
 	if err != nil {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("Email", model.Email).
 			WithField("Service", "UserService").
 			WithField("Method", "AddUser").
 			WithField("Operation", "InsertOne").
@@ -102,11 +205,14 @@ func (c *UserService) AddUser(context context.Context, model models.AddUserModel
 	}
 
 	c.logger.
-		WithField("RequestModel", model).
+		WithField("Email", model.Email).
 		WithField("Service", "UserService").
 		WithField("Method", "AddUser").
 		Info("User Created")
 
+	// The account exists either way; the user can ask for the link again.
+	c.emailVerificationService.SendVerification(context, userEntity)
+
 	resp := models.AddUserResponseModel{
 		Id:    userEntity.Id.Hex(),
 		Name:  userEntity.Name,
@@ -125,16 +231,23 @@ func (c *UserService) UpdateUser(context context.Context, model models.UpdateUse
 		return responseModel, error
 	}
 
+	// UpdateUser always stores the given password.
+	error = c.rejectImpersonation(context, "UpdateUser", model.Id)
+
+	if error != nil {
+		return responseModel, error
+	}
+
 	var userEntity models.UserEntity
 
 	objID, _ := primitive.ObjectIDFromHex(model.Id)
 
-	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}}).Decode(&userEntity)
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.logger.
-				WithField("RequestModel", model).
+				WithField("UserId", model.Id).
 				WithField("Service", "UserService").
 				WithField("Method", "UpdateUser").
 				WithField("Operation", "FindOne").
@@ -146,7 +259,7 @@ func (c *UserService) UpdateUser(context context.Context, model models.UpdateUse
 		}
 
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("UserId", model.Id).
 			WithField("Service", "UserService").
 			WithField("Method", "UpdateUser").
 			WithField("Operation", "FindOne").
@@ -158,19 +271,66 @@ func (c *UserService) UpdateUser(context context.Context, model models.UpdateUse
 		}
 	}
 
+	if model.Version != nil && *model.Version != userEntity.Version {
+		return responseModel, c.versionConflict("UpdateUser", model.Id)
+	}
+
+	error = c.validator.ValidatePassword(model.Password, model.Name, userEntity.Email)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	passwordUnchanged, _, _ := c.hasher.Verify(model.Password, userEntity.Password)
+
+	// Sending the current password again only updates the name, so it is
+	// checked against the previous passwords alone.
+	if !passwordUnchanged {
+		error = c.checkPasswordHistory(userEntity.PasswordHistory, model.Password, "UpdateUser")
+
+		if error != nil {
+			return responseModel, error
+		}
+	}
+
+	passwordHash, err := c.hasher.Hash(model.Password)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "UpdateUser").
+			WithField("Operation", "Hash").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
 	update := bson.D{{"$set",
 		bson.D{
 			{"Name", model.Name},
-			{"Password", model.Password}}}}
+			{"Password", passwordHash}}}, incrementVersion}
+
+	if !passwordUnchanged {
+		update = bson.D{{"$set",
+			bson.D{
+				{"Name", model.Name},
+				{"Password", passwordHash},
+				{"PasswordChangedAt", time.Now().UTC()},
+				{"MustChangePassword", false}}}, incrementVersion}
+		update = append(update, c.pushPasswordHistory(userEntity.Password)...)
+	}
 
-	updateResult, err := helpers.UserCollection.UpdateByID(context, objID, update)
+	updateResult, err := helpers.UserCollection.UpdateOne(context, versionedUser(objID, model.Version), update)
 
 	if err != nil {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("UserId", model.Id).
 			WithField("Service", "UserService").
 			WithField("Method", "UpdateUser").
-			WithField("Operation", "UpdateByID").
+			WithField("Operation", "UpdateOne").
 			WithField("Error", err.Error()).
 			Error("")
 		return responseModel, &models.ErrorModel{
@@ -179,12 +339,16 @@ func (c *UserService) UpdateUser(context context.Context, model models.UpdateUse
 		}
 	}
 
+	if updateResult.MatchedCount == 0 && model.Version != nil {
+		return responseModel, c.versionConflict("UpdateUser", model.Id)
+	}
+
 	if updateResult.MatchedCount == 0 {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("UserId", model.Id).
 			WithField("Service", "UserService").
 			WithField("Method", "UpdateUser").
-			WithField("Operation", "UpdateByID").
+			WithField("Operation", "UpdateOne").
 			Warn("User not found")
 		return responseModel, &models.ErrorModel{
 			Error:      models.UserNotFoundErrorMessage,
@@ -192,120 +356,1744 @@ func (c *UserService) UpdateUser(context context.Context, model models.UpdateUse
 		}
 	}
 
+	if !passwordUnchanged {
+		error = c.tokenService.RevokeUserTokens(context, objID)
+
+		if error != nil {
+			return responseModel, error
+		}
+	}
+
 	return models.UpdateUserResponseModel{
-		Id:    model.Id,
-		Email: userEntity.Email,
-		Name:  model.Name,
+		Id:      model.Id,
+		Email:   userEntity.Email,
+		Name:    model.Name,
+		Version: userEntity.Version + 1,
 	}, nil
 
 }
 
-func (c *UserService) DeleteUser(context context.Context, model models.DeleteUserModel) (
-	errorModel *models.ErrorModel) {
-	error := c.validator.ValidateDeleteUserModel(model)
+// userChanges holds the fields set by a patch; nil fields are left as they are.
+type userChanges struct {
+	Name     *string
+	Password *string
+}
+
+// PatchUser updates only the fields present in the patch, validating each of
+// them on its own, so a user can be renamed without sending the password.
+// Changing the password signs the user out of every session like UpdateUser.
+func (c *UserService) PatchUser(context context.Context, model models.PatchUserModel) (
+	responseModel models.UpdateUserResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidatePatchUserModel(model)
 
 	if error != nil {
-		return error
+		return responseModel, error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	userEntity, error := c.findUserEntity(context, objID, "PatchUser")
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	if model.Version != nil && *model.Version != userEntity.Version {
+		return responseModel, c.versionConflict("PatchUser", model.Id)
+	}
+
+	var changes userChanges
+
+	if model.Operations != nil {
+		error = applyUserJsonPatch(&changes, userEntity, model.Operations)
+	} else {
+		error = applyUserMergePatch(&changes, userEntity, model.MergePatch)
+	}
+
+	if error != nil {
+		c.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "UserService").
+			WithField("Method", "PatchUser").
+			Warn(error.Error)
+		return responseModel, error
+	}
+
+	name := userEntity.Name
+	set := bson.D{}
+
+	if changes.Name != nil {
+		error = c.validator.ValidateUserName(*changes.Name)
+
+		if error != nil {
+			return responseModel, error
+		}
+
+		name = *changes.Name
+		set = append(set, bson.E{Key: "Name", Value: name})
+	}
+
+	var update bson.D
+	passwordChanged := false
+
+	// Sending the current password again leaves it as it is. It is refused
+	// while impersonating either way, so the admin cannot test guesses of it.
+	if changes.Password != nil {
+		error = c.rejectImpersonation(context, "PatchUser", model.Id)
+
+		if error != nil {
+			return responseModel, error
+		}
+
+		passwordUnchanged, _, _ := c.hasher.Verify(*changes.Password, userEntity.Password)
+		passwordChanged = !passwordUnchanged
+	}
+
+	if passwordChanged {
+		error = c.validator.ValidatePassword(*changes.Password, name, userEntity.Email)
+
+		if error != nil {
+			return responseModel, error
+		}
+
+		error = c.checkPasswordHistory(userEntity.PasswordHistory, *changes.Password, "PatchUser")
+
+		if error != nil {
+			return responseModel, error
+		}
+
+		passwordHash, err := c.hasher.Hash(*changes.Password)
+
+		if err != nil {
+			c.logger.
+				WithField("Service", "UserService").
+				WithField("Method", "PatchUser").
+				WithField("Operation", "Hash").
+				WithField("Error", err.Error()).
+				Error("")
+			return responseModel, &models.ErrorModel{
+				Error:      models.InternalErrorMessage,
+				StatusCode: http.StatusInternalServerError,
+			}
+		}
+
+		set = append(set,
+			bson.E{Key: "Password", Value: passwordHash},
+			bson.E{Key: "PasswordChangedAt", Value: time.Now().UTC()},
+			bson.E{Key: "MustChangePassword", Value: false})
+		update = c.pushPasswordHistory(userEntity.Password)
+	}
+
+	responseModel = models.UpdateUserResponseModel{
+		Id:      model.Id,
+		Email:   userEntity.Email,
+		Name:    name,
+		Version: userEntity.Version,
+	}
+
+	// A patch that changes nothing is not a write and keeps the version.
+	if len(set) == 0 {
+		return responseModel, nil
 	}
 
-	deleteResult, err := helpers.UserCollection.DeleteOne(context, bson.D{{"_id", model.Id}})
+	updateResult, err := helpers.UserCollection.UpdateOne(context, versionedUser(objID, model.Version),
+		append(bson.D{{"$set", set}, incrementVersion}, update...))
 
 	if err != nil {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("UserId", model.Id).
 			WithField("Service", "UserService").
-			WithField("Method", "DeleteUser").
-			WithField("Operation", "DeleteOne").
+			WithField("Method", "PatchUser").
+			WithField("Operation", "UpdateOne").
 			WithField("Error", err.Error()).
 			Error("")
-		return &models.ErrorModel{
+		return models.UpdateUserResponseModel{}, &models.ErrorModel{
 			Error:      models.InternalErrorMessage,
 			StatusCode: http.StatusInternalServerError,
 		}
 	}
 
-	if deleteResult.DeletedCount == 0 {
+	if updateResult.MatchedCount == 0 && model.Version != nil {
+		return models.UpdateUserResponseModel{}, c.versionConflict("PatchUser", model.Id)
+	}
+
+	if updateResult.MatchedCount == 0 {
 		c.logger.
-			WithField("RequestModel", model).
+			WithField("UserId", model.Id).
 			WithField("Service", "UserService").
-			WithField("Method", "DeleteUser").
-			WithField("Operation", "DeleteOne").
-			Warn("User Not Found")
-		return &models.ErrorModel{
+			WithField("Method", "PatchUser").
+			WithField("Operation", "UpdateOne").
+			Warn("User not found")
+		return models.UpdateUserResponseModel{}, &models.ErrorModel{
 			Error:      models.UserNotFoundErrorMessage,
 			StatusCode: http.StatusNotFound,
 		}
 	}
-	return nil
+
+	if passwordChanged {
+		error = c.tokenService.RevokeUserTokens(context, objID)
+
+		if error != nil {
+			return models.UpdateUserResponseModel{}, error
+		}
+	}
+
+	responseModel.Version++
+
+	return responseModel, nil
 }
 
-func (c *UserService) GetUser(context context.Context, model models.GetUserModel) (responseModel models.
-	GetUserResponseModel,
+// applyUserMergePatch applies a JSON Merge Patch (RFC 7396). name and
+// password can be replaced but not removed; id and email may be sent as they
+// are, which keeps the body of the old PATCH /users form accepted.
+func applyUserMergePatch(changes *userChanges, userEntity models.UserEntity, patch map[string]interface{}) (
 	errorModel *models.ErrorModel) {
 
-	error := c.validator.ValidateGetUserModel(model)
-
-	if error != nil {
-		return responseModel, error
+	for field, value := range patch {
+		switch field {
+		case "name", "password":
+			if !setUserField(changes, "/"+field, value) {
+				return invalidPatch()
+			}
+		case "id", "email":
+			if !userFieldEquals(*changes, userEntity, "/"+field, value) {
+				return invalidPatch()
+			}
+		default:
+			return invalidPatch()
+		}
 	}
 
-	var userEntity models.UserEntity
-
-	objID, _ := primitive.ObjectIDFromHex(model.Id)
+	return nil
+}
 
-	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}}).Decode(&userEntity)
+// applyUserJsonPatch applies JSON Patch (RFC 6902) operations in order.
+// add and replace set name or password; test compares id, email or name with
+// the patched user. Fields cannot be removed, moved or copied.
+func applyUserJsonPatch(changes *userChanges, userEntity models.UserEntity,
+	operations []models.JsonPatchOperationModel) (errorModel *models.ErrorModel) {
 
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.logger.
-				WithField("RequestModel", model).
-				WithField("Service", "UserService").
-				WithField("Method", "GetUser").
-				WithField("Operation", "FindOne").
-				Warn("UserNotFound")
-			return responseModel, &models.ErrorModel{
-				Error:      models.UserNotFoundErrorMessage,
-				StatusCode: http.StatusNotFound,
+	for _, operation := range operations {
+		switch operation.Op {
+		case "add", "replace":
+			if !setUserField(changes, operation.Path, operation.Value) {
+				return invalidPatch()
+			}
+		case "test":
+			if operation.Path != "/id" && operation.Path != "/email" && operation.Path != "/name" {
+				return invalidPatch()
 			}
+			if !userFieldEquals(*changes, userEntity, operation.Path, operation.Value) {
+				return &models.ErrorModel{
+					Error:      models.PatchTestFailedMessage,
+					StatusCode: http.StatusConflict,
+				}
+			}
+		default:
+			return invalidPatch()
 		}
+	}
 
-		c.logger.
-			WithField("RequestModel", model).
-			WithField("Service", "UserService").
-			WithField("Method", "GetUser").
-			WithField("Operation", "FindOne").
-			WithField("Error", err.Error()).
-			Error("")
-		return responseModel, &models.ErrorModel{
-			Error:      models.InternalErrorMessage,
-			StatusCode: http.StatusInternalServerError,
+	return nil
+}
+
+func setUserField(changes *userChanges, path string, value interface{}) bool {
+	text, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	switch path {
+	case "/name":
+		changes.Name = &text
+	case "/password":
+		changes.Password = &text
+	default:
+		return false
+	}
+
+	return true
+}
+
+func userFieldEquals(changes userChanges, userEntity models.UserEntity, path string, value interface{}) bool {
+	text, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	switch path {
+	case "/id":
+		return text == userEntity.Id.Hex()
+	case "/email":
+		return text == userEntity.Email
+	case "/name":
+		if changes.Name != nil {
+			return text == *changes.Name
 		}
+		return text == userEntity.Name
 	}
 
-	return models.GetUserResponseModel{
-		Id:    userEntity.Id,
-		Name:  userEntity.Name,
-		Email: userEntity.Email,
-	}, nil
+	return false
+}
 
+func invalidPatch() *models.ErrorModel {
+	return &models.ErrorModel{
+		Error:      models.InvalidPatchMessage,
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// versionedUser matches the active user with the id and, when a version is
+// given, only while the user is still at that version. Users written before
+// versions were tracked have no Version field and are at version 0.
+func versionedUser(userId primitive.ObjectID, version *int64) bson.D {
+	filter := bson.D{{"_id", userId}, activeUser}
+
+	if version == nil {
+		return filter
+	}
+
+	if *version == 0 {
+		return append(filter, bson.E{Key: "Version", Value: bson.D{{"$in", bson.A{0, nil}}}})
+	}
+
+	return append(filter, bson.E{Key: "Version", Value: *version})
 }
 
-func (c *UserService) GetAllUsers(ctx context.Context) (responseModel []models.GetUserResponseModel,
+func (c *UserService) versionConflict(method string, userId string) *models.ErrorModel {
+	c.logger.
+		WithField("UserId", userId).
+		WithField("Service", "UserService").
+		WithField("Method", method).
+		Warn("User version does not match")
+	return &models.ErrorModel{
+		Error:      models.PreconditionFailedMessage,
+		StatusCode: http.StatusPreconditionFailed,
+	}
+}
+
+// DeleteUser soft deletes the user and signs them out everywhere. The user is
+// hidden from every lookup and frees the email address, but can be restored
+// until the purge job removes it after the retention period.
+func (c *UserService) DeleteUser(context context.Context, model models.DeleteUserModel) (
 	errorModel *models.ErrorModel) {
+	error := c.validator.ValidateDeleteUserModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	userEntity, error := c.findUserEntity(context, objID, "DeleteUser")
+
+	if error != nil {
+		return error
+	}
+
+	if model.Version != nil && *model.Version != userEntity.Version {
+		return c.versionConflict("DeleteUser", model.Id)
+	}
+
+	if containsString(userEntity.Roles, models.AdminRole) {
+		error = c.CheckNotLastAdmin(context, objID, "DeleteUser")
+
+		if error != nil {
+			return error
+		}
+	}
 
-	cursor, err := helpers.UserCollection.Find(context.TODO(), bson.D{})
-	if err = cursor.All(ctx, &responseModel); err != nil {
+	updateResult, err := helpers.UserCollection.UpdateOne(context, versionedUser(objID, model.Version),
+		bson.D{{"$set", bson.D{{"DeletedAt", time.Now().UTC()}}}, incrementVersion})
+
+	if err != nil {
 		c.logger.
+			WithField("RequestModel", model).
 			WithField("Service", "UserService").
-			WithField("Method", "GetAllUsers").
-			WithField("Operation", "Find").
+			WithField("Method", "DeleteUser").
+			WithField("Operation", "UpdateOne").
 			WithField("Error", err.Error()).
 			Error("")
-		return nil, &models.ErrorModel{
+		return &models.ErrorModel{
 			Error:      models.InternalErrorMessage,
 			StatusCode: http.StatusInternalServerError,
 		}
 	}
 
-	return responseModel, nil
+	if updateResult.MatchedCount == 0 && model.Version != nil {
+		return c.versionConflict("DeleteUser", model.Id)
+	}
+
+	if updateResult.MatchedCount == 0 {
+		c.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserService").
+			WithField("Method", "DeleteUser").
+			WithField("Operation", "UpdateOne").
+			Warn("User Not Found")
+		return &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "UserService").
+		WithField("Method", "DeleteUser").
+		Info("User deleted")
+
+	return c.tokenService.RevokeUserTokens(context, objID)
+}
+
+// RestoreUser undoes a soft delete. It fails when another user took the email
+// address in the meantime.
+func (c *UserService) RestoreUser(context context.Context, model models.RestoreUserModel) (
+	responseModel models.GetUserResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateRestoreUserModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+	deletedUser := bson.D{{"_id", objID}, {"DeletedAt", bson.D{{"$exists", true}}}}
+
+	notFound := &models.ErrorModel{
+		Error:      models.UserNotFoundErrorMessage,
+		StatusCode: http.StatusNotFound,
+	}
+
+	var userEntity models.UserEntity
+
+	err := helpers.UserCollection.FindOne(context, deletedUser).Decode(&userEntity)
+
+	if err == mongo.ErrNoDocuments {
+		return responseModel, notFound
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("RestoreUser", "FindOne", err)
+	}
+
+	count, err := helpers.UserCollection.CountDocuments(context, bson.D{{"Email", userEntity.Email}, activeUser})
+
+	if err != nil {
+		return responseModel, c.internalError("RestoreUser", "CountDocuments", err)
+	}
+
+	if count > 0 {
+		c.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "UserService").
+			WithField("Method", "RestoreUser").
+			Warn("User already exist for the email")
+		return responseModel, &models.ErrorModel{
+			Error:      models.EmailExistMessage,
+			StatusCode: http.StatusForbidden,
+		}
+	}
+
+	err = helpers.UserCollection.FindOneAndUpdate(context, deletedUser,
+		bson.D{{"$unset", bson.D{{"DeletedAt", ""}}}, incrementVersion},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&responseModel)
+
+	if err == mongo.ErrNoDocuments {
+		return responseModel, notFound
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("RestoreUser", "FindOneAndUpdate", err)
+	}
+
+	responseModel.CreatedAt = responseModel.Id.Timestamp().UTC()
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "UserService").
+		WithField("Method", "RestoreUser").
+		Info("User restored")
+
+	return responseModel, nil
+}
+
+// ListDeletedUsers returns one page of the soft deleted users, most recently
+// deleted first.
+func (c *UserService) ListDeletedUsers(context context.Context, model models.ListDeletedUsersModel) (
+	responseModel models.UserListResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateListDeletedUsersModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	page := model.Page
+	if page == 0 {
+		page = 1
+	}
+
+	limit := model.Limit
+	if limit == 0 {
+		limit = c.defaultPageLimit
+	}
+	if limit > c.maxPageLimit {
+		limit = c.maxPageLimit
+	}
+
+	filter := bson.D{{"DeletedAt", bson.D{{"$exists", true}}}}
+
+	total, err := helpers.UserCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		return responseModel, c.internalError("ListDeletedUsers", "CountDocuments", err)
+	}
+
+	items, errorModel := c.findUsers(context, filter, options.Find().
+		SetSort(bson.D{{"DeletedAt", -1}, {"_id", -1}}).
+		SetSkip(int64(page-1)*int64(limit)).
+		SetLimit(int64(limit)), "ListDeletedUsers")
+
+	if errorModel != nil {
+		return responseModel, errorModel
+	}
+
+	return models.UserListResponseModel{
+		Items: items,
+		Total: &total,
+		Page:  page,
+		Limit: limit,
+	}, nil
+}
+
+// PurgeDeletedUsers removes the users deleted longer than retention ago for
+// good, along with their tokens, sessions and API keys. The users go last so
+// that a failed run is picked up again by the next one.
+func (c *UserService) PurgeDeletedUsers(context context.Context, retention time.Duration) (int64, error) {
+	deletedBefore := bson.D{{"DeletedAt", bson.D{{"$lte", time.Now().UTC().Add(-retention)}}}}
+
+	cursor, err := helpers.UserCollection.Find(context, deletedBefore,
+		options.Find().SetProjection(bson.D{{"_id", 1}}))
+
+	if err != nil {
+		return 0, err
+	}
+
+	var users []struct {
+		Id primitive.ObjectID `bson:"_id"`
+	}
+
+	if err = cursor.All(context, &users); err != nil || len(users) == 0 {
+		return 0, err
+	}
+
+	ids := make(bson.A, len(users))
+	for i, user := range users {
+		ids[i] = user.Id
+	}
+
+	for _, collection := range []*mongo.Collection{
+		helpers.RefreshTokenCollection,
+		helpers.SessionCollection,
+		helpers.ApiKeyCollection,
+		helpers.PasswordResetTokenCollection,
+		helpers.MagicLinkTokenCollection,
+		helpers.AuthorizationCodeCollection,
+	} {
+		if _, err = collection.DeleteMany(context, bson.D{{"UserId", bson.D{{"$in", ids}}}}); err != nil {
+			return 0, err
+		}
+	}
+
+	deleteResult, err := helpers.UserCollection.DeleteMany(context,
+		append(bson.D{{"_id", bson.D{{"$in", ids}}}}, deletedBefore...))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return deleteResult.DeletedCount, nil
+}
+
+// RunPurge purges deleted users right away and then at every purge interval
+// until the context is done.
+func (c *UserService) RunPurge(context context.Context, config configuration.DeletionConfigurations) {
+	retention := config.RetentionPeriod
+	if retention <= 0 {
+		retention = defaultDeletionRetentionPeriod
+	}
+
+	interval := config.PurgeInterval
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := c.PurgeDeletedUsers(context, retention)
+
+		if err != nil {
+			c.logger.
+				WithField("Service", "UserService").
+				WithField("Method", "RunPurge").
+				WithField("Operation", "PurgeDeletedUsers").
+				WithField("Error", err.Error()).
+				Error("")
+		} else if purged > 0 {
+			c.logger.
+				WithField("Purged", purged).
+				WithField("Service", "UserService").
+				WithField("Method", "RunPurge").
+				Info("Deleted users purged")
+		}
+
+		select {
+		case <-context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *UserService) GetUser(context context.Context, model models.GetUserModel) (responseModel models.
+	GetUserResponseModel,
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateGetUserModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	var userEntity models.UserEntity
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", objID}, activeUser}).Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("RequestModel", model).
+				WithField("Service", "UserService").
+				WithField("Method", "GetUser").
+				WithField("Operation", "FindOne").
+				Warn("UserNotFound")
+			return responseModel, &models.ErrorModel{
+				Error:      models.UserNotFoundErrorMessage,
+				StatusCode: http.StatusNotFound,
+			}
+		}
+
+		c.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserService").
+			WithField("Method", "GetUser").
+			WithField("Operation", "FindOne").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return models.GetUserResponseModel{
+		Id:                userEntity.Id,
+		Name:              userEntity.Name,
+		Email:             userEntity.Email,
+		Roles:             userEntity.Roles,
+		EmailVerified:     userEntity.EmailVerified,
+		TwoFactorEnabled:  userEntity.TwoFactorEnabled,
+		Disabled:          userEntity.Disabled,
+		CreatedAt:         userEntity.Id.Timestamp().UTC(),
+		PasswordExpiresAt: c.PasswordExpiresAt(userEntity),
+		Version:           userEntity.Version,
+	}, nil
+
+}
+
+// GetAllUsers returns one page of the users matching the filters. Users are
+// ordered by creation unless another sort field is given; a leading "-"
+// sorts in descending order. The limit is capped at the configured maximum.
+//
+// Pages are 1-based offsets by default. In cursor mode, chosen with
+// pagination=cursor or by passing a cursor, pages are read by keyset from the
+// last sort value and _id of the previous page instead, which stays fast and
+// stable on large collections while users are added.
+func (c *UserService) GetAllUsers(context context.Context, model models.ListUsersModel) (
+	responseModel models.UserListResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateListUsersModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	limit := model.Limit
+	if limit == 0 {
+		limit = c.defaultPageLimit
+	}
+	if limit > c.maxPageLimit {
+		limit = c.maxPageLimit
+	}
+
+	if model.Cursor != "" {
+		var position userListCursor
+
+		err := c.cursorSigner.Decode(model.Cursor, &position)
+
+		if err != nil {
+			c.logger.
+				WithField("Service", "UserService").
+				WithField("Method", "GetAllUsers").
+				WithField("Operation", "Decode").
+				Warn("Cursor is not valid")
+			return responseModel, &models.ErrorModel{
+				Error:      models.InvalidCursorMessage,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+
+		// The cursor keeps the sort and filters of the first page.
+		model.Sort, model.Name, model.EmailDomain, model.Status =
+			position.Sort, position.Name, position.EmailDomain, position.Status
+
+		return c.getUsersByCursor(context, model, &position, limit)
+	}
+
+	if model.Pagination == models.CursorPagination {
+		return c.getUsersByCursor(context, model, nil, limit)
+	}
+
+	page := model.Page
+	if page == 0 {
+		page = 1
+	}
+
+	filter := userListFilter(model)
+	sortField, direction := userListSort(model.Sort)
+
+	total, err := helpers.UserCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "GetAllUsers").
+			WithField("Operation", "CountDocuments").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	findOptions := options.Find().
+		SetSort(userListSortDocument(sortField, direction)).
+		SetSkip(int64(page-1) * int64(limit)).
+		SetLimit(int64(limit))
+
+	items, errorModel := c.findUsers(context, filter, findOptions, "GetAllUsers")
+
+	if errorModel != nil {
+		return responseModel, errorModel
+	}
+
+	return models.UserListResponseModel{
+		Items: items,
+		Total: &total,
+		Page:  page,
+		Limit: limit,
+	}, nil
+}
+
+// userListCursor is the position signed into the cursors of GetAllUsers: the
+// sort value and _id of the item next to the page, and whether the page
+// comes before it.
+type userListCursor struct {
+	Sort        string             `json:"s,omitempty"`
+	Name        string             `json:"n,omitempty"`
+	EmailDomain string             `json:"d,omitempty"`
+	Status      string             `json:"st,omitempty"`
+	Value       string             `json:"v,omitempty"`
+	Id          primitive.ObjectID `json:"id"`
+	Backward    bool               `json:"b,omitempty"`
+}
+
+// getUsersByCursor reads the page after the position, or before it for
+// backward cursors, and the first page without one. One extra user is read
+// to tell whether there is another page.
+func (c *UserService) getUsersByCursor(context context.Context, model models.ListUsersModel,
+	position *userListCursor, limit int) (responseModel models.UserListResponseModel, errorModel *models.ErrorModel) {
+
+	filter := userListFilter(model)
+	sortField, direction := userListSort(model.Sort)
+	field := userSortFields[sortField]
+
+	backward := position != nil && position.Backward
+	if backward {
+		direction = -direction
+	}
+
+	if position != nil {
+		comparison := "$gt"
+		if direction < 0 {
+			comparison = "$lt"
+		}
+
+		if field == "_id" {
+			filter = append(filter, bson.E{Key: "_id", Value: bson.D{{comparison, position.Id}}})
+		} else {
+			filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+				bson.D{{field, bson.D{{comparison, position.Value}}}},
+				bson.D{{field, position.Value}, {"_id", bson.D{{comparison, position.Id}}}},
+			}})
+		}
+	}
+
+	findOptions := options.Find().
+		SetSort(userListSortDocument(sortField, direction)).
+		SetLimit(int64(limit) + 1)
+
+	items, errorModel := c.findUsers(context, filter, findOptions, "GetAllUsers")
+
+	if errorModel != nil {
+		return responseModel, errorModel
+	}
+
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+
+	hasNext, hasPrevious := more, position != nil
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+		hasNext, hasPrevious = true, more
+	}
+
+	responseModel = models.UserListResponseModel{
+		Items: items,
+		Limit: limit,
+	}
+
+	if len(items) == 0 {
+		return responseModel, nil
+	}
+
+	cursor := func(item models.GetUserResponseModel, before bool) (string, error) {
+		itemPosition := userListCursor{Sort: model.Sort, Name: model.Name, EmailDomain: model.EmailDomain,
+			Status: model.Status, Id: item.Id, Backward: before}
+		switch sortField {
+		case models.NameSortField:
+			itemPosition.Value = item.Name
+		case models.EmailSortField:
+			itemPosition.Value = item.Email
+		}
+		return c.cursorSigner.Encode(itemPosition)
+	}
+
+	var err error
+
+	if hasNext {
+		responseModel.NextCursor, err = cursor(items[len(items)-1], false)
+	}
+
+	if err == nil && hasPrevious {
+		responseModel.PrevCursor, err = cursor(items[0], true)
+	}
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "GetAllUsers").
+			WithField("Operation", "Encode").
+			WithField("Error", err.Error()).
+			Error("")
+		return models.UserListResponseModel{}, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return responseModel, nil
+}
+
+func (c *UserService) findUsers(context context.Context, filter bson.D, findOptions *options.FindOptions,
+	method string) (items []models.GetUserResponseModel, errorModel *models.ErrorModel) {
+
+	items = []models.GetUserResponseModel{}
+
+	cursor, err := helpers.UserCollection.Find(context, filter, findOptions)
+
+	if err == nil {
+		err = cursor.All(context, &items)
+	}
+
+	if err != nil {
+		return nil, c.internalError(method, "Find", err)
+	}
+
+	for i := range items {
+		items[i].CreatedAt = items[i].Id.Timestamp().UTC()
+	}
+
+	return items, nil
+}
+
+func userListFilter(model models.ListUsersModel) bson.D {
+	filter := bson.D{activeUser}
+
+	if model.Name != "" {
+		filter = append(filter, bson.E{Key: "Name", Value: primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(model.Name), Options: "i"}})
+	}
+
+	if model.EmailDomain != "" {
+		filter = append(filter, bson.E{Key: "Email", Value: primitive.Regex{
+			Pattern: "@" + regexp.QuoteMeta(model.EmailDomain) + "$", Options: "i"}})
+	}
+
+	switch model.Status {
+	case models.ActiveUserStatus:
+		filter = append(filter, bson.E{Key: "Disabled", Value: bson.D{{"$ne", true}}})
+	case models.DisabledUserStatus:
+		filter = append(filter, bson.E{Key: "Disabled", Value: true})
+	case models.UnverifiedUserStatus:
+		filter = append(filter, bson.E{Key: "EmailVerified", Value: bson.D{{"$ne", true}}})
+	}
+
+	return filter
+}
+
+func userListSort(sort string) (sortField string, direction int) {
+	sortField, direction = sort, 1
+	if strings.HasPrefix(sortField, "-") {
+		sortField, direction = sortField[1:], -1
+	}
+	if sortField == "" {
+		sortField = models.CreatedAtSortField
+	}
+	return sortField, direction
+}
+
+// userListSortDocument breaks ties on _id so that pages do not overlap.
+func userListSortDocument(sortField string, direction int) bson.D {
+	sort := bson.D{{userSortFields[sortField], direction}}
+	if sortField != models.CreatedAtSortField {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	return sort
+}
+
+// SearchUsers finds users whose name or email matches the query, most relevant
+// first. Whole words are looked up in the text index; when nothing matches
+// there, or the index is missing, users whose name or email starts with the
+// query are returned instead, ordered by name.
+func (c *UserService) SearchUsers(context context.Context, model models.SearchUsersModel) (
+	responseModel models.UserSearchResponseModel, errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateSearchUsersModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	page := model.Page
+	if page == 0 {
+		page = 1
+	}
+
+	limit := model.Limit
+	if limit == 0 {
+		limit = c.defaultPageLimit
+	}
+	if limit > c.maxPageLimit {
+		limit = c.maxPageLimit
+	}
+
+	query := strings.TrimSpace(model.Query)
+
+	filter := bson.D{{"$text", bson.D{{"$search", query}}}, activeUser}
+	findOptions := options.Find().
+		SetProjection(bson.D{{"Score", bson.D{{"$meta", "textScore"}}}}).
+		SetSort(bson.D{{"Score", bson.D{{"$meta", "textScore"}}}, {"_id", 1}})
+
+	total, err := helpers.UserCollection.CountDocuments(context, filter)
+
+	if err != nil {
+		var commandError mongo.CommandError
+		if !errors.As(err, &commandError) || commandError.Code != indexNotFoundCode {
+			return responseModel, c.internalError("SearchUsers", "CountDocuments", err)
+		}
+
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "SearchUsers").
+			WithField("Operation", "CountDocuments").
+			Warn("Text index does not exist, falling back to prefix matching")
+	}
+
+	if err != nil || total == 0 {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query), Options: "i"}
+		filter = bson.D{{"$or", bson.A{bson.D{{"Name", prefix}}, bson.D{{"Email", prefix}}}}, activeUser}
+		findOptions = options.Find().SetSort(bson.D{{"Name", 1}, {"_id", 1}})
+
+		total, err = helpers.UserCollection.CountDocuments(context, filter)
+
+		if err != nil {
+			return responseModel, c.internalError("SearchUsers", "CountDocuments", err)
+		}
+	}
+
+	findOptions.
+		SetSkip(int64(page-1) * int64(limit)).
+		SetLimit(int64(limit))
+
+	items := []models.UserSearchResultModel{}
+
+	cursor, err := helpers.UserCollection.Find(context, filter, findOptions)
+
+	if err == nil {
+		err = cursor.All(context, &items)
+	}
+
+	if err != nil {
+		return responseModel, c.internalError("SearchUsers", "Find", err)
+	}
+
+	terms := helpers.SearchTerms(query)
+
+	for i := range items {
+		items[i].CreatedAt = items[i].Id.Timestamp().UTC()
+		items[i].Highlights = map[string]string{}
+
+		if name, ok := helpers.Highlight(items[i].Name, terms); ok {
+			items[i].Highlights["name"] = name
+		}
+		if email, ok := helpers.Highlight(items[i].Email, terms); ok {
+			items[i].Highlights["email"] = email
+		}
+	}
+
+	return models.UserSearchResponseModel{
+		Items: items,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}, nil
+}
+
+func (c *UserService) internalError(method string, operation string, err error) *models.ErrorModel {
+	c.logger.
+		WithField("Service", "UserService").
+		WithField("Method", method).
+		WithField("Operation", operation).
+		WithField("Error", err.Error()).
+		Error("")
+	return &models.ErrorModel{
+		Error:      models.InternalErrorMessage,
+		StatusCode: http.StatusInternalServerError,
+	}
+}
+
+func (c *UserService) VerifyCredentials(context context.Context, email string, password string) (
+	userEntity models.UserEntity,
+	errorModel *models.ErrorModel) {
+
+	invalidCredentials := &models.ErrorModel{
+		Error:      models.InvalidCredentialsErrorMessage,
+		StatusCode: http.StatusUnauthorized,
+	}
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"Email", email}, activeUser}).Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// Hash anyway so unknown emails take as long as wrong passwords.
+			_, _ = c.hasher.Hash(password)
+			c.logger.
+				WithField("Service", "UserService").
+				WithField("Method", "VerifyCredentials").
+				WithField("Operation", "FindOne").
+				Warn("UserNotFound")
+			return userEntity, invalidCredentials
+		}
+
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "VerifyCredentials").
+			WithField("Operation", "FindOne").
+			WithField("Error", err.Error()).
+			Error("")
+		return userEntity, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	match, needsRehash, err := c.hasher.Verify(password, userEntity.Password)
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "VerifyCredentials").
+			WithField("Operation", "Verify").
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return userEntity, invalidCredentials
+	}
+
+	// Provisioned users may have no password at all.
+	if !match || userEntity.Password == "" {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "VerifyCredentials").
+			WithField("Operation", "Verify").
+			WithField("UserId", userEntity.Id.Hex()).
+			Warn("Password mismatch")
+		return userEntity, invalidCredentials
+	}
+
+	if userEntity.Disabled {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "VerifyCredentials").
+			WithField("UserId", userEntity.Id.Hex()).
+			Warn("Account disabled")
+		return userEntity, &models.ErrorModel{
+			Error:      models.AccountDisabledMessage,
+			StatusCode: http.StatusForbidden,
+		}
+	}
+
+	if needsRehash {
+		c.rehashPassword(context, &userEntity, password)
+	}
+
+	return userEntity, nil
+}
+
+// rehashPassword upgrades a stored password to the configured algorithm and
+// parameters. Failures are only logged since the credentials were valid.
+func (c *UserService) rehashPassword(context context.Context, userEntity *models.UserEntity, password string) {
+	passwordHash, err := c.hasher.Hash(password)
+
+	if err == nil {
+		_, err = helpers.UserCollection.UpdateOne(context,
+			bson.D{{"_id", userEntity.Id}, {"Password", userEntity.Password}},
+			bson.D{{"$set", bson.D{{"Password", passwordHash}}}, incrementVersion})
+	}
+
+	if err != nil {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "VerifyCredentials").
+			WithField("Operation", "Rehash").
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Error", err.Error()).
+			Error("")
+		return
+	}
+
+	userEntity.Password = passwordHash
+
+	c.logger.
+		WithField("Service", "UserService").
+		WithField("Method", "VerifyCredentials").
+		WithField("UserId", userEntity.Id.Hex()).
+		Info("Password rehashed")
+}
+
+// SetPassword replaces the password of the user and signs them out of every
+// session, for flows where the caller already proved ownership of the account.
+func (c *UserService) SetPassword(context context.Context, userId primitive.ObjectID, password string) (
+	errorModel *models.ErrorModel) {
+
+	userEntity, error := c.findUserEntity(context, userId, "SetPassword")
+
+	if error != nil {
+		return error
+	}
+
+	return c.replacePassword(context, userEntity, password, "SetPassword")
+}
+
+// ChangePassword lets users replace their own password by proving they know
+// the current one. It is the only operation allowed for a password change
+// token issued at login when the password has expired.
+func (c *UserService) ChangePassword(context context.Context, model models.ChangePasswordModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateChangePasswordModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	userEntity, error := c.findUserEntity(context, objID, "ChangePassword")
+
+	if error != nil {
+		return error
+	}
+
+	match, _, _ := c.hasher.Verify(model.CurrentPassword, userEntity.Password)
+
+	if !match {
+		c.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "UserService").
+			WithField("Method", "ChangePassword").
+			WithField("Operation", "Verify").
+			Warn("Current password mismatch")
+		return &models.ErrorModel{
+			Error:      models.InvalidCurrentPasswordMessage,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	return c.replacePassword(context, userEntity, model.NewPassword, "ChangePassword")
+}
+
+// ExpirePassword makes the user choose a new password at the next login and
+// ends the user's current sessions.
+func (c *UserService) ExpirePassword(context context.Context, model models.ExpirePasswordModel) (
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateExpirePasswordModel(model)
+
+	if error != nil {
+		return error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	updateResult, err := helpers.UserCollection.UpdateOne(context, bson.D{{"_id", objID}, activeUser},
+		bson.D{{"$set", bson.D{{"MustChangePassword", true}}}, incrementVersion})
+
+	if err != nil {
+		c.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "UserService").
+			WithField("Method", "ExpirePassword").
+			WithField("Operation", "UpdateOne").
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	if updateResult.MatchedCount == 0 {
+		return &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	c.logger.
+		WithField("UserId", model.Id).
+		WithField("Service", "UserService").
+		WithField("Method", "ExpirePassword").
+		Info("Password expired")
+
+	return c.tokenService.RevokeUserTokens(context, objID)
+}
+
+// PasswordExpiresAt returns when the user's password stops being accepted
+// for a normal login, or nil when it does not expire. Passwords set before
+// their change time was tracked only expire when flagged by an admin.
+func (c *UserService) PasswordExpiresAt(userEntity models.UserEntity) *time.Time {
+	return passwordExpiresAt(userEntity, c.passwordMaxAge)
+}
+
+func (c *UserService) PasswordExpired(userEntity models.UserEntity) bool {
+	return passwordExpired(userEntity, c.passwordMaxAge)
+}
+
+func passwordExpiresAt(userEntity models.UserEntity, maxAge time.Duration) *time.Time {
+	if userEntity.MustChangePassword {
+		expiresAt := time.Now().UTC()
+		if userEntity.PasswordChangedAt != nil && userEntity.PasswordChangedAt.Before(expiresAt) {
+			expiresAt = *userEntity.PasswordChangedAt
+		}
+		return &expiresAt
+	}
+
+	if maxAge <= 0 || userEntity.PasswordChangedAt == nil {
+		return nil
+	}
+
+	expiresAt := userEntity.PasswordChangedAt.Add(maxAge)
+
+	return &expiresAt
+}
+
+func passwordExpired(userEntity models.UserEntity, maxAge time.Duration) bool {
+	expiresAt := passwordExpiresAt(userEntity, maxAge)
+
+	return expiresAt != nil && !time.Now().Before(*expiresAt)
+}
+
+func (c *UserService) findUserEntity(context context.Context, userId primitive.ObjectID, method string) (
+	userEntity models.UserEntity, errorModel *models.ErrorModel) {
+
+	err := helpers.UserCollection.FindOne(context, bson.D{{"_id", userId}, activeUser}).Decode(&userEntity)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("UserId", userId.Hex()).
+				WithField("Service", "UserService").
+				WithField("Method", method).
+				WithField("Operation", "FindOne").
+				Warn("User not found")
+			return userEntity, &models.ErrorModel{
+				Error:      models.UserNotFoundErrorMessage,
+				StatusCode: http.StatusNotFound,
+			}
+		}
+
+		c.logger.
+			WithField("UserId", userId.Hex()).
+			WithField("Service", "UserService").
+			WithField("Method", method).
+			WithField("Operation", "FindOne").
+			WithField("Error", err.Error()).
+			Error("")
+		return userEntity, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return userEntity, nil
+}
+
+// rejectImpersonation refuses password changes made through an impersonation
+// token. Besides blocking the routes in the middleware, every path that
+// stores a password checks it, so a new route cannot let an admin take over
+// the impersonated account.
+func (c *UserService) rejectImpersonation(context context.Context, method string, userId string) (
+	errorModel *models.ErrorModel) {
+
+	if !helpers.Impersonating(context) {
+		return nil
+	}
+
+	c.logger.
+		WithField("UserId", userId).
+		WithField("Service", "UserService").
+		WithField("Method", method).
+		Warn("Password change refused while impersonating")
+	return &models.ErrorModel{
+		Error:      models.ImpersonationForbiddenMessage,
+		StatusCode: http.StatusForbidden,
+	}
+}
+
+// replacePassword applies the password policy and history, stores the new
+// hash, clears any forced change and revokes the user's refresh tokens.
+func (c *UserService) replacePassword(context context.Context, userEntity models.UserEntity, password string,
+	method string) (errorModel *models.ErrorModel) {
+
+	set, update, error := c.preparePassword(context, userEntity, password, method)
+
+	if error != nil {
+		return error
+	}
+
+	updateResult, err := helpers.UserCollection.UpdateByID(context, userEntity.Id,
+		append(bson.D{{"$set", set}, incrementVersion}, update...))
+
+	if err != nil {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "UserService").
+			WithField("Method", method).
+			WithField("Operation", "UpdateByID").
+			WithField("Error", err.Error()).
+			Error("")
+		return &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	if updateResult.MatchedCount == 0 {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "UserService").
+			WithField("Method", method).
+			WithField("Operation", "UpdateByID").
+			Warn("User not found")
+		return &models.ErrorModel{
+			Error:      models.UserNotFoundErrorMessage,
+			StatusCode: http.StatusNotFound,
+		}
+	}
+
+	c.logger.
+		WithField("UserId", userEntity.Id.Hex()).
+		WithField("Service", "UserService").
+		WithField("Method", method).
+		Info("Password changed")
+
+	return c.tokenService.RevokeUserTokens(context, userEntity.Id)
+}
+
+// PreparePassword checks password like a password change would and returns
+// the fields to $set and the rest of the update that store it, so that it can
+// be saved together with other changes of the user.
+func (c *UserService) PreparePassword(context context.Context, userEntity models.UserEntity, password string) (
+	set bson.D, update bson.D, errorModel *models.ErrorModel) {
+	return c.preparePassword(context, userEntity, password, "PreparePassword")
+}
+
+// preparePassword applies the password policy and history and hashes the new
+// password. Callers store it and revoke the user's refresh tokens.
+func (c *UserService) preparePassword(context context.Context, userEntity models.UserEntity, password string,
+	method string) (set bson.D, update bson.D, errorModel *models.ErrorModel) {
+
+	error := c.rejectImpersonation(context, method, userEntity.Id.Hex())
+
+	if error != nil {
+		return nil, nil, error
+	}
+
+	error = c.validator.ValidatePassword(password, userEntity.Name, userEntity.Email)
+
+	if error != nil {
+		return nil, nil, error
+	}
+
+	error = c.checkPasswordHistory(append([]string{userEntity.Password}, userEntity.PasswordHistory...), password,
+		method)
+
+	if error != nil {
+		return nil, nil, error
+	}
+
+	passwordHash, err := c.hasher.Hash(password)
+
+	if err != nil {
+		c.logger.
+			WithField("UserId", userEntity.Id.Hex()).
+			WithField("Service", "UserService").
+			WithField("Method", method).
+			WithField("Operation", "Hash").
+			WithField("Error", err.Error()).
+			Error("")
+		return nil, nil, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	return bson.D{
+		{"Password", passwordHash},
+		{"PasswordChangedAt", time.Now().UTC()},
+		{"MustChangePassword", false}}, c.pushPasswordHistory(userEntity.Password), nil
+}
+
+// checkPasswordHistory rejects a password matching any of the given hashes.
+func (c *UserService) checkPasswordHistory(passwordHashes []string, password string, method string) (
+	errorModel *models.ErrorModel) {
+
+	if c.passwordHistorySize <= 0 {
+		return nil
+	}
+
+	for _, passwordHash := range passwordHashes {
+		if match, _, _ := c.hasher.Verify(password, passwordHash); match {
+			c.logger.
+				WithField("Service", "UserService").
+				WithField("Method", method).
+				Warn("Password reused")
+			return &models.ErrorModel{
+				Error:      models.PasswordReusedErrorMessage,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+	}
+
+	return nil
+}
+
+// pushPasswordHistory returns the update that records the replaced hash,
+// keeping only the last passwordHistorySize entries. Legacy plaintext
+// passwords are not carried into the history.
+func (c *UserService) pushPasswordHistory(previousHash string) bson.D {
+	if c.passwordHistorySize <= 0 || !strings.HasPrefix(previousHash, "$") {
+		return nil
+	}
+
+	return bson.D{{"$push", bson.D{{"PasswordHistory", bson.D{
+		{"$each", bson.A{previousHash}},
+		{"$slice", -c.passwordHistorySize}}}}}}
+}
+
+func (c *UserService) GrantRole(context context.Context, model models.UserRoleModel) (responseModel models.
+	GetUserResponseModel,
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateUserRoleModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	return c.updateRoles(context, "GrantRole", objID, bson.D{{"$addToSet", bson.D{{"Roles", model.Role}}}})
+}
+
+func (c *UserService) RevokeRole(context context.Context, model models.UserRoleModel) (responseModel models.
+	GetUserResponseModel,
+	errorModel *models.ErrorModel) {
+
+	error := c.validator.ValidateUserRoleModel(model)
+
+	if error != nil {
+		return responseModel, error
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(model.Id)
+
+	if model.Role == models.AdminRole {
+		error = c.CheckNotLastAdmin(context, objID, "RevokeRole")
+
+		if error != nil {
+			return responseModel, error
+		}
+	}
+
+	return c.updateRoles(context, "RevokeRole", objID, bson.D{{"$pull", bson.D{{"Roles", model.Role}}}})
+}
+
+// CheckNotLastAdmin refuses to take the admin role, by demotion, deletion or
+// deactivation, from the only active admin. Otherwise nobody could manage the
+// users, as BootstrapAdmin only runs while no admin exists at all.
+func (c *UserService) CheckNotLastAdmin(context context.Context, userId primitive.ObjectID, method string) (
+	errorModel *models.ErrorModel) {
+
+	otherAdmins, err := helpers.UserCollection.CountDocuments(context,
+		bson.D{{"Roles", models.AdminRole}, {"_id", bson.D{{"$ne", userId}}}, {"Disabled", bson.D{{"$ne", true}}},
+			activeUser})
+
+	if err != nil {
+		return c.internalError(method, "CountDocuments", err)
+	}
+
+	if otherAdmins == 0 {
+		c.logger.
+			WithField("UserId", userId.Hex()).
+			WithField("Service", "UserService").
+			WithField("Method", method).
+			Warn("Last admin cannot lose the admin role")
+		return &models.ErrorModel{
+			Error:      models.LastAdminErrorMessage,
+			StatusCode: http.StatusConflict,
+		}
+	}
+
+	return nil
+}
+
+func (c *UserService) updateRoles(context context.Context, method string, objID primitive.ObjectID,
+	update bson.D) (responseModel models.GetUserResponseModel, errorModel *models.ErrorModel) {
+
+	err := helpers.UserCollection.FindOneAndUpdate(context, bson.D{{"_id", objID}, activeUser},
+		append(update, incrementVersion), options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&responseModel)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.logger.
+				WithField("UserId", objID.Hex()).
+				WithField("Service", "UserService").
+				WithField("Method", method).
+				WithField("Operation", "FindOneAndUpdate").
+				Warn("UserNotFound")
+			return responseModel, &models.ErrorModel{
+				Error:      models.UserNotFoundErrorMessage,
+				StatusCode: http.StatusNotFound,
+			}
+		}
+
+		c.logger.
+			WithField("UserId", objID.Hex()).
+			WithField("Service", "UserService").
+			WithField("Method", method).
+			WithField("Operation", "FindOneAndUpdate").
+			WithField("Error", err.Error()).
+			Error("")
+		return responseModel, &models.ErrorModel{
+			Error:      models.InternalErrorMessage,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+
+	responseModel.CreatedAt = responseModel.Id.Timestamp().UTC()
+
+	c.logger.
+		WithField("UserId", objID.Hex()).
+		WithField("Roles", responseModel.Roles).
+		WithField("Service", "UserService").
+		WithField("Method", method).
+		Info("User roles updated")
+
+	return responseModel, nil
+}
+
+// EnsureSearchIndex creates the text index SearchUsers looks names and emails
+// up in. Names are not stemmed, so the index does not use a language.
+func (c *UserService) EnsureSearchIndex(context context.Context) error {
+	_, err := helpers.UserCollection.Indexes().CreateOne(context, mongo.IndexModel{
+		Keys: bson.D{{"Name", "text"}, {"Email", "text"}},
+		Options: options.Index().
+			SetName(userSearchIndexName).
+			SetWeights(bson.D{{"Name", 2}, {"Email", 1}}).
+			SetDefaultLanguage("none"),
+	})
+
+	return err
+}
+
+// BootstrapAdmin makes sure at least one admin exists. When there is none,
+// an account is created for the configured email. Existing accounts are never
+// promoted: registration is public, so whoever signed up with the configured
+// email first would become admin. Startup fails when the email is taken.
+func (c *UserService) BootstrapAdmin(context context.Context, config configuration.BootstrapConfigurations) error {
+	// Soft deleted admins count, so deleting them does not bootstrap a new
+	// admin at the next start.
+	adminCount, err := helpers.UserCollection.CountDocuments(context, bson.D{{"Roles", models.AdminRole}})
+
+	if err != nil {
+		return err
+	}
+
+	if adminCount > 0 {
+		return nil
+	}
+
+	if config.AdminEmail == "" || config.AdminPassword == "" {
+		c.logger.
+			WithField("Service", "UserService").
+			WithField("Method", "BootstrapAdmin").
+			Warn("No admin exists and no bootstrap admin is configured")
+		return nil
+	}
+
+	emailCount, err := helpers.UserCollection.CountDocuments(context, bson.D{{"Email", config.AdminEmail}, activeUser})
+
+	if err != nil {
+		return err
+	}
+
+	if emailCount > 0 {
+		return fmt.Errorf("bootstrap admin email %s already belongs to a user", config.AdminEmail)
+	}
+
+	passwordHash, err := c.hasher.Hash(config.AdminPassword)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	_, err = helpers.UserCollection.InsertOne(context, models.UserEntity{
+		Id:              primitive.NewObjectID(),
+		Name:            config.AdminName,
+		Password:        passwordHash,
+		Email:           config.AdminEmail,
+		Roles:           []string{models.UserRole, models.AdminRole},
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		// The configured password is shared with whoever deploys the
+		// service, so the admin has to replace it at first login.
+		PasswordChangedAt:  &now,
+		MustChangePassword: true,
+		Version:            1,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	c.logger.
+		WithField("Email", config.AdminEmail).
+		WithField("Service", "UserService").
+		WithField("Method", "BootstrapAdmin").
+		Info("Bootstrap admin created")
+
+	return nil
+}
+
+// MigrateLegacyPasswords hashes the passwords still stored in plaintext, so
+// that no plaintext is left in the database and Verify never has to accept
+// one. It runs at startup and finds nothing to do once all rows are hashed.
+func (c *UserService) MigrateLegacyPasswords(context context.Context) error {
+	cursor, err := helpers.UserCollection.Find(context,
+		bson.D{{"Password", bson.D{
+			{"$type", "string"},
+			{"$ne", ""},
+			{"$not", primitive.Regex{Pattern: `^\$(argon2id|2[aby])\$`}}}}},
+		options.Find().SetProjection(bson.D{{"_id", 1}, {"Password", 1}}))
+
+	if err != nil {
+		return err
+	}
+
+	var users []struct {
+		Id       primitive.ObjectID `bson:"_id"`
+		Password string             `bson:"Password"`
+	}
+
+	if err = cursor.All(context, &users); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if helpers.IsPasswordHash(user.Password) {
+			continue
+		}
+
+		passwordHash, err := c.hasher.Hash(user.Password)
+
+		if err != nil {
+			return err
+		}
+
+		// Matching the old value leaves a password changed meanwhile alone.
+		_, err = helpers.UserCollection.UpdateOne(context,
+			bson.D{{"_id", user.Id}, {"Password", user.Password}},
+			bson.D{{"$set", bson.D{{"Password", passwordHash}}}, incrementVersion})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(users) > 0 {
+		c.logger.
+			WithField("Count", len(users)).
+			WithField("Service", "UserService").
+			WithField("Method", "MigrateLegacyPasswords").
+			Info("Legacy plaintext passwords hashed")
+	}
+
+	return nil
 }
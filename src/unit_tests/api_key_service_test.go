@@ -0,0 +1,198 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+func newTestApiKeyService(mt *mtest.T) *services.ApiKeyService {
+	logger := log.New()
+	helpers.UserCollection = mt.Coll
+	helpers.ApiKeyCollection = mt.Coll
+	return services.NewApiKeyService(newTestUserValidator(logger), configuration.ApiKeyConfigurations{}, logger)
+}
+
+func TestGenerateApiKey_Should_Embed_Prefix(t *testing.T) {
+	key, prefix, err := helpers.GenerateApiKey()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(key, models.ApiKeyPrefix+"_"+prefix+"_"))
+
+	parsed, ok := helpers.ParseApiKeyPrefix(key)
+	assert.True(t, ok)
+	assert.Equal(t, prefix, parsed)
+
+	for _, invalid := range []string{"", "abc", "umk_short_secret", "xyz_" + prefix + "_secret", "umk_" + prefix + "_"} {
+		_, ok = helpers.ParseApiKeyPrefix(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestCreateApiKey_Should_Store_Hashed_Key(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid scopes", func(mt *mtest.T) {
+		apiKeyService := newTestApiKeyService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}),
+			mtest.CreateSuccessResponse())
+
+		result, message := apiKeyService.Create(c, models.CreateApiKeyModel{UserId: primitive.NewObjectID().Hex(),
+			Name: "billing", Scopes: []string{models.ReadUsersPermission}})
+		assert.Nil(t, message)
+		assert.True(t, strings.HasPrefix(result.Key, models.ApiKeyPrefix+"_"+result.Prefix+"_"))
+		assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), result.ExpiresAt, time.Minute)
+
+		started := mt.GetAllStartedEvents()
+		inserted := started[1].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, helpers.HashOpaqueToken(result.Key), inserted.Lookup("KeyHash").StringValue())
+		assert.Equal(t, result.Prefix, inserted.Lookup("Prefix").StringValue())
+		assert.NotContains(t, inserted.String(), result.Key)
+	})
+
+	mt.Run("unknown scope", func(mt *mtest.T) {
+		apiKeyService := newTestApiKeyService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		_, message := apiKeyService.Create(c, models.CreateApiKeyModel{UserId: primitive.NewObjectID().Hex(),
+			Name: "billing", Scopes: []string{models.ManageApiKeysPermission}})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidApiKeyScopeMessage, message.Error)
+	})
+
+	mt.Run("credential scope", func(mt *mtest.T) {
+		apiKeyService := newTestApiKeyService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		for _, scope := range services.CredentialApiKeyScopes {
+			_, message := apiKeyService.Create(c, models.CreateApiKeyModel{UserId: primitive.NewObjectID().Hex(),
+				Name: "provisioning", Scopes: []string{scope}})
+			assert.NotNil(t, message, scope)
+			assert.Equal(t, models.InvalidApiKeyScopeMessage, message.Error)
+		}
+
+		helpers.ApiKeyCollection = mt.Coll
+		apiKeyService = services.NewApiKeyService(newTestUserValidator(log.New()),
+			configuration.ApiKeyConfigurations{AllowCredentialScopes: true}, log.New())
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}),
+			mtest.CreateSuccessResponse())
+
+		_, message := apiKeyService.Create(c, models.CreateApiKeyModel{UserId: primitive.NewObjectID().Hex(),
+			Name: "provisioning", Scopes: []string{models.ProvisionUsersPermission}})
+		assert.Nil(t, message)
+	})
+
+	mt.Run("expiry too far", func(mt *mtest.T) {
+		apiKeyService := newTestApiKeyService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		expiresAt := time.Now().Add(2 * 365 * 24 * time.Hour)
+		_, message := apiKeyService.Create(c, models.CreateApiKeyModel{UserId: primitive.NewObjectID().Hex(),
+			Name: "billing", Scopes: []string{models.ReadUsersPermission}, ExpiresAt: &expiresAt})
+		assert.NotNil(t, message)
+		assert.Equal(t, models.InvalidApiKeyExpiryMessage, message.Error)
+	})
+}
+
+func TestAuthenticateApiKey_Should_Resolve_Principal(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	key, prefix, _ := helpers.GenerateApiKey()
+	userId := primitive.NewObjectID()
+	apiKeyId := primitive.NewObjectID()
+	apiKeyDocument := bson.D{
+		{"_id", apiKeyId},
+		{"UserId", userId},
+		{"Prefix", prefix},
+		{"KeyHash", helpers.HashOpaqueToken(key)},
+		{"Scopes", bson.A{models.ReadUsersPermission}},
+	}
+
+	mt.Run("valid key", func(mt *mtest.T) {
+		apiKeyService := newTestApiKeyService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, apiKeyDocument),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", userId},
+				{"Email", "billing@service.local"},
+				{"Roles", bson.A{models.AdminRole}},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		principal, message := apiKeyService.Authenticate(c, key)
+		assert.Nil(t, message)
+		assert.Equal(t, userId.Hex(), principal.UserId)
+		assert.Equal(t, apiKeyId.Hex(), principal.ApiKeyId)
+		assert.Equal(t, []string{models.ReadUsersPermission}, principal.ApiKeyScopes)
+		assert.Equal(t, []string{models.AdminRole}, principal.Roles)
+
+		started := mt.GetAllStartedEvents()
+		update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		_, err := update.LookupErr("u", "$set", "LastUsedAt")
+		assert.Nil(t, err)
+	})
+
+	mt.Run("wrong secret", func(mt *mtest.T) {
+		apiKeyService := newTestApiKeyService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, apiKeyDocument))
+
+		_, message := apiKeyService.Authenticate(c, models.ApiKeyPrefix+"_"+prefix+"_guessed")
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+	})
+}
+
+func TestAuthMiddleware_Should_Accept_Api_Key_As_Bearer_Token(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("bearer key", func(mt *mtest.T) {
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, newTestApiKeyService(mt), log.New())
+		router := newTestRouter(authMiddleware)
+
+		key, prefix, _ := helpers.GenerateApiKey()
+		userId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", userId},
+				{"Prefix", prefix},
+				{"KeyHash", helpers.HashOpaqueToken(key)},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"_id", userId}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodGet, "/users/"+userId.Hex(), nil)
+		request.Header.Set("Authorization", "Bearer "+key)
+
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, `"`+userId.Hex()+`"`, recorder.Body.String())
+	})
+}
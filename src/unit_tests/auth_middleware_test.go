@@ -0,0 +1,161 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-management-service/src/helpers"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+)
+
+func newTestRouter(authMiddleware *middlewares.AuthMiddleware) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	user := router.Group("/users", authMiddleware.Authenticate)
+	{
+		user.POST("", func(context *gin.Context) {
+			_, authenticated := middlewares.GetPrincipal(context)
+			context.JSON(http.StatusOK, authenticated)
+		})
+		user.GET("/:id", func(context *gin.Context) {
+			principal, _ := helpers.PrincipalFromContext(context.Request.Context())
+			context.JSON(http.StatusOK, principal.UserId)
+		})
+	}
+
+	return router
+}
+
+func TestAuthMiddleware_Should_Reject_Anonymous_Requests(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, nil, log.New())
+	router := newTestRouter(authMiddleware)
+
+	for _, header := range []string{"", "Basic abc", "Bearer ", "Bearer not-a-token"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodGet, "/users/"+primitive.NewObjectID().Hex(), nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, header)
+		assert.Equal(t, `"`+models.UnauthorizedErrorMessage+`"`, recorder.Body.String())
+		assert.Equal(t, models.BearerTokenType, recorder.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAuthMiddleware_Should_Set_Principal(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, nil, log.New())
+	router := newTestRouter(authMiddleware)
+
+	id := primitive.NewObjectID()
+	token, _, _ := tokenHelper.IssueAccessToken(models.UserEntity{Id: id, Email: "oguzhan@gmail.com"}, "")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodGet, "/users/"+id.Hex(), nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"`+id.Hex()+`"`, recorder.Body.String())
+}
+
+func TestAuthMiddleware_Should_Allow_Public_Routes(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, nil, log.New())
+	authMiddleware.Public(http.MethodPost, "/users")
+	router := newTestRouter(authMiddleware)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodPost, "/users", nil)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "false", recorder.Body.String())
+
+	token, _, _ := tokenHelper.IssueAccessToken(models.UserEntity{Id: primitive.NewObjectID()}, "")
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest(http.MethodPost, "/users", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "true", recorder.Body.String())
+}
+
+func TestAuthMiddleware_Should_Restrict_Scoped_Tokens(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, nil, log.New())
+	authMiddleware.AllowScope(models.PasswordChangeScope, http.MethodGet, "/users/:id")
+	router := newTestRouter(authMiddleware)
+
+	id := primitive.NewObjectID()
+	token, _, _ := tokenHelper.IssueRestrictedAccessToken(models.UserEntity{Id: id, Roles: []string{"admin"}},
+		models.PasswordChangeScope)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodPost, "/users", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, `"`+models.PasswordChangeRequiredMessage+`"`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest(http.MethodGet, "/users/"+id.Hex(), nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"`+id.Hex()+`"`, recorder.Body.String())
+
+	claims, _ := tokenHelper.ParseAccessToken(token)
+	assert.Empty(t, claims.Roles)
+}
+
+func TestAuthMiddleware_Should_Audit_Impersonation(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	logger, hook := logtest.NewNullLogger()
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, nil, logger)
+	authMiddleware.BlockImpersonation(http.MethodPost, "/users")
+	router := newTestRouter(authMiddleware)
+
+	id := primitive.NewObjectID()
+	actorId := primitive.NewObjectID()
+	token, _, _ := tokenHelper.IssueImpersonationToken(models.UserEntity{Id: id},
+		helpers.ActorClaims{Subject: actorId.Hex(), Email: "admin@gmail.com"}, time.Minute)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodGet, "/users/"+id.Hex(), nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"`+id.Hex()+`"`, recorder.Body.String())
+	assert.Equal(t, "Impersonated request", hook.LastEntry().Message)
+	assert.Equal(t, actorId.Hex(), hook.LastEntry().Data["ActorId"])
+	assert.Equal(t, id.Hex(), hook.LastEntry().Data["UserId"])
+	assert.Equal(t, http.StatusOK, hook.LastEntry().Data["Status"])
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest(http.MethodPost, "/users", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, `"`+models.ImpersonationForbiddenMessage+`"`, recorder.Body.String())
+	assert.Equal(t, "Impersonated request blocked", hook.LastEntry().Message)
+}
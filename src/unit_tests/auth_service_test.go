@@ -0,0 +1,264 @@
+package unit_tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+func newTestJwtConfig() configuration.JwtConfigurations {
+	return configuration.JwtConfigurations{
+		Issuer:         "test-issuer",
+		Audience:       "test-audience",
+		AccessTokenTtl: time.Minute,
+		Algorithm:      helpers.HS256Algorithm,
+		Secret:         "test-secret",
+	}
+}
+
+func TestTokenHelper_Should_Issue_And_Parse_Tokens(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDer, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	edPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDer})
+
+	hsConfig := newTestJwtConfig()
+	rsConfig := newTestJwtConfig()
+	rsConfig.Algorithm = helpers.RS256Algorithm
+	rsConfig.PrivateKey = string(rsaPem)
+	edConfig := newTestJwtConfig()
+	edConfig.Algorithm = helpers.EdDSAAlgorithm
+	edConfig.PrivateKey = string(edPem)
+
+	user := models.UserEntity{Id: primitive.NewObjectID(), Email: "oguzhan@gmail.com"}
+
+	for _, config := range []configuration.JwtConfigurations{hsConfig, rsConfig, edConfig} {
+		tokenHelper, err := helpers.NewTokenHelper(config)
+		assert.Nil(t, err)
+
+		token, claims, err := tokenHelper.IssueAccessToken(user, "")
+		assert.Nil(t, err)
+		assert.Equal(t, user.Id.Hex(), claims.Subject)
+
+		parsed, err := tokenHelper.ParseAccessToken(token)
+		assert.Nil(t, err, config.Algorithm)
+		assert.Equal(t, user.Id.Hex(), parsed.Subject)
+		assert.Equal(t, user.Email, parsed.Email)
+		assert.Equal(t, "test-issuer", parsed.Issuer)
+
+		_, err = tokenHelper.ParseAccessToken(token + "x")
+		assert.Equal(t, helpers.ErrInvalidToken, err)
+	}
+}
+
+func TestTokenHelper_Should_Reject_Foreign_Tokens(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+
+	otherConfig := newTestJwtConfig()
+	otherConfig.Audience = "other-audience"
+	otherHelper, _ := helpers.NewTokenHelper(otherConfig)
+
+	token, _, _ := otherHelper.IssueAccessToken(models.UserEntity{Id: primitive.NewObjectID()}, "")
+
+	_, err := tokenHelper.ParseAccessToken(token)
+	assert.Equal(t, helpers.ErrInvalidToken, err)
+
+	otherConfig = newTestJwtConfig()
+	otherConfig.Secret = "other-secret"
+	otherHelper, _ = helpers.NewTokenHelper(otherConfig)
+
+	token, _, _ = otherHelper.IssueAccessToken(models.UserEntity{Id: primitive.NewObjectID()}, "")
+
+	_, err = tokenHelper.ParseAccessToken(token)
+	assert.Equal(t, helpers.ErrInvalidToken, err)
+}
+
+func TestTokenHelper_Should_Not_Take_Other_Tokens_For_Access_Tokens(t *testing.T) {
+	config := newTestJwtConfig()
+	config.Audience = ""
+	tokenHelper, _ := helpers.NewTokenHelper(config)
+	user := models.UserEntity{Id: primitive.NewObjectID()}
+
+	token, claims, _ := tokenHelper.IssueAccessToken(user, "")
+	assert.Equal(t, jwt.ClaimStrings{helpers.DefaultAccessTokenAudience}, claims.Audience)
+
+	_, err := tokenHelper.ParseAccessToken(token)
+	assert.Nil(t, err)
+
+	purposeToken, _ := tokenHelper.IssuePurposeToken(models.EmailVerificationPurpose, user.Id.Hex(), "", time.Minute)
+	_, err = tokenHelper.ParseAccessToken(purposeToken)
+	assert.Equal(t, helpers.ErrInvalidToken, err)
+
+	idToken, _ := tokenHelper.IssueIdToken(helpers.IdTokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:  user.Id.Hex(),
+		Issuer:   config.Issuer,
+		Audience: jwt.ClaimStrings{primitive.NewObjectID().Hex()},
+	}})
+	_, err = tokenHelper.ParseAccessToken(idToken)
+	assert.Equal(t, helpers.ErrInvalidToken, err)
+
+	idToken, _ = tokenHelper.IssueIdToken(helpers.IdTokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:  user.Id.Hex(),
+		Issuer:   config.Issuer,
+		Audience: jwt.ClaimStrings{primitive.NewObjectID().Hex(), helpers.DefaultAccessTokenAudience},
+	}})
+	_, err = tokenHelper.ParseAccessToken(idToken)
+	assert.Equal(t, helpers.ErrInvalidToken, err)
+}
+
+func TestValidateLoginModel_Should_Not_Validate(t *testing.T) {
+	logger := log.New()
+	validator := validators.NewAuthValidator(logger)
+
+	result := validator.ValidateLoginModel(models.LoginModel{Email: "oguzhan@gmail.com"})
+	assert.NotNil(t, result)
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = validator.ValidateLoginModel(models.LoginModel{Password: "123"})
+	assert.NotNil(t, result)
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+}
+
+func TestLogin_Should_Issue_Token(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid credentials", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		authService := services.NewAuthService(validators.NewAuthValidator(logger), userService, tokenService, nil,
+			newTestLoginAttemptService(mt), configuration.EmailVerificationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", id},
+			{"Name", "oguzhan"},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", passwordHash},
+		}), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		result, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com",
+			Password: "s3cret-Password", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"})
+		assert.Nil(t, message)
+		assert.Equal(t, models.BearerTokenType, result.TokenType)
+		assert.Equal(t, id.Hex(), result.UserId)
+		assert.Equal(t, int64(60), result.ExpiresIn)
+		assert.NotEmpty(t, result.RefreshToken)
+
+		claims, err := tokenHelper.ParseAccessToken(result.AccessToken)
+		assert.Nil(t, err)
+		assert.Equal(t, id.Hex(), claims.Subject)
+
+		started := mt.GetAllStartedEvents()
+		session := started[2].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, claims.SessionId, session.Lookup("_id").ObjectID().Hex())
+		assert.Equal(t, "iPhone", session.Lookup("Device").StringValue())
+		refreshToken := started[3].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, claims.SessionId, refreshToken.Lookup("FamilyId").ObjectID().Hex())
+	})
+
+	mt.Run("wrong password", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		authService := services.NewAuthService(validators.NewAuthValidator(logger), userService, tokenService, nil,
+			newTestLoginAttemptService(mt), configuration.EmailVerificationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", passwordHash},
+		}))
+
+		_, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com", Password: "wrong"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+		assert.Equal(t, models.InvalidCredentialsErrorMessage, message.Error)
+	})
+}
+
+func TestLogin_Should_Issue_Restricted_Token_When_Password_Expired(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("expired password", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		authService := services.NewAuthService(validators.NewAuthValidator(logger), userService, tokenService, nil,
+			newTestLoginAttemptService(mt), configuration.EmailVerificationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", passwordHash},
+			{"Roles", bson.A{models.AdminRole}},
+			{"PasswordChangedAt", time.Now().Add(-91 * 24 * time.Hour)},
+		}), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		result, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com",
+			Password: "s3cret-Password"})
+		assert.Nil(t, message)
+		assert.True(t, result.PasswordChangeRequired)
+		assert.Equal(t, models.PasswordChangeScope, result.Scope)
+		assert.Empty(t, result.RefreshToken)
+
+		claims, err := tokenHelper.ParseAccessToken(result.AccessToken)
+		assert.Nil(t, err)
+		assert.Equal(t, models.PasswordChangeScope, claims.Scope)
+		assert.Empty(t, claims.Roles)
+
+		for _, event := range mt.GetAllStartedEvents() {
+			assert.NotEqual(t, "insert", event.CommandName)
+		}
+	})
+}
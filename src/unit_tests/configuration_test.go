@@ -0,0 +1,46 @@
+package unit_tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"user-management-service/src/configuration"
+)
+
+func newTestSecretsConfig() *configuration.Configurations {
+	return &configuration.Configurations{
+		Jwt:        configuration.JwtConfigurations{Algorithm: "HS256", Secret: "a-real-jwt-secret"},
+		TwoFactor:  configuration.TwoFactorConfigurations{EncryptionKey: "q0Vb0y9w3x5ZrZ2mJp1cN8kT6sH4dF7gL2aE9uW3yRo="},
+		Pagination: configuration.PaginationConfigurations{CursorSecret: "a-real-cursor-secret"},
+	}
+}
+
+func TestValidateSecrets_Should_Accept_Configured_Secrets(t *testing.T) {
+	assert.Nil(t, newTestSecretsConfig().ValidateSecrets())
+
+	config := newTestSecretsConfig()
+	config.Jwt = configuration.JwtConfigurations{Algorithm: "RS256"}
+
+	assert.Nil(t, config.ValidateSecrets())
+}
+
+func TestValidateSecrets_Should_Reject_Missing_Or_Published_Secrets(t *testing.T) {
+	cases := map[string]func(config *configuration.Configurations){
+		"empty jwt secret":     func(config *configuration.Configurations) { config.Jwt.Secret = "" },
+		"default jwt secret":   func(config *configuration.Configurations) { config.Jwt.Secret = "change-me-in-production" },
+		"empty encryption key": func(config *configuration.Configurations) { config.TwoFactor.EncryptionKey = " " },
+		"published encryption key": func(config *configuration.Configurations) {
+			config.TwoFactor.EncryptionKey = "eWk7IV/1TKaI6uhKAZrW9uvJRFWRyJhqCCSfNrcvaDk="
+		},
+		"empty cursor secret":   func(config *configuration.Configurations) { config.Pagination.CursorSecret = "" },
+		"default cursor secret": func(config *configuration.Configurations) { config.Pagination.CursorSecret = "change-me-cursor-secret" },
+	}
+
+	for name, change := range cases {
+		t.Run(name, func(t *testing.T) {
+			config := newTestSecretsConfig()
+			change(config)
+
+			assert.NotNil(t, config.ValidateSecrets())
+		})
+	}
+}
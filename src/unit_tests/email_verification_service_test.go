@@ -0,0 +1,249 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+func newTestEmailVerificationService(sender helpers.INotificationSender) (*services.EmailVerificationService,
+	*helpers.TokenHelper) {
+	logger := log.New()
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+
+	return services.NewEmailVerificationService(newTestUserValidator(logger), tokenHelper, sender,
+		configuration.EmailVerificationConfigurations{TokenTtl: time.Minute,
+			VerifyUrl: "http://localhost/users/verify-email",
+			ChangeUrl: "http://localhost/users/email-change/confirm"}, logger), tokenHelper
+}
+
+func TestSendVerification_Should_Send_Signed_Link(t *testing.T) {
+	sender := helpers.NewInMemoryNotificationSender()
+	emailVerificationService, tokenHelper := newTestEmailVerificationService(sender)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	userId := primitive.NewObjectID()
+	message := emailVerificationService.SendVerification(c, models.UserEntity{Id: userId,
+		Email: "oguzhan@gmail.com"})
+	assert.Nil(t, message)
+
+	messages := sender.Messages()
+	assert.Equal(t, 1, len(messages))
+
+	parsed, _ := url.Parse(messages[0].Body[strings.Index(messages[0].Body, "http://"):])
+	claims, err := tokenHelper.ParsePurposeToken(parsed.Query().Get("token"), models.EmailVerificationPurpose)
+	assert.Nil(t, err)
+	assert.Equal(t, userId.Hex(), claims.Subject)
+	assert.Equal(t, "oguzhan@gmail.com", claims.Email)
+
+	_, err = tokenHelper.ParseAccessToken(parsed.Query().Get("token"))
+	assert.Equal(t, helpers.ErrInvalidToken, err)
+}
+
+func TestVerifyEmail_Should_Reject_Access_Tokens(t *testing.T) {
+	emailVerificationService, tokenHelper := newTestEmailVerificationService(helpers.NewInMemoryNotificationSender())
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	token, _, _ := tokenHelper.IssueAccessToken(models.UserEntity{Id: primitive.NewObjectID()}, "")
+
+	message := emailVerificationService.VerifyEmail(c, models.VerifyEmailModel{Token: token})
+	assert.NotNil(t, message)
+	assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+	assert.Equal(t, models.InvalidVerificationTokenMessage, message.Error)
+}
+
+func TestVerifyEmail_Should_Mark_Email_Verified(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid token", func(mt *mtest.T) {
+		emailVerificationService, tokenHelper := newTestEmailVerificationService(
+			helpers.NewInMemoryNotificationSender())
+		helpers.UserCollection = mt.Coll
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		token, _ := tokenHelper.IssuePurposeToken(models.EmailVerificationPurpose, userId.Hex(),
+			"oguzhan@gmail.com", time.Minute)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{"_id", userId},
+			{"Email", "oguzhan@gmail.com"},
+		}}))
+
+		message := emailVerificationService.VerifyEmail(c, models.VerifyEmailModel{Token: token})
+		assert.Nil(t, message)
+
+		command := mt.GetStartedEvent().Command
+		assert.Equal(t, userId, command.Lookup("query", "_id").ObjectID())
+		assert.Equal(t, "oguzhan@gmail.com", command.Lookup("query", "Email").StringValue())
+		assert.True(t, command.Lookup("update", "$set", "EmailVerified").Boolean())
+	})
+}
+
+func TestRequestEmailChange_Should_Confirm_New_Address(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	userId := primitive.NewObjectID()
+	userDocument := bson.D{{"_id", userId}, {"Email", "oguzhan@gmail.com"}}
+
+	mt.Run("request", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		emailVerificationService, tokenHelper := newTestEmailVerificationService(sender)
+		helpers.UserCollection = mt.Coll
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := emailVerificationService.RequestEmailChange(c, models.EmailChangeModel{Id: userId.Hex(),
+			Email: "oguzhan@yahoo.com"})
+		assert.Nil(t, message)
+
+		update := mt.GetAllStartedEvents()[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, "oguzhan@yahoo.com", update.Lookup("u", "$set", "PendingEmail").StringValue())
+		assert.Nil(t, update.Lookup("u", "$set", "Email").Value)
+
+		messages := sender.Messages()
+		assert.Equal(t, 2, len(messages))
+		assert.Equal(t, "oguzhan@yahoo.com", messages[0].To)
+		assert.Equal(t, "oguzhan@gmail.com", messages[1].To)
+
+		parsed, _ := url.Parse(messages[0].Body[strings.Index(messages[0].Body, "http://"):])
+		assert.Equal(t, "/users/email-change/confirm", parsed.Path)
+		claims, err := tokenHelper.ParsePurposeToken(parsed.Query().Get("token"), models.EmailChangePurpose)
+		assert.Nil(t, err)
+		assert.Equal(t, userId.Hex(), claims.Subject)
+		assert.Equal(t, "oguzhan@yahoo.com", claims.Email)
+	})
+
+	mt.Run("email taken", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		emailVerificationService, _ := newTestEmailVerificationService(sender)
+		helpers.UserCollection = mt.Coll
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}))
+
+		message := emailVerificationService.RequestEmailChange(c, models.EmailChangeModel{Id: userId.Hex(),
+			Email: "taken@gmail.com"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusForbidden, message.StatusCode)
+		assert.Equal(t, models.EmailExistMessage, message.Error)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+		message = emailVerificationService.RequestEmailChange(c, models.EmailChangeModel{Id: userId.Hex(),
+			Email: "oguzhan@gmail.com"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, 0, len(sender.Messages()))
+	})
+
+	mt.Run("confirm", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		emailVerificationService, tokenHelper := newTestEmailVerificationService(sender)
+		helpers.UserCollection = mt.Coll
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		token, _ := tokenHelper.IssuePurposeToken(models.EmailChangePurpose, userId.Hex(), "oguzhan@yahoo.com",
+			time.Minute)
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: userDocument}))
+
+		message := emailVerificationService.ConfirmEmailChange(c, models.VerifyEmailModel{Token: token})
+		assert.Nil(t, message)
+
+		command := mt.GetAllStartedEvents()[1].Command
+		assert.Equal(t, userId, command.Lookup("query", "_id").ObjectID())
+		assert.Equal(t, "oguzhan@yahoo.com", command.Lookup("query", "PendingEmail").StringValue())
+		assert.Equal(t, "oguzhan@yahoo.com", command.Lookup("update", "$set", "Email").StringValue())
+		assert.True(t, command.Lookup("update", "$set", "EmailVerified").Boolean())
+		assert.NotNil(t, command.Lookup("update", "$unset", "PendingEmail").Value)
+
+		messages := sender.Messages()
+		assert.Equal(t, 1, len(messages))
+		assert.Equal(t, "oguzhan@gmail.com", messages[0].To)
+	})
+
+	mt.Run("invalid token", func(mt *mtest.T) {
+		emailVerificationService, tokenHelper := newTestEmailVerificationService(
+			helpers.NewInMemoryNotificationSender())
+		helpers.UserCollection = mt.Coll
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		verificationToken, _ := tokenHelper.IssuePurposeToken(models.EmailVerificationPurpose, userId.Hex(),
+			"oguzhan@yahoo.com", time.Minute)
+
+		message := emailVerificationService.ConfirmEmailChange(c, models.VerifyEmailModel{Token: verificationToken})
+		assert.NotNil(t, message)
+		assert.Equal(t, models.InvalidEmailChangeTokenMessage, message.Error)
+
+		// A newer request replaced the pending address.
+		token, _ := tokenHelper.IssuePurposeToken(models.EmailChangePurpose, userId.Hex(), "oguzhan@yahoo.com",
+			time.Minute)
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		message = emailVerificationService.ConfirmEmailChange(c, models.VerifyEmailModel{Token: token})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidEmailChangeTokenMessage, message.Error)
+	})
+}
+
+func TestLogin_Should_Require_Verified_Email(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unverified", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		authService := services.NewAuthService(validators.NewAuthValidator(logger), userService, tokenService, nil,
+			newTestLoginAttemptService(mt), configuration.EmailVerificationConfigurations{Required: true}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", passwordHash},
+			{"EmailVerified", false},
+		}))
+
+		_, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com",
+			Password: "s3cret-Password"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusForbidden, message.StatusCode)
+		assert.Equal(t, models.EmailNotVerifiedErrorMessage, message.Error)
+	})
+}
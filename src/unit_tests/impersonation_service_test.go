@@ -0,0 +1,91 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+func TestImpersonate_Should_Issue_Token_With_Actor(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("impersonate", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		impersonationService := services.NewImpersonationService(newTestUserValidator(logger), tokenHelper,
+			configuration.ImpersonationConfigurations{TokenTtl: time.Hour}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		actorId := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", id},
+			{"Email", "oguzhan@gmail.com"},
+			{"Roles", bson.A{models.UserRole}},
+		}))
+
+		result, message := impersonationService.Impersonate(c, models.ImpersonateModel{UserId: id.Hex(),
+			ActorId: actorId.Hex(), ActorEmail: "admin@gmail.com", Reason: "ticket 42"})
+		assert.Nil(t, message)
+		assert.Empty(t, result.RefreshToken)
+		assert.Equal(t, actorId.Hex(), result.ImpersonatedBy)
+		assert.Equal(t, int64(60), result.ExpiresIn)
+
+		claims, err := tokenHelper.ParseAccessToken(result.AccessToken)
+		assert.Nil(t, err)
+		assert.Equal(t, id.Hex(), claims.Subject)
+		assert.Equal(t, []string{models.UserRole}, claims.Roles)
+		assert.Equal(t, &helpers.ActorClaims{Subject: actorId.Hex(), Email: "admin@gmail.com"}, claims.Actor)
+		assert.Empty(t, claims.SessionId)
+	})
+
+	mt.Run("privileged target", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		impersonationService := services.NewImpersonationService(newTestUserValidator(logger), tokenHelper,
+			configuration.ImpersonationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		for _, target := range []bson.E{
+			{"Roles", bson.A{models.UserRole, models.AdminRole}},
+			{"Permissions", bson.A{models.ImpersonateUsersPermission}},
+		} {
+			id := primitive.NewObjectID()
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+				bson.D{{"_id", id}, {"Email", "other-admin@gmail.com"}, target}))
+
+			_, message := impersonationService.Impersonate(c, models.ImpersonateModel{UserId: id.Hex(),
+				ActorId: primitive.NewObjectID().Hex(), Reason: "ticket 42"})
+			assert.NotNil(t, message)
+			assert.Equal(t, http.StatusForbidden, message.StatusCode)
+			assert.Equal(t, models.AdminImpersonationMessage, message.Error)
+		}
+	})
+
+	mt.Run("self", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		impersonationService := services.NewImpersonationService(newTestUserValidator(logger), tokenHelper,
+			configuration.ImpersonationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID().Hex()
+		_, message := impersonationService.Impersonate(c, models.ImpersonateModel{UserId: id, ActorId: id})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+	})
+}
@@ -0,0 +1,175 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/controllers"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+func newTestLoginAttemptService(mt *mtest.T) *services.LoginAttemptService {
+	logger := log.New()
+	helpers.LoginAttemptCollection = mt.Coll
+
+	return services.NewLoginAttemptService(newTestUserValidator(logger), configuration.LockoutConfigurations{
+		AccountThreshold: 3,
+		IpThreshold:      10,
+		BaseDelay:        time.Minute,
+		MaxDelay:         10 * time.Minute,
+		ResetAfter:       time.Hour,
+	}, logger)
+}
+
+func TestLoginAttempt_Should_Reject_Locked_Account(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("locked", func(mt *mtest.T) {
+		loginAttemptService := newTestLoginAttemptService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", "account:oguzhan@gmail.com"},
+			{"Failures", 3},
+			{"LockedUntil", time.Now().Add(2 * time.Minute)},
+		}))
+
+		message := loginAttemptService.Check(c, "Oguzhan@gmail.com", "10.0.0.1")
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusTooManyRequests, message.StatusCode)
+		assert.Equal(t, models.TooManyLoginAttemptsMessage, message.Error)
+		assert.True(t, message.RetryAfter > time.Minute && message.RetryAfter <= 2*time.Minute)
+
+		keys, _ := mt.GetStartedEvent().Command.Lookup("filter", "_id", "$in").Array().Values()
+		assert.Equal(t, "account:oguzhan@gmail.com", keys[0].StringValue())
+		assert.Equal(t, "ip:10.0.0.1", keys[1].StringValue())
+	})
+}
+
+func TestLoginAttempt_Should_Lock_With_Exponential_Backoff(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	for failures, delay := range map[int]time.Duration{3: time.Minute, 5: 4 * time.Minute, 9: 10 * time.Minute} {
+		failures, delay := failures, delay
+
+		mt.Run("failures", func(mt *mtest.T) {
+			loginAttemptService := newTestLoginAttemptService(mt)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+					{"_id", "account:oguzhan@gmail.com"},
+					{"Failures", failures},
+				}}),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+			before := time.Now()
+			loginAttemptService.RegisterFailure(c, "oguzhan@gmail.com", "")
+
+			started := mt.GetAllStartedEvents()
+			assert.Equal(t, 3, len(started))
+			update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+			lockedUntil := update.Lookup("u", "$set", "LockedUntil").Time()
+			assert.WithinDuration(t, before.Add(delay), lockedUntil, 2*time.Second)
+		})
+	}
+
+	mt.Run("below threshold", func(mt *mtest.T) {
+		loginAttemptService := newTestLoginAttemptService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", "account:oguzhan@gmail.com"},
+				{"Failures", 2},
+			}}))
+
+		loginAttemptService.RegisterFailure(c, "oguzhan@gmail.com", "")
+
+		assert.Equal(t, 2, len(mt.GetAllStartedEvents()))
+	})
+}
+
+func TestUnlock_Should_Clear_Account_Counter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unlock", func(mt *mtest.T) {
+		loginAttemptService := newTestLoginAttemptService(mt)
+		helpers.UserCollection = mt.Coll
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "Oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		message := loginAttemptService.Unlock(c, models.UnlockUserModel{Id: id.Hex()})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		deleted := started[1].Command.Lookup("deletes").Array().Index(0).Value().Document()
+		assert.Equal(t, "account:oguzhan@gmail.com", deleted.Lookup("q", "_id").StringValue())
+	})
+}
+
+func TestLogin_Should_Count_Failures_For_Connection_Address(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("spoofed forwarded header", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger),
+			newTestHasher(helpers.Argon2idAlgorithm, 1, 4), nil, nil, newTestPasswordPolicyConfig(),
+			configuration.PaginationConfigurations{}, logger)
+		authService := services.NewAuthService(validators.NewAuthValidator(logger), userService, nil, nil,
+			newTestLoginAttemptService(mt), configuration.EmailVerificationConfigurations{}, logger)
+		authController := controllers.NewAuthController(authService, nil, nil, logger)
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		assert.Nil(t, router.SetTrustedProxies(configuration.ServerConfigurations{}.TrustedProxies))
+		router.POST("/auth/login", authController.Login)
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodPost, "/auth/login",
+			strings.NewReader(`{"email":"oguzhan@gmail.com","password":"wrong"}`))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Forwarded-For", "203.0.113.7")
+		request.RemoteAddr = "10.0.0.1:51234"
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+		keys, _ := mt.GetAllStartedEvents()[0].Command.Lookup("filter", "_id", "$in").Array().Values()
+		assert.Equal(t, "ip:10.0.0.1", keys[1].StringValue())
+
+		for _, event := range mt.GetAllStartedEvents()[2:] {
+			assert.NotContains(t, event.Command.String(), "203.0.113.7")
+		}
+	})
+}
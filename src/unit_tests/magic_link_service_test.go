@@ -0,0 +1,273 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+func newTestMagicLinkService(mt *mtest.T, sender helpers.INotificationSender) *services.MagicLinkService {
+	logger := log.New()
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	helpers.UserCollection = mt.Coll
+	helpers.RefreshTokenCollection = mt.Coll
+	helpers.SessionCollection = mt.Coll
+	helpers.MagicLinkTokenCollection = mt.Coll
+	twoFactorService, _ := newTestTwoFactorService(mt)
+
+	tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+	userService := services.NewUserService(newTestUserValidator(logger), nil, tokenService, nil,
+		newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+
+	return services.NewMagicLinkService(validators.NewAuthValidator(logger), userService, tokenService,
+		twoFactorService, newTestLoginAttemptService(mt), sender,
+		configuration.MagicLinkConfigurations{TokenTtl: time.Minute, LoginUrl: "http://localhost/magic-link",
+			MaxRequests: 3, Window: time.Hour}, logger)
+}
+
+func TestRequestMagicLink_Should_Send_Link(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("known email", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		magicLinkService := newTestMagicLinkService(mt, sender)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse())
+
+		message := magicLinkService.RequestLink(c, models.MagicLinkModel{Email: "oguzhan@gmail.com"})
+		assert.Nil(t, message)
+
+		messages := sender.Messages()
+		assert.Equal(t, 1, len(messages))
+		assert.Equal(t, "oguzhan@gmail.com", messages[0].To)
+
+		link := messages[0].Body[strings.Index(messages[0].Body, "http://"):]
+		parsed, _ := url.Parse(strings.TrimSpace(link))
+		token := parsed.Query().Get("token")
+		assert.NotEmpty(t, token)
+
+		started := mt.GetAllStartedEvents()
+		inserted := started[3].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, helpers.HashOpaqueToken(token), inserted.Lookup("TokenHash").StringValue())
+		assert.Equal(t, id, inserted.Lookup("UserId").ObjectID())
+	})
+
+	mt.Run("unknown email", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		magicLinkService := newTestMagicLinkService(mt, sender)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateSuccessResponse())
+
+		message := magicLinkService.RequestLink(c, models.MagicLinkModel{Email: "Nobody@gmail.com"})
+		assert.Nil(t, message)
+		assert.Empty(t, sender.Messages())
+
+		started := mt.GetAllStartedEvents()
+		assert.Contains(t, started[0].Command.String(), `"Nobody@gmail.com"`)
+		assert.Equal(t, "Nobody@gmail.com", started[1].Command.Lookup("filter", "Email").StringValue())
+		inserted := started[2].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, "Nobody@gmail.com", inserted.Lookup("Email").StringValue())
+		_, err := inserted.LookupErr("TokenHash")
+		assert.NotNil(t, err)
+	})
+
+	mt.Run("rate limited", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		magicLinkService := newTestMagicLinkService(mt, sender)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 3}}))
+
+		message := magicLinkService.RequestLink(c, models.MagicLinkModel{Email: "oguzhan@gmail.com"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusTooManyRequests, message.StatusCode)
+		assert.Equal(t, time.Hour, message.RetryAfter)
+		assert.Empty(t, sender.Messages())
+	})
+}
+
+func TestMagicLinkLogin_Should_Issue_Tokens_Once(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid link", func(mt *mtest.T) {
+		magicLinkService := newTestMagicLinkService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		tokenId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", tokenId},
+				{"UserId", id},
+				{"TokenHash", helpers.HashOpaqueToken("link-token")},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		result, message := magicLinkService.Login(c, models.MagicLinkLoginModel{Token: "link-token"})
+		assert.Nil(t, message)
+		assert.Equal(t, id.Hex(), result.UserId)
+		assert.NotEmpty(t, result.RefreshToken)
+
+		started := mt.GetAllStartedEvents()
+		update := started[3].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, tokenId, update.Lookup("q", "_id").ObjectID())
+	})
+
+	mt.Run("used link", func(mt *mtest.T) {
+		magicLinkService := newTestMagicLinkService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		_, message := magicLinkService.Login(c, models.MagicLinkLoginModel{Token: "link-token"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+		assert.Equal(t, models.InvalidMagicLinkMessage, message.Error)
+	})
+
+	mt.Run("two-factor code missing", func(mt *mtest.T) {
+		magicLinkService := newTestMagicLinkService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", id},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"TwoFactorEnabled", true},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		_, message := magicLinkService.Login(c, models.MagicLinkLoginModel{Token: "link-token"})
+		assert.NotNil(t, message)
+		assert.Equal(t, models.TwoFactorRequiredMessage, message.Error)
+		assert.Equal(t, 3, len(mt.GetAllStartedEvents()))
+	})
+
+	mt.Run("wrong two-factor code", func(mt *mtest.T) {
+		magicLinkService := newTestMagicLinkService(mt, helpers.NewInMemoryNotificationSender())
+		_, encryptor := newTestTwoFactorService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		secret, _ := encryptor.Encrypt("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", id},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+				{"TwoFactorEnabled", true},
+				{"TwoFactorSecret", secret},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{"Failures", 1}}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{"Failures", 1}}}))
+
+		_, message := magicLinkService.Login(c, models.MagicLinkLoginModel{Token: "link-token", Code: "not-a-code",
+			ClientIp: "10.0.0.1"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+		assert.Equal(t, models.InvalidTwoFactorCodeMessage, message.Error)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, "findAndModify", started[5].CommandName)
+		assert.Equal(t, "account:oguzhan@gmail.com", started[5].Command.Lookup("query", "_id").StringValue())
+	})
+
+	mt.Run("locked account", func(mt *mtest.T) {
+		magicLinkService := newTestMagicLinkService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", id},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", "account:oguzhan@gmail.com"},
+				{"LockedUntil", time.Now().Add(time.Minute)},
+			}))
+
+		_, message := magicLinkService.Login(c, models.MagicLinkLoginModel{Token: "link-token"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusTooManyRequests, message.StatusCode)
+		assert.Equal(t, 3, len(mt.GetAllStartedEvents()))
+	})
+
+	mt.Run("expired password", func(mt *mtest.T) {
+		magicLinkService := newTestMagicLinkService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", id},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+				{"MustChangePassword", true},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		result, message := magicLinkService.Login(c, models.MagicLinkLoginModel{Token: "link-token"})
+		assert.Nil(t, message)
+		assert.True(t, result.PasswordChangeRequired)
+		assert.Equal(t, models.PasswordChangeScope, result.Scope)
+		assert.Empty(t, result.RefreshToken)
+	})
+}
@@ -0,0 +1,436 @@
+package unit_tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"user-management-service/src/configuration"
+	"user-management-service/src/controllers"
+	"user-management-service/src/helpers"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+const (
+	testRedirectUri  = "http://127.0.0.1/callback"
+	testClientSecret = "client-secret"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newTestOAuthServer serves the OAuth routes as main.go registers them.
+func newTestOAuthServer(mt *mtest.T) (*httptest.Server, *helpers.TokenHelper) {
+	gin.SetMode(gin.TestMode)
+	logger := log.New()
+	helpers.UserCollection = mt.Coll
+	helpers.RefreshTokenCollection = mt.Coll
+	helpers.SessionCollection = mt.Coll
+	helpers.OAuthClientCollection = mt.Coll
+	helpers.AuthorizationCodeCollection = mt.Coll
+
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+	userService := services.NewUserService(newTestUserValidator(logger), nil, tokenService, nil,
+		newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+	oauthService := services.NewOAuthService(validators.NewOAuthValidator(logger), userService, tokenService,
+		tokenHelper, configuration.OAuthConfigurations{Issuer: "http://localhost:8080/"}, logger)
+	oauthController := controllers.NewOAuthController(oauthService, services.NewPermissionService(logger), logger)
+	authMiddleware := middlewares.NewAuthMiddleware(tokenHelper, nil, logger)
+
+	router := gin.New()
+	router.GET("/.well-known/openid-configuration", oauthController.Discovery)
+	router.POST("/oauth/token", oauthController.Token)
+
+	oauth := router.Group("/oauth", authMiddleware.Authenticate)
+	{
+		oauth.GET("/authorize", oauthController.Authorize)
+		oauth.GET("/userinfo", oauthController.UserInfo)
+	}
+
+	return httptest.NewServer(router), tokenHelper
+}
+
+func newTestCodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newTestOAuthClientDocument(clientId primitive.ObjectID) bson.D {
+	return bson.D{
+		{"_id", clientId},
+		{"Name", "dashboard"},
+		{"SecretHash", helpers.HashOpaqueToken(testClientSecret)},
+		{"RedirectUris", bson.A{testRedirectUri}},
+	}
+}
+
+func TestOAuth_Should_Complete_Authorization_Code_Flow(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("authorization code with pkce", func(mt *mtest.T) {
+		server, tokenHelper := newTestOAuthServer(mt)
+		defer server.Close()
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+
+		clientId := primitive.NewObjectID()
+		userId := primitive.NewObjectID()
+		accessToken, _, _ := tokenHelper.IssueAccessToken(models.UserEntity{Id: userId, Email: "oguzhan@gmail.com"}, "")
+
+		// Authorization endpoint.
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)),
+			mtest.CreateSuccessResponse())
+
+		query := url.Values{
+			"response_type":         {models.CodeResponseType},
+			"client_id":             {clientId.Hex()},
+			"redirect_uri":          {testRedirectUri},
+			"scope":                 {"openid email"},
+			"state":                 {"xyz"},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {newTestCodeChallenge(testCodeVerifier)},
+			"code_challenge_method": {models.S256CodeChallengeMethod},
+		}
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/oauth/authorize?"+query.Encode(), nil)
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+
+		response, err := client.Do(request)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusFound, response.StatusCode)
+
+		location, _ := url.Parse(response.Header.Get("Location"))
+		assert.True(t, strings.HasPrefix(location.String(), testRedirectUri+"?"))
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+		code := location.Query().Get("code")
+		assert.NotEmpty(t, code)
+
+		inserted := mt.GetStartedEvent()
+		for inserted != nil && inserted.CommandName != "insert" {
+			inserted = mt.GetStartedEvent()
+		}
+		codeDocument := inserted.Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, helpers.HashOpaqueToken(code), codeDocument.Lookup("CodeHash").StringValue())
+		assert.Equal(t, userId, codeDocument.Lookup("UserId").ObjectID())
+		assert.True(t, codeDocument.Lookup("RedirectUriRequired").Boolean())
+
+		// Token endpoint.
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"ClientId", clientId},
+				{"UserId", userId},
+				{"RedirectUri", testRedirectUri},
+				{"Scope", "openid email"},
+				{"Nonce", "n-0S6_WzA2Mj"},
+				{"CodeChallenge", newTestCodeChallenge(testCodeVerifier)},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", userId},
+				{"Name", "oguzhan"},
+				{"Email", "oguzhan@gmail.com"},
+				{"EmailVerified", true},
+			}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse())
+
+		form := url.Values{
+			"grant_type":    {models.AuthorizationCodeGrantType},
+			"code":          {code},
+			"redirect_uri":  {testRedirectUri},
+			"code_verifier": {testCodeVerifier},
+		}
+		request, _ = http.NewRequest(http.MethodPost, server.URL+"/oauth/token", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(clientId.Hex(), testClientSecret)
+
+		response, err = client.Do(request)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "no-store", response.Header.Get("Cache-Control"))
+
+		var tokens models.OAuthTokenResponseModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&tokens))
+		assert.Equal(t, models.BearerTokenType, tokens.TokenType)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.Equal(t, "openid email", tokens.Scope)
+
+		idToken := &helpers.IdTokenClaims{}
+		_, err = jwt.ParseWithClaims(tokens.IdToken, idToken, func(*jwt.Token) (interface{}, error) {
+			return []byte(newTestJwtConfig().Secret), nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "http://localhost:8080", idToken.Issuer)
+		assert.Equal(t, userId.Hex(), idToken.Subject)
+		assert.True(t, idToken.VerifyAudience(clientId.Hex(), true))
+		assert.Equal(t, "n-0S6_WzA2Mj", idToken.Nonce)
+		assert.Equal(t, "oguzhan@gmail.com", idToken.Email)
+		assert.Empty(t, idToken.Name)
+
+		// UserInfo endpoint.
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", userId},
+			{"Name", "oguzhan"},
+			{"Email", "oguzhan@gmail.com"},
+			{"EmailVerified", true},
+		}))
+
+		request, _ = http.NewRequest(http.MethodGet, server.URL+"/oauth/userinfo", nil)
+		request.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+
+		response, err = client.Do(request)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		var userInfo models.UserInfoResponseModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&userInfo))
+		assert.Equal(t, models.UserInfoResponseModel{Sub: userId.Hex(), Name: "oguzhan",
+			Email: "oguzhan@gmail.com", EmailVerified: true}, userInfo)
+	})
+}
+
+func TestOAuth_Should_Reject_Invalid_Requests(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unregistered redirect uri", func(mt *mtest.T) {
+		server, tokenHelper := newTestOAuthServer(mt)
+		defer server.Close()
+
+		clientId := primitive.NewObjectID()
+		accessToken, _, _ := tokenHelper.IssueAccessToken(models.UserEntity{Id: primitive.NewObjectID()}, "")
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)))
+
+		query := url.Values{
+			"response_type":         {models.CodeResponseType},
+			"client_id":             {clientId.Hex()},
+			"redirect_uri":          {"https://attacker.example/callback"},
+			"code_challenge":        {newTestCodeChallenge(testCodeVerifier)},
+			"code_challenge_method": {models.S256CodeChallengeMethod},
+		}
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/oauth/authorize?"+query.Encode(), nil)
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+
+		response, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		var errorResponse models.OAuthErrorResponseModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&errorResponse))
+		assert.Equal(t, models.OAuthInvalidRequestError, errorResponse.Error)
+	})
+
+	mt.Run("wrong code verifier", func(mt *mtest.T) {
+		server, _ := newTestOAuthServer(mt)
+		defer server.Close()
+
+		clientId := primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"ClientId", clientId},
+				{"UserId", primitive.NewObjectID()},
+				{"RedirectUri", testRedirectUri},
+				{"CodeChallenge", newTestCodeChallenge(testCodeVerifier)},
+			}}))
+
+		form := url.Values{
+			"grant_type":    {models.AuthorizationCodeGrantType},
+			"code":          {"code"},
+			"code_verifier": {"some-other-verifier"},
+			"client_id":     {clientId.Hex()},
+			"client_secret": {testClientSecret},
+		}
+		response, err := http.PostForm(server.URL+"/oauth/token", form)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		var errorResponse models.OAuthErrorResponseModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&errorResponse))
+		assert.Equal(t, models.OAuthInvalidGrantError, errorResponse.Error)
+	})
+
+	mt.Run("missing redirect uri", func(mt *mtest.T) {
+		server, _ := newTestOAuthServer(mt)
+		defer server.Close()
+
+		clientId := primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"ClientId", clientId},
+				{"UserId", primitive.NewObjectID()},
+				{"RedirectUri", testRedirectUri},
+				{"CodeChallenge", newTestCodeChallenge(testCodeVerifier)},
+				{"RedirectUriRequired", true},
+			}}))
+
+		form := url.Values{
+			"grant_type":    {models.AuthorizationCodeGrantType},
+			"code":          {"code"},
+			"code_verifier": {testCodeVerifier},
+			"client_id":     {clientId.Hex()},
+			"client_secret": {testClientSecret},
+		}
+		response, err := http.PostForm(server.URL+"/oauth/token", form)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		var errorResponse models.OAuthErrorResponseModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&errorResponse))
+		assert.Equal(t, models.OAuthInvalidGrantError, errorResponse.Error)
+	})
+
+	mt.Run("expired password", func(mt *mtest.T) {
+		server, _ := newTestOAuthServer(mt)
+		defer server.Close()
+
+		clientId := primitive.NewObjectID()
+		userId := primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"ClientId", clientId},
+				{"UserId", userId},
+				{"RedirectUri", testRedirectUri},
+				{"CodeChallenge", newTestCodeChallenge(testCodeVerifier)},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", userId},
+				{"Email", "oguzhan@gmail.com"},
+				{"MustChangePassword", true},
+			}))
+
+		form := url.Values{
+			"grant_type":    {models.AuthorizationCodeGrantType},
+			"code":          {"code"},
+			"code_verifier": {testCodeVerifier},
+			"client_id":     {clientId.Hex()},
+			"client_secret": {testClientSecret},
+		}
+		response, err := http.PostForm(server.URL+"/oauth/token", form)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		var errorResponse models.OAuthErrorResponseModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&errorResponse))
+		assert.Equal(t, models.OAuthInvalidGrantError, errorResponse.Error)
+
+		for _, event := range mt.GetAllStartedEvents() {
+			assert.NotEqual(t, "insert", event.CommandName)
+		}
+	})
+
+	mt.Run("wrong client secret", func(mt *mtest.T) {
+		server, _ := newTestOAuthServer(mt)
+		defer server.Close()
+
+		clientId := primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, newTestOAuthClientDocument(clientId)))
+
+		form := url.Values{
+			"grant_type":    {models.AuthorizationCodeGrantType},
+			"code":          {"code"},
+			"code_verifier": {testCodeVerifier},
+		}
+		request, _ := http.NewRequest(http.MethodPost, server.URL+"/oauth/token", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(clientId.Hex(), "guessed")
+
+		response, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+		assert.Equal(t, "Basic", response.Header.Get("WWW-Authenticate"))
+	})
+}
+
+func TestOAuth_Should_Serve_Discovery_Document(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("discovery", func(mt *mtest.T) {
+		server, _ := newTestOAuthServer(mt)
+		defer server.Close()
+
+		response, err := http.Get(server.URL + "/.well-known/openid-configuration")
+		assert.Nil(t, err)
+
+		var discovery models.OpenIdConfigurationModel
+		assert.Nil(t, json.NewDecoder(response.Body).Decode(&discovery))
+		assert.Equal(t, "http://localhost:8080", discovery.Issuer)
+		assert.Equal(t, "http://localhost:8080/oauth/token", discovery.TokenEndpoint)
+		assert.Equal(t, []string{helpers.HS256Algorithm}, discovery.IdTokenSigningAlgValuesSupported)
+		assert.Equal(t, []string{models.S256CodeChallengeMethod}, discovery.CodeChallengeMethodsSupported)
+	})
+}
+
+func TestValidateCreateOAuthClientModel_Should_Check_Redirect_Uris(t *testing.T) {
+	validator := validators.NewOAuthValidator(log.New())
+
+	for redirectUri, valid := range map[string]bool{
+		"https://app.example.com/callback":  true,
+		"http://localhost:3000/callback":    true,
+		"http://127.0.0.1/callback":         true,
+		"http://app.example.com/callback":   false,
+		"https://app.example.com/cb#token":  false,
+		"/callback":                         false,
+		"javascript:alert(1)":               false,
+		"https://app.example.com/callback#": false,
+	} {
+		result := validator.ValidateCreateOAuthClientModel(models.CreateOAuthClientModel{Name: "app",
+			RedirectUris: []string{redirectUri}})
+		assert.Equal(t, valid, result == nil, redirectUri)
+	}
+}
+
+func TestTokenHelper_Should_Publish_Asymmetric_Keys_Only(t *testing.T) {
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	assert.Empty(t, tokenHelper.Jwks().Keys)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsConfig := newTestJwtConfig()
+	rsConfig.Algorithm = helpers.RS256Algorithm
+	rsConfig.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+
+	tokenHelper, err := helpers.NewTokenHelper(rsConfig)
+	assert.Nil(t, err)
+
+	jwks := tokenHelper.Jwks()
+	assert.Equal(t, 1, len(jwks.Keys))
+	assert.Equal(t, "RSA", jwks.Keys[0].Kty)
+	assert.Equal(t, helpers.RS256Algorithm, jwks.Keys[0].Alg)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), jwks.Keys[0].N)
+}
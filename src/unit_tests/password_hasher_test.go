@@ -0,0 +1,96 @@
+package unit_tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+)
+
+func newTestHasher(algorithm string, iterations uint32, bcryptCost int) *helpers.PasswordHasher {
+	return helpers.NewPasswordHasher(configuration.PasswordHashingConfigurations{
+		Algorithm: algorithm,
+		Argon2: configuration.Argon2Configurations{
+			Memory:      1024,
+			Iterations:  iterations,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		Bcrypt: configuration.BcryptConfigurations{Cost: bcryptCost},
+	})
+}
+
+func TestPasswordHasher_Argon2id_Should_Verify(t *testing.T) {
+	hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+
+	hash, err := hasher.Hash("s3cret-Password")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.NotContains(t, hash, "s3cret-Password")
+
+	match, needsRehash, err := hasher.Verify("s3cret-Password", hash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+
+	match, _, err = hasher.Verify("wrong", hash)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
+
+func TestPasswordHasher_Bcrypt_Should_Verify(t *testing.T) {
+	hasher := newTestHasher(helpers.BcryptAlgorithm, 1, 4)
+
+	hash, err := hasher.Hash("s3cret-Password")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$04$"))
+
+	match, needsRehash, err := hasher.Verify("s3cret-Password", hash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+}
+
+func TestPasswordHasher_Should_Request_Rehash_When_Parameters_Change(t *testing.T) {
+	oldHasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+	hash, _ := oldHasher.Hash("s3cret-Password")
+
+	match, needsRehash, err := newTestHasher(helpers.Argon2idAlgorithm, 2, 4).Verify("s3cret-Password", hash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	match, needsRehash, err = newTestHasher(helpers.BcryptAlgorithm, 1, 4).Verify("s3cret-Password", hash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	bcryptHash, _ := newTestHasher(helpers.BcryptAlgorithm, 1, 4).Hash("s3cret-Password")
+	match, needsRehash, err = newTestHasher(helpers.BcryptAlgorithm, 1, 5).Verify("s3cret-Password", bcryptHash)
+	assert.Nil(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+}
+
+func TestPasswordHasher_Should_Reject_Legacy_Plaintext(t *testing.T) {
+	hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+
+	match, needsRehash, err := hasher.Verify("123", "123")
+	assert.Equal(t, helpers.ErrInvalidPasswordHash, err)
+	assert.False(t, match)
+	assert.False(t, needsRehash)
+
+	match, _, err = hasher.Verify("", "")
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
+
+func TestPasswordHasher_Should_Reject_Malformed_Hash(t *testing.T) {
+	hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+
+	match, _, err := hasher.Verify("123", "$argon2id$v=19$broken")
+	assert.False(t, match)
+	assert.Equal(t, helpers.ErrInvalidPasswordHash, err)
+}
@@ -0,0 +1,95 @@
+package unit_tests
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/models"
+	"user-management-service/src/validators"
+)
+
+func newTestPasswordPolicyConfig() configuration.PasswordPolicyConfigurations {
+	return configuration.PasswordPolicyConfigurations{
+		MinLength:            8,
+		MaxLength:            64,
+		RequireUppercase:     true,
+		RequireLowercase:     true,
+		RequireDigit:         true,
+		DisallowPersonalInfo: true,
+		HistorySize:          3,
+		MaxAge:               90 * 24 * time.Hour,
+	}
+}
+
+func newTestPasswordPolicy() *validators.PasswordPolicy {
+	passwordPolicy, _ := validators.NewPasswordPolicy(newTestPasswordPolicyConfig())
+	return passwordPolicy
+}
+
+func newTestUserValidator(logger *log.Logger) *validators.UserValidator {
+	return validators.NewUserValidator(newTestPasswordPolicy(), logger)
+}
+
+func TestPasswordPolicy_Should_Report_Failed_Rules(t *testing.T) {
+	passwordPolicy := newTestPasswordPolicy()
+
+	assert.Empty(t, passwordPolicy.Check("s3cret-Password", "oguzhan", "oguzhan@gmail.com"))
+
+	assert.Equal(t, []string{
+		"must be at least 8 characters",
+		"must contain an uppercase letter",
+		"must contain a lowercase letter",
+	}, passwordPolicy.Check("123", "", ""))
+
+	assert.Equal(t, []string{"must be at most 64 characters"},
+		passwordPolicy.Check("Aa1"+strings.Repeat("x", 62), "", ""))
+
+	assert.Equal(t, []string{"must not contain the name or email"},
+		passwordPolicy.Check("Oguzhan2024", "Oguzhan Yilmaz", ""))
+	assert.Equal(t, []string{"must not contain the name or email"},
+		passwordPolicy.Check("My-Yilmaz-1", "Oguzhan Yilmaz", ""))
+	assert.Equal(t, []string{"must not contain the name or email"},
+		passwordPolicy.Check("Mail-oguzhan1", "", "oguzhan@gmail.com"))
+}
+
+func TestPasswordPolicy_Should_Reject_Common_Passwords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("# comment\n\nPassword123\n"), 0644))
+
+	passwordPolicy, err := validators.NewPasswordPolicy(configuration.PasswordPolicyConfigurations{
+		RequireSymbol:      true,
+		CommonPasswordFile: path,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"must contain a symbol",
+		"is too common or has appeared in a data breach",
+	}, passwordPolicy.Check("PASSWORD123", "", ""))
+	assert.Empty(t, passwordPolicy.Check("comment!", "", ""))
+
+	_, err = validators.NewPasswordPolicy(configuration.PasswordPolicyConfigurations{
+		CommonPasswordFile: filepath.Join(t.TempDir(), "missing.txt"),
+	})
+	assert.NotNil(t, err)
+}
+
+func TestValidateAddUserModel_Should_Return_Failed_Password_Rules(t *testing.T) {
+	validator := newTestUserValidator(log.New())
+
+	result := validator.ValidateAddUserModel(models.AddUserModel{
+		Name:     "oguzhan",
+		Email:    "oguzhan@gmail.com",
+		Password: "oguzhan",
+	})
+	assert.NotNil(t, result)
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	assert.Equal(t, models.PasswordPolicyErrorMessage+": must be at least 8 characters, "+
+		"must contain an uppercase letter, must contain a digit, must not contain the name or email", result.Error)
+}
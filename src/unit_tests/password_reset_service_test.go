@@ -0,0 +1,202 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+func newTestPasswordResetService(mt *mtest.T, sender helpers.INotificationSender) *services.PasswordResetService {
+	logger := log.New()
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	helpers.UserCollection = mt.Coll
+	helpers.RefreshTokenCollection = mt.Coll
+	helpers.SessionCollection = mt.Coll
+	helpers.PasswordResetTokenCollection = mt.Coll
+	tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+	userService := services.NewUserService(newTestUserValidator(logger),
+		newTestHasher(helpers.Argon2idAlgorithm, 1, 4), tokenService, nil, newTestPasswordPolicyConfig(),
+		configuration.PaginationConfigurations{}, logger)
+
+	return services.NewPasswordResetService(validators.NewAuthValidator(logger), userService, sender,
+		configuration.PasswordResetConfigurations{TokenTtl: time.Minute, ResetUrl: "http://localhost/reset"}, logger)
+}
+
+func TestFileNotificationSender_Should_Append_Messages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	sender := helpers.NewNotificationSender(configuration.NotificationConfigurations{
+		Sender:   helpers.FileNotificationSenderType,
+		FilePath: path,
+	})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Nil(t, sender.Send(c, models.NotificationMessage{To: "a@gmail.com", Subject: "first"}))
+	assert.Nil(t, sender.Send(c, models.NotificationMessage{To: "b@gmail.com", Subject: "second"}))
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var message models.NotificationMessage
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &message))
+	assert.Equal(t, "b@gmail.com", message.To)
+}
+
+func TestForgotPassword_Should_Not_Reveal_Unknown_Email(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unknown email", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		passwordResetService := newTestPasswordResetService(mt, sender)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		message := passwordResetService.ForgotPassword(c, models.ForgotPasswordModel{Email: "nobody@gmail.com"})
+		assert.Nil(t, message)
+		assert.Empty(t, sender.Messages())
+	})
+}
+
+func TestForgotPassword_Should_Send_Reset_Link(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("known email", func(mt *mtest.T) {
+		sender := helpers.NewInMemoryNotificationSender()
+		passwordResetService := newTestPasswordResetService(mt, sender)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse())
+
+		message := passwordResetService.ForgotPassword(c, models.ForgotPasswordModel{Email: "oguzhan@gmail.com"})
+		assert.Nil(t, message)
+
+		messages := sender.Messages()
+		assert.Equal(t, 1, len(messages))
+		assert.Equal(t, "oguzhan@gmail.com", messages[0].To)
+
+		link := messages[0].Body[strings.Index(messages[0].Body, "http://localhost/reset?token="):]
+		parsed, _ := url.Parse(link)
+		token := parsed.Query().Get("token")
+		assert.NotEmpty(t, token)
+
+		started := mt.GetAllStartedEvents()
+		inserted := started[2].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, helpers.HashOpaqueToken(token), inserted.Lookup("TokenHash").StringValue())
+	})
+}
+
+func TestResetPassword_Should_Reject_Invalid_Token(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("invalid token", func(mt *mtest.T) {
+		passwordResetService := newTestPasswordResetService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		message := passwordResetService.ResetPassword(c, models.ResetPasswordModel{Token: "used",
+			Password: "n3w-Password"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidResetTokenMessage, message.Error)
+	})
+}
+
+func TestResetPassword_Should_Set_Password(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid token", func(mt *mtest.T) {
+		passwordResetService := newTestPasswordResetService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", userId},
+				{"TokenHash", helpers.HashOpaqueToken("token")},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", userId},
+				{"Name", "oguzhan"},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := passwordResetService.ResetPassword(c, models.ResetPasswordModel{Token: "token",
+			Password: "n3w-Password"})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, userId, update.Lookup("q", "_id").ObjectID())
+		assert.True(t, strings.HasPrefix(update.Lookup("u", "$set", "Password").StringValue(), "$argon2id$"))
+		assert.Equal(t, "update", started[3].CommandName)
+	})
+}
+
+func TestResetPassword_Should_Keep_Token_When_Password_Rejected(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("weak password", func(mt *mtest.T) {
+		passwordResetService := newTestPasswordResetService(mt, helpers.NewInMemoryNotificationSender())
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		tokenId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", tokenId},
+				{"UserId", primitive.NewObjectID()},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"Name", "oguzhan"},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := passwordResetService.ResetPassword(c, models.ResetPasswordModel{Token: "token",
+			Password: "Oguzhan-2024"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.True(t, strings.HasPrefix(message.Error, models.PasswordPolicyErrorMessage))
+
+		started := mt.GetAllStartedEvents()
+		update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, tokenId, update.Lookup("q", "_id").ObjectID())
+		_, err := update.LookupErr("u", "$unset", "UsedAt")
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,102 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+func TestAuthorize_Should_Allow_Owner_And_Admin(t *testing.T) {
+	permissionService := services.NewPermissionService(log.New())
+
+	owner := models.Principal{UserId: "1", Roles: []string{models.UserRole}}
+	admin := models.Principal{UserId: "2", Roles: []string{models.UserRole, models.AdminRole}}
+
+	assert.Nil(t, permissionService.Authorize(owner, models.UpdateUsersPermission, "1"))
+	assert.Nil(t, permissionService.Authorize(owner, models.DeleteUsersPermission, "1"))
+	assert.Nil(t, permissionService.Authorize(admin, models.UpdateUsersPermission, "1"))
+	assert.Nil(t, permissionService.Authorize(admin, models.ListUsersPermission, ""))
+	assert.Nil(t, permissionService.Authorize(admin, models.ManageRolesPermission, ""))
+}
+
+func TestAuthorize_Should_Forbid_Other_Users(t *testing.T) {
+	permissionService := services.NewPermissionService(log.New())
+
+	user := models.Principal{UserId: "1", Roles: []string{models.UserRole}}
+
+	for _, result := range []*models.ErrorModel{
+		permissionService.Authorize(user, models.UpdateUsersPermission, "2"),
+		permissionService.Authorize(user, models.DeleteUsersPermission, "2"),
+		permissionService.Authorize(user, models.ReadUsersPermission, "2"),
+		permissionService.Authorize(user, models.ListUsersPermission, ""),
+		permissionService.Authorize(user, models.ManageRolesPermission, ""),
+		permissionService.Authorize(models.Principal{}, models.ReadUsersPermission, ""),
+	} {
+		assert.NotNil(t, result)
+		assert.Equal(t, http.StatusForbidden, result.StatusCode)
+		assert.Equal(t, models.ForbiddenErrorMessage, result.Error)
+	}
+
+	user.Permissions = []string{models.ListUsersPermission}
+	assert.Nil(t, permissionService.Authorize(user, models.ListUsersPermission, ""))
+}
+
+func TestValidateUserRoleModel_Should_Not_Validate(t *testing.T) {
+	validator := newTestUserValidator(log.New())
+
+	result := validator.ValidateUserRoleModel(models.UserRoleModel{Id: primitive.NewObjectID().Hex(),
+		Role: "superuser"})
+	assert.NotNil(t, result)
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	result = validator.ValidateUserRoleModel(models.UserRoleModel{Role: models.AdminRole})
+	assert.NotNil(t, result)
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+}
+
+func TestRevokeRole_Should_Keep_Last_Admin(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("last admin", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger),
+			newTestHasher(helpers.Argon2idAlgorithm, 1, 4), nil, nil, newTestPasswordPolicyConfig(),
+			configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}))
+
+		_, message := userService.RevokeRole(c, models.UserRoleModel{Id: primitive.NewObjectID().Hex(),
+			Role: models.AdminRole})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusConflict, message.StatusCode)
+		assert.Equal(t, models.LastAdminErrorMessage, message.Error)
+	})
+}
+
+func TestAuthorize_Should_Limit_Api_Keys_To_Scopes(t *testing.T) {
+	permissionService := services.NewPermissionService(log.New())
+
+	apiKey := models.Principal{UserId: "1", Roles: []string{models.AdminRole}, ApiKeyId: "key",
+		ApiKeyScopes: []string{models.ReadUsersPermission}}
+
+	assert.Nil(t, permissionService.Authorize(apiKey, models.ReadUsersPermission, "2"))
+	assert.NotNil(t, permissionService.Authorize(apiKey, models.DeleteUsersPermission, "2"))
+	assert.NotNil(t, permissionService.Authorize(apiKey, models.UpdateUsersPermission, "1"))
+
+	apiKey.Roles = []string{models.UserRole}
+	assert.Nil(t, permissionService.Authorize(apiKey, models.ReadUsersPermission, "1"))
+	assert.NotNil(t, permissionService.Authorize(apiKey, models.ReadUsersPermission, "2"))
+}
@@ -0,0 +1,73 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+)
+
+func TestPreconditionMiddleware_Should_Require_IfMatch_When_Configured(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, required := range []bool{true, false} {
+		preconditionMiddleware := middlewares.NewPreconditionMiddleware(
+			configuration.ConcurrencyConfigurations{RequireIfMatch: required}, log.New())
+		router := gin.New()
+		router.DELETE("/users/:id", preconditionMiddleware.RequireIfMatch, func(context *gin.Context) {
+			context.JSON(http.StatusOK, nil)
+		})
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodDelete, "/users/1", nil)
+		router.ServeHTTP(recorder, request)
+
+		if required {
+			assert.Equal(t, http.StatusPreconditionRequired, recorder.Code)
+			assert.Equal(t, `"`+models.PreconditionRequiredMessage+`"`, recorder.Body.String())
+		} else {
+			assert.Equal(t, http.StatusOK, recorder.Code)
+		}
+
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest(http.MethodDelete, "/users/1", nil)
+		request.Header.Set("If-Match", helpers.UserETag(1))
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+}
+
+func TestParseIfMatch_Should_Return_Version(t *testing.T) {
+	version, err := helpers.ParseIfMatch(helpers.UserETag(7))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), *version)
+
+	for _, header := range []string{"", "*"} {
+		version, err = helpers.ParseIfMatch(header)
+		assert.Nil(t, err)
+		assert.Nil(t, version)
+	}
+
+	for _, header := range []string{`W/"7"`, `7`, `"seven"`, `"-1"`, `"7", "8"`} {
+		_, err = helpers.ParseIfMatch(header)
+		assert.Equal(t, helpers.ErrETagMismatch, err, header)
+	}
+}
+
+func TestETagMatches_Should_Use_Weak_Comparison(t *testing.T) {
+	etag := helpers.UserETag(7)
+
+	assert.True(t, helpers.ETagMatches(`"7"`, etag))
+	assert.True(t, helpers.ETagMatches(`W/"7"`, etag))
+	assert.True(t, helpers.ETagMatches(`"6", "7"`, etag))
+	assert.True(t, helpers.ETagMatches(`*`, etag))
+	assert.False(t, helpers.ETagMatches(`"6"`, etag))
+	assert.False(t, helpers.ETagMatches(``, etag))
+}
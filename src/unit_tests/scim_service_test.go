@@ -0,0 +1,463 @@
+package unit_tests
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/controllers"
+	"user-management-service/src/helpers"
+	"user-management-service/src/middlewares"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+func newTestScimService(mt *mtest.T) *services.ScimService {
+	logger := log.New()
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	helpers.UserCollection = mt.Coll
+	helpers.RefreshTokenCollection = mt.Coll
+	helpers.SessionCollection = mt.Coll
+	tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+	hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+	userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+		newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+
+	return services.NewScimService(newTestUserValidator(logger), hasher, userService, tokenService,
+		configuration.ScimConfigurations{BaseUrl: "http://localhost/scim/v2/", MaxResults: 2}, logger)
+}
+
+func TestParseScimFilter_Should_Translate_Filters(t *testing.T) {
+	id := primitive.NewObjectID()
+	var created primitive.ObjectID
+	binary.BigEndian.PutUint32(created[0:4], uint32(time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC).Unix()))
+
+	tests := []struct {
+		filter   string
+		expected bson.D
+	}{
+		{`userName eq "a.b@gmail.com"`,
+			bson.D{{"Email", primitive.Regex{Pattern: `^a\.b@gmail\.com$`, Options: "i"}}}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName Eq "a@gmail.com"`,
+			bson.D{{"Email", primitive.Regex{Pattern: `^a@gmail\.com$`, Options: "i"}}}},
+		{`externalId eq "Ext-1"`, bson.D{{"ExternalId", "Ext-1"}}},
+		{`name.familyName sw "Ka"`, bson.D{{"FamilyName", primitive.Regex{Pattern: `^Ka`, Options: "i"}}}},
+		{`emails.value ew "@gmail.com"`, bson.D{{"Email", primitive.Regex{Pattern: `@gmail\.com$`, Options: "i"}}}},
+		{`displayName co "gu"`, bson.D{{"Name", primitive.Regex{Pattern: `gu`, Options: "i"}}}},
+		{`active eq false`, bson.D{{"Disabled", true}}},
+		{`active ne false`, bson.D{{"Disabled", bson.D{{"$ne", true}}}}},
+		{`id eq "` + id.Hex() + `"`, bson.D{{"_id", id}}},
+		{`externalId pr`, bson.D{{"ExternalId", bson.D{{"$exists", true}, {"$nin", bson.A{"", nil}}}}}},
+		{`meta.created ge "2021-05-01T10:00:00Z"`,
+			bson.D{{"_id", bson.D{{"$gte", created}}}}},
+		{`externalId eq "1" or externalId eq "2" and active eq true`,
+			bson.D{{"$or", bson.A{
+				bson.D{{"ExternalId", "1"}},
+				bson.D{{"$and", bson.A{
+					bson.D{{"ExternalId", "2"}},
+					bson.D{{"Disabled", bson.D{{"$ne", true}}}}}}}}}}},
+		{`not (externalId eq "1")`, bson.D{{"$nor", bson.A{bson.D{{"ExternalId", "1"}}}}}},
+	}
+
+	for _, test := range tests {
+		query, err := helpers.ParseScimFilter(test.filter)
+		assert.Nil(t, err, test.filter)
+		assert.Equal(t, test.expected, query, test.filter)
+	}
+}
+
+func TestParseScimFilter_Should_Reject_Invalid_Filters(t *testing.T) {
+	filters := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName eq a@gmail.com`,
+		`password eq "secret"`,
+		`userName xx "a"`,
+		`active eq "true"`,
+		`emails[type eq "work"]`,
+		`(userName eq "a"`,
+		`not userName eq "a"`,
+		`userName eq "a" and`,
+		`userName eq "unterminated`,
+	}
+
+	for _, filter := range filters {
+		_, err := helpers.ParseScimFilter(filter)
+		assert.Equal(t, helpers.ErrInvalidScimFilter, err, filter)
+	}
+}
+
+func TestScimGetUsers_Should_Page_Results(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("page", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 3}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Name", "Oguzhan Kalkar"},
+				{"Email", "oguzhan@gmail.com"},
+				{"ExternalId", "ext-1"},
+				{"Disabled", true},
+			}))
+
+		count := 5
+		result, message := scimService.GetUsers(c, models.ScimListModel{
+			Filter:     `userName sw "oguzhan"`,
+			StartIndex: 3,
+			Count:      &count,
+		})
+		assert.Nil(t, message)
+		assert.Equal(t, int64(3), result.TotalResults)
+		assert.Equal(t, 3, result.StartIndex)
+		assert.Equal(t, 1, result.ItemsPerPage)
+		assert.Equal(t, []string{models.ScimListResponseSchema}, result.Schemas)
+
+		resources := result.Resources.([]models.ScimUserModel)
+		assert.Equal(t, id.Hex(), resources[0].Id)
+		assert.Equal(t, "oguzhan@gmail.com", resources[0].UserName)
+		assert.Equal(t, "ext-1", resources[0].ExternalId)
+		assert.Equal(t, "Oguzhan Kalkar", resources[0].DisplayName)
+		assert.False(t, *resources[0].Active)
+		assert.Equal(t, "http://localhost/scim/v2/Users/"+id.Hex(), resources[0].Meta.Location)
+
+		find := mt.GetAllStartedEvents()[1].Command
+		assert.Equal(t, int64(2), find.Lookup("skip").AsInt64())
+		assert.Equal(t, int64(2), find.Lookup("limit").AsInt64())
+	})
+
+	mt.Run("count only", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 7}}))
+
+		count := 0
+		result, message := scimService.GetUsers(c, models.ScimListModel{Count: &count})
+		assert.Nil(t, message)
+		assert.Equal(t, int64(7), result.TotalResults)
+		assert.Equal(t, 0, result.ItemsPerPage)
+		assert.Equal(t, 1, len(mt.GetAllStartedEvents()))
+	})
+
+	mt.Run("invalid filter", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		_, message := scimService.GetUsers(c, models.ScimListModel{Filter: `password eq "x"`})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidScimFilterMessage, message.Error)
+	})
+}
+
+func TestScimCreateUser_Should_Provision_User(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("created", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse())
+
+		result, message := scimService.CreateUser(c, models.ScimUserModel{
+			Schemas:    []string{models.ScimUserSchema},
+			UserName:   "oguzhan@gmail.com",
+			ExternalId: "ext-1",
+			Name:       &models.ScimNameModel{GivenName: "Oguzhan", FamilyName: "Kalkar"},
+		})
+		assert.Nil(t, message)
+		assert.NotEmpty(t, result.Id)
+		assert.Equal(t, "Oguzhan Kalkar", result.DisplayName)
+		assert.True(t, *result.Active)
+
+		inserted := mt.GetAllStartedEvents()[2].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, "oguzhan@gmail.com", inserted.Lookup("Email").StringValue())
+		assert.Equal(t, "Oguzhan", inserted.Lookup("GivenName").StringValue())
+		assert.True(t, inserted.Lookup("EmailVerified").Boolean())
+		assert.Equal(t, "", inserted.Lookup("Password").StringValue())
+	})
+
+	mt.Run("duplicate externalId", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}))
+
+		_, message := scimService.CreateUser(c, models.ScimUserModel{
+			Schemas:    []string{models.ScimUserSchema},
+			UserName:   "oguzhan@gmail.com",
+			ExternalId: "ext-1",
+		})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusConflict, message.StatusCode)
+		assert.Equal(t, models.ExternalIdExistMessage, message.Error)
+	})
+
+	mt.Run("missing schema", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		_, message := scimService.CreateUser(c, models.ScimUserModel{UserName: "oguzhan@gmail.com"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+	})
+}
+
+func TestScimPatchUser_Should_Apply_Operations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+	userDocument := bson.D{
+		{"_id", id},
+		{"Name", "Oguzhan Kalkar"},
+		{"Email", "oguzhan@gmail.com"},
+		{"GivenName", "Oguzhan"},
+	}
+
+	mt.Run("deactivate", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}))
+
+		result, message := scimService.PatchUser(c, id.Hex(), models.ScimPatchModel{
+			Schemas: []string{models.ScimPatchOpSchema},
+			Operations: []models.ScimPatchOperationModel{
+				{Op: "Replace", Path: "active", Value: "False"},
+				{Op: "add", Value: map[string]interface{}{"name.familyName": "Kalkar", "externalId": "ext-1"}},
+			},
+		})
+		assert.Nil(t, message)
+		assert.False(t, *result.Active)
+		assert.Equal(t, "Kalkar", result.Name.FamilyName)
+		assert.Equal(t, "Oguzhan Kalkar", result.DisplayName)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, "aggregate", started[1].CommandName)
+		assert.Equal(t, "aggregate", started[2].CommandName)
+		update := started[3].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u").Document()
+		assert.True(t, update.Lookup("$set", "Disabled").Boolean())
+		assert.Equal(t, "ext-1", update.Lookup("$set", "ExternalId").StringValue())
+		assert.Equal(t, "update", started[4].CommandName)
+	})
+
+	mt.Run("change userName through emails", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		result, message := scimService.PatchUser(c, id.Hex(), models.ScimPatchModel{
+			Schemas: []string{models.ScimPatchOpSchema},
+			Operations: []models.ScimPatchOperationModel{
+				{Op: "replace", Path: `emails[type eq "work"].value`, Value: "new@gmail.com"},
+			},
+		})
+		assert.Nil(t, message)
+		assert.Equal(t, "new@gmail.com", result.UserName)
+		assert.Equal(t, "new@gmail.com", result.Emails[0].Value)
+	})
+
+	mt.Run("rejected password", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}))
+
+		_, message := scimService.PatchUser(c, id.Hex(), models.ScimPatchModel{
+			Schemas: []string{models.ScimPatchOpSchema},
+			Operations: []models.ScimPatchOperationModel{
+				{Op: "replace", Path: "active", Value: false},
+				{Op: "replace", Path: "password", Value: "short"},
+			},
+		})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.NotEqual(t, models.InvalidScimPatchMessage, message.Error)
+
+		for _, event := range mt.GetAllStartedEvents() {
+			assert.NotEqual(t, "update", event.CommandName)
+		}
+	})
+
+	mt.Run("deactivate last admin", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+				append(userDocument, bson.E{Key: "Roles", Value: bson.A{models.UserRole, models.AdminRole}})),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}))
+
+		_, message := scimService.PatchUser(c, id.Hex(), models.ScimPatchModel{
+			Schemas:    []string{models.ScimPatchOpSchema},
+			Operations: []models.ScimPatchOperationModel{{Op: "replace", Path: "active", Value: false}},
+		})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusConflict, message.StatusCode)
+		assert.Equal(t, models.LastAdminErrorMessage, message.Error)
+
+		started := mt.GetAllStartedEvents()
+		assert.Contains(t, started[len(started)-1].Command.String(), `"Disabled"`)
+		for _, event := range started {
+			assert.NotEqual(t, "update", event.CommandName)
+		}
+	})
+
+	mt.Run("invalid path", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+		_, message := scimService.PatchUser(c, id.Hex(), models.ScimPatchModel{
+			Schemas:    []string{models.ScimPatchOpSchema},
+			Operations: []models.ScimPatchOperationModel{{Op: "remove", Path: "userName"}},
+		})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidScimPatchMessage, message.Error)
+	})
+
+	mt.Run("unknown user", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		_, message := scimService.PatchUser(c, "not-an-id", models.ScimPatchModel{
+			Schemas:    []string{models.ScimPatchOpSchema},
+			Operations: []models.ScimPatchOperationModel{{Op: "remove", Path: "externalId"}},
+		})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusNotFound, message.StatusCode)
+	})
+}
+
+func TestScimDiscovery_Should_Describe_Users(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("discovery", func(mt *mtest.T) {
+		scimService := newTestScimService(mt)
+
+		config := scimService.GetServiceProviderConfig()
+		assert.True(t, config.Patch.Supported)
+		assert.False(t, config.Bulk.Supported)
+		assert.Equal(t, 2, config.Filter.MaxResults)
+
+		resourceType, message := scimService.GetResourceType(models.ScimUserResourceType)
+		assert.Nil(t, message)
+		assert.Equal(t, models.ScimUserSchema, resourceType.Schema)
+
+		schema, message := scimService.GetSchema(models.ScimUserSchema)
+		assert.Nil(t, message)
+		assert.Equal(t, "userName", schema.Attributes[0].Name)
+
+		_, message = scimService.GetSchema("urn:ietf:params:scim:schemas:core:2.0:Group")
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusNotFound, message.StatusCode)
+	})
+}
+
+func TestScimController_Should_Return_Scim_Errors(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("errors", func(mt *mtest.T) {
+		gin.SetMode(gin.TestMode)
+		scimController := controllers.NewScimController(newTestScimService(mt),
+			services.NewPermissionService(log.New()), log.New())
+
+		router := gin.New()
+		router.Use(func(context *gin.Context) {
+			if roles := context.GetHeader("X-Test-Roles"); roles != "" {
+				context.Set(middlewares.PrincipalKey, models.Principal{UserId: "admin", Roles: []string{roles}})
+			}
+		})
+		router.GET("/scim/v2/Users", scimController.GetUsers)
+
+		request := httptest.NewRequest(http.MethodGet, "/scim/v2/Users?filter=password+eq+%22x%22", nil)
+		request.Header.Set("X-Test-Roles", models.AdminRole)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), models.ScimContentType))
+
+		var scimError models.ScimErrorModel
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &scimError))
+		assert.Equal(t, []string{models.ScimErrorSchema}, scimError.Schemas)
+		assert.Equal(t, "400", scimError.Status)
+		assert.Equal(t, models.ScimInvalidFilterError, scimError.ScimType)
+
+		request = httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		request.Header.Set("X-Test-Roles", models.UserRole)
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &scimError))
+		assert.Equal(t, "403", scimError.Status)
+	})
+}
+
+func TestVerifyCredentials_Should_Reject_Disabled_User(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("disabled", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("Password1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", passwordHash},
+			{"Disabled", true},
+		}))
+
+		_, message := userService.VerifyCredentials(c, "oguzhan@gmail.com", "Password1")
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusForbidden, message.StatusCode)
+		assert.Equal(t, models.AccountDisabledMessage, message.Error)
+	})
+}
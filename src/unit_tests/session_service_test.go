@@ -0,0 +1,143 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+func newTestSessionService(mt *mtest.T) *services.SessionService {
+	logger := log.New()
+	tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+	helpers.RefreshTokenCollection = mt.Coll
+	helpers.SessionCollection = mt.Coll
+
+	return services.NewSessionService(newTestUserValidator(logger),
+		services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger), logger)
+}
+
+func TestDescribeDevice_Should_Recognise_Platforms(t *testing.T) {
+	assert.Equal(t, "iPhone", helpers.DescribeDevice(
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15"))
+	assert.Equal(t, "Android", helpers.DescribeDevice("Mozilla/5.0 (Linux; Android 14; Pixel 8)"))
+	assert.Equal(t, "macOS", helpers.DescribeDevice("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)"))
+	assert.Equal(t, "Other", helpers.DescribeDevice("curl/8.4.0"))
+	assert.Equal(t, "Unknown", helpers.DescribeDevice(""))
+}
+
+func TestGetSessions_Should_Mark_Current_Session(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("active sessions", func(mt *mtest.T) {
+		sessionService := newTestSessionService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		current := primitive.NewObjectID()
+		other := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{current, other}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+				bson.D{{"_id", current}, {"UserId", userId}, {"Device", "macOS"}},
+				bson.D{{"_id", other}, {"UserId", userId}, {"Device", "iPhone"}}))
+
+		result, message := sessionService.GetSessions(c, models.ListSessionsModel{UserId: userId.Hex(),
+			CurrentSessionId: current.Hex()})
+		assert.Nil(t, message)
+		assert.Equal(t, 2, len(result))
+		assert.True(t, result[0].Current)
+		assert.False(t, result[1].Current)
+		assert.Equal(t, "iPhone", result[1].Device)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, "distinct", started[0].CommandName)
+		assert.Equal(t, userId, started[0].Command.Lookup("query", "UserId").ObjectID())
+	})
+
+	mt.Run("no active sessions", func(mt *mtest.T) {
+		sessionService := newTestSessionService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}))
+
+		result, message := sessionService.GetSessions(c, models.ListSessionsModel{
+			UserId: primitive.NewObjectID().Hex()})
+		assert.Nil(t, message)
+		assert.Equal(t, 0, len(result))
+		assert.Equal(t, 1, len(mt.GetAllStartedEvents()))
+	})
+}
+
+func TestRevokeSession_Should_Revoke_Refresh_Tokens(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("revoke", func(mt *mtest.T) {
+		sessionService := newTestSessionService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		sessionId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", sessionId},
+				{"UserId", userId},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := sessionService.RevokeSession(c, models.RevokeSessionModel{UserId: userId.Hex(),
+			Id: sessionId.Hex()})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		update := started[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, sessionId, update.Lookup("q", "FamilyId").ObjectID())
+	})
+
+	mt.Run("other user's session", func(mt *mtest.T) {
+		sessionService := newTestSessionService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		message := sessionService.RevokeSession(c, models.RevokeSessionModel{UserId: primitive.NewObjectID().Hex(),
+			Id: primitive.NewObjectID().Hex()})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusNotFound, message.StatusCode)
+		assert.Equal(t, models.SessionNotFoundErrorMessage, message.Error)
+	})
+}
+
+func TestRevokeOtherSessions_Should_Keep_Current_Session(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("revoke others", func(mt *mtest.T) {
+		sessionService := newTestSessionService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		current := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3},
+			bson.E{Key: "nModified", Value: 3}))
+
+		message := sessionService.RevokeOtherSessions(c, models.RevokeSessionsModel{UserId: userId.Hex(),
+			CurrentSessionId: current.Hex()})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		update := started[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, userId, update.Lookup("q", "UserId").ObjectID())
+		assert.Equal(t, current, update.Lookup("q", "FamilyId", "$ne").ObjectID())
+	})
+}
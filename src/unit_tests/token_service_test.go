@@ -0,0 +1,209 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+)
+
+func TestRefresh_Should_Rotate_Token(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("rotate", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		familyId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", userId},
+				{"FamilyId", familyId},
+				{"TokenHash", helpers.HashOpaqueToken("old-token")},
+				{"ExpiresAt", time.Now().Add(time.Hour)},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", userId},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse())
+
+		result, message := tokenService.Refresh(c, "old-token", models.ClientInfoModel{ClientIp: "10.0.0.1"})
+		assert.Nil(t, message)
+		assert.Equal(t, userId.Hex(), result.UserId)
+		assert.NotEmpty(t, result.AccessToken)
+		assert.NotEqual(t, "old-token", result.RefreshToken)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, "findAndModify", started[0].CommandName)
+		session := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, familyId, session.Lookup("q", "_id").ObjectID())
+		assert.Equal(t, "10.0.0.1", session.Lookup("u", "$set", "ClientIp").StringValue())
+		assert.Equal(t, "insert", started[3].CommandName)
+		inserted := started[3].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, familyId, inserted.Lookup("FamilyId").ObjectID())
+		assert.Equal(t, helpers.HashOpaqueToken(result.RefreshToken), inserted.Lookup("TokenHash").StringValue())
+	})
+}
+
+func TestRefresh_Should_Restrict_Expired_Password(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("expired", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		familyId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", userId},
+				{"FamilyId", familyId},
+				{"TokenHash", helpers.HashOpaqueToken("old-token")},
+				{"ExpiresAt", time.Now().Add(time.Hour)},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", userId},
+				{"Email", "oguzhan@gmail.com"},
+				{"PasswordChangedAt", time.Now().Add(-100 * 24 * time.Hour)},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		result, message := tokenService.Refresh(c, "old-token", models.ClientInfoModel{ClientIp: "10.0.0.1"})
+		assert.Nil(t, message)
+		assert.True(t, result.PasswordChangeRequired)
+		assert.Equal(t, models.PasswordChangeScope, result.Scope)
+		assert.Empty(t, result.RefreshToken)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, 3, len(started))
+		revoked := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, familyId, revoked.Lookup("q", "FamilyId").ObjectID())
+	})
+}
+
+func TestRefresh_Should_Bind_Token_To_Client(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	clientId := primitive.NewObjectID()
+
+	mt.Run("oauth client", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		userId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", userId},
+				{"FamilyId", primitive.NewObjectID()},
+				{"ClientId", clientId},
+				{"TokenHash", helpers.HashOpaqueToken("old-token")},
+				{"ExpiresAt", time.Now().Add(time.Hour)},
+			}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"_id", userId}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse())
+
+		_, message := tokenService.Refresh(c, "old-token", models.ClientInfoModel{ClientId: clientId})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, clientId, started[0].Command.Lookup("query", "ClientId").ObjectID())
+		inserted := started[3].Command.Lookup("documents").Array().Index(0).Value().Document()
+		assert.Equal(t, clientId, inserted.Lookup("ClientId").ObjectID())
+	})
+
+	mt.Run("first party", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.RefreshTokenCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", primitive.NewObjectID()},
+				{"FamilyId", primitive.NewObjectID()},
+				{"ClientId", clientId},
+				{"TokenHash", helpers.HashOpaqueToken("old-token")},
+			}))
+
+		_, message := tokenService.Refresh(c, "old-token", models.ClientInfoModel{})
+		assert.NotNil(t, message)
+		assert.Equal(t, models.InvalidRefreshTokenMessage, message.Error)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, 2, len(started))
+		assert.Equal(t, "false", started[0].Command.Lookup("query", "ClientId", "$exists").String())
+	})
+}
+
+func TestRefresh_Should_Revoke_Family_On_Reuse(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("reuse", func(mt *mtest.T) {
+		logger := log.New()
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		familyId := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"UserId", primitive.NewObjectID()},
+				{"FamilyId", familyId},
+				{"TokenHash", helpers.HashOpaqueToken("old-token")},
+				{"UsedAt", time.Now().Add(-time.Minute)},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}))
+
+		_, message := tokenService.Refresh(c, "old-token", models.ClientInfoModel{})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+		assert.Equal(t, models.InvalidRefreshTokenMessage, message.Error)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, "update", started[2].CommandName)
+		update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, familyId, update.Lookup("q", "FamilyId").ObjectID())
+	})
+}
@@ -0,0 +1,246 @@
+package unit_tests
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-management-service/src/configuration"
+	"user-management-service/src/helpers"
+	"user-management-service/src/models"
+	"user-management-service/src/services"
+	"user-management-service/src/validators"
+)
+
+const testEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func newTestTwoFactorService(mt *mtest.T) (*services.TwoFactorService, *helpers.SecretEncryptor) {
+	logger := log.New()
+	helpers.UserCollection = mt.Coll
+	encryptor, _ := helpers.NewSecretEncryptor(testEncryptionKey)
+
+	return services.NewTwoFactorService(newTestUserValidator(logger), encryptor,
+		configuration.TwoFactorConfigurations{Issuer: "test", RecoveryCodeCount: 3}, logger), encryptor
+}
+
+func TestTotp_Should_Match_Rfc6238_Vectors(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	for unix, expected := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924"} {
+		code, err := helpers.GenerateTotpCode(secret, helpers.TotpStep(time.Unix(unix, 0)))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code)
+	}
+
+	step, valid := helpers.ValidateTotpCode(secret, "287082", time.Unix(89, 0))
+	assert.True(t, valid)
+	assert.Equal(t, int64(1), step)
+
+	_, valid = helpers.ValidateTotpCode(secret, "287082", time.Unix(150, 0))
+	assert.False(t, valid)
+
+	uri := helpers.TotpUri("user api", "oguzhan@gmail.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/user%20api:oguzhan@gmail.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+}
+
+func TestSecretEncryptor_Should_Round_Trip(t *testing.T) {
+	encryptor, err := helpers.NewSecretEncryptor(testEncryptionKey)
+	assert.Nil(t, err)
+
+	ciphertext, err := encryptor.Encrypt("secret")
+	assert.Nil(t, err)
+	assert.NotContains(t, ciphertext, "secret")
+
+	plaintext, err := encryptor.Decrypt(ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plaintext)
+
+	_, err = encryptor.Decrypt(ciphertext[:len(ciphertext)-4] + "AAA=")
+	assert.Equal(t, helpers.ErrInvalidCiphertext, err)
+}
+
+func TestConfirmEnrollment_Should_Enable_Two_Factor(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid code", func(mt *mtest.T) {
+		twoFactorService, encryptor := newTestTwoFactorService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		secret, _ := helpers.GenerateTotpSecret()
+		pending, _ := encryptor.Encrypt(secret)
+		code, _ := helpers.GenerateTotpCode(secret, helpers.TotpStep(time.Now()))
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+				{"TwoFactorPendingSecret", pending},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		result, message := twoFactorService.ConfirmEnrollment(c, models.TwoFactorCodeModel{Id: id.Hex(),
+			Code: code})
+		assert.Nil(t, message)
+		assert.Equal(t, 3, len(result.RecoveryCodes))
+
+		started := mt.GetAllStartedEvents()
+		update := started[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		set := update.Lookup("u", "$set").Document()
+		assert.True(t, set.Lookup("TwoFactorEnabled").Boolean())
+		assert.Equal(t, pending, set.Lookup("TwoFactorSecret").StringValue())
+
+		hashes, _ := set.Lookup("RecoveryCodes").Array().Values()
+		assert.Equal(t, helpers.HashRecoveryCode(result.RecoveryCodes[0]), hashes[0].StringValue())
+	})
+
+	mt.Run("wrong code", func(mt *mtest.T) {
+		twoFactorService, encryptor := newTestTwoFactorService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		secret, _ := helpers.GenerateTotpSecret()
+		pending, _ := encryptor.Encrypt(secret)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"TwoFactorPendingSecret", pending},
+		}))
+
+		_, message := twoFactorService.ConfirmEnrollment(c, models.TwoFactorCodeModel{
+			Id: primitive.NewObjectID().Hex(), Code: "000000"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidTwoFactorCodeMessage, message.Error)
+	})
+}
+
+func TestLogin_Should_Require_Two_Factor_Code(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	newAuthService := func(mt *mtest.T) (*services.AuthService, *helpers.SecretEncryptor, *helpers.PasswordHasher) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		twoFactorService, encryptor := newTestTwoFactorService(mt)
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		return services.NewAuthService(validators.NewAuthValidator(logger), userService, tokenService,
+			twoFactorService, newTestLoginAttemptService(mt), configuration.EmailVerificationConfigurations{},
+			logger), encryptor, hasher
+	}
+
+	mt.Run("missing code", func(mt *mtest.T) {
+		authService, encryptor, hasher := newAuthService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		secret, _ := encryptor.Encrypt("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", passwordHash},
+			{"TwoFactorEnabled", true},
+			{"TwoFactorSecret", secret},
+		}))
+
+		_, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com",
+			Password: "s3cret-Password"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+		assert.Equal(t, models.TwoFactorRequiredMessage, message.Error)
+	})
+
+	mt.Run("recovery code", func(mt *mtest.T) {
+		authService, encryptor, hasher := newAuthService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		secret, _ := encryptor.Encrypt("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"Email", "oguzhan@gmail.com"},
+				{"Password", passwordHash},
+				{"TwoFactorEnabled", true},
+				{"TwoFactorSecret", secret},
+				{"RecoveryCodes", bson.A{helpers.HashRecoveryCode("abcde-fghij")}},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		result, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com",
+			Password: "s3cret-Password", Code: "ABCDE-FGHIJ"})
+		assert.Nil(t, message)
+		assert.NotEmpty(t, result.AccessToken)
+
+		started := mt.GetAllStartedEvents()
+		update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, helpers.HashRecoveryCode("abcde-fghij"),
+			update.Lookup("u", "$pull", "RecoveryCodes").StringValue())
+	})
+
+	mt.Run("used recovery code", func(mt *mtest.T) {
+		authService, encryptor, hasher := newAuthService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		passwordHash, _ := hasher.Hash("s3cret-Password")
+		secret, _ := encryptor.Encrypt("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"Email", "oguzhan@gmail.com"},
+				{"Password", passwordHash},
+				{"TwoFactorEnabled", true},
+				{"TwoFactorSecret", secret},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+
+		_, message := authService.Login(c, models.LoginModel{Email: "oguzhan@gmail.com",
+			Password: "s3cret-Password", Code: "abcde-fghij"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusUnauthorized, message.StatusCode)
+		assert.Equal(t, models.InvalidTwoFactorCodeMessage, message.Error)
+	})
+}
+
+func TestResetTwoFactor_Should_Clear_Secrets(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("reset", func(mt *mtest.T) {
+		twoFactorService, _ := newTestTwoFactorService(mt)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1},
+			bson.E{Key: "nModified", Value: 1}))
+
+		message := twoFactorService.Reset(c, models.TwoFactorModel{Id: primitive.NewObjectID().Hex()})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		update := started[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.False(t, update.Lookup("u", "$set", "TwoFactorEnabled").Boolean())
+		_, err := update.LookupErr("u", "$unset", "TwoFactorSecret")
+		assert.Nil(t, err)
+		_, err = update.LookupErr("u", "$unset", "RecoveryCodes")
+		assert.Nil(t, err)
+	})
+}
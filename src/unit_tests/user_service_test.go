@@ -1,28 +1,33 @@
 package unit_tests
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+	"user-management-service/src/configuration"
 	"user-management-service/src/helpers"
 	"user-management-service/src/models"
 	"user-management-service/src/services"
-	"user-management-service/src/validators"
 )
 
 func TestValidateAddUserModel_Should_Not_Validate(t *testing.T) {
 	logger := log.New()
-	validator := validators.NewUserValidator(logger)
+	validator := newTestUserValidator(logger)
 
 	model := models.AddUserModel{
 		Name:     "",
 		Email:    "oguzhan@gmail.com",
-		Password: "123",
+		Password: "s3cret-Password",
 	}
 
 	result := validator.ValidateAddUserModel(model)
@@ -47,12 +52,12 @@ func TestValidateAddUserModel_Should_Not_Validate(t *testing.T) {
 
 func TestValidateAddUserModel_Should_Validate(t *testing.T) {
 	logger := log.New()
-	validator := validators.NewUserValidator(logger)
+	validator := newTestUserValidator(logger)
 
 	model := models.AddUserModel{
 		Name:     "oguzhan",
 		Email:    "oguzhan@gmail.com",
-		Password: "123",
+		Password: "s3cret-Password",
 	}
 
 	result := validator.ValidateAddUserModel(model)
@@ -62,12 +67,12 @@ func TestValidateAddUserModel_Should_Validate(t *testing.T) {
 
 func TestValidateUpdateUserModel_Should_Not_Validate(t *testing.T) {
 	logger := log.New()
-	validator := validators.NewUserValidator(logger)
+	validator := newTestUserValidator(logger)
 
 	model := models.UpdateUserModel{
 		Id:       "12",
 		Name:     "",
-		Password: "123",
+		Password: "s3cret-Password",
 	}
 
 	result := validator.ValidateUpdateUserModel(model)
@@ -96,13 +101,15 @@ func TestUpdateUser_Should_Return_UserNotFound(t *testing.T) {
 	mt.Run("user not found", func(mt *mtest.T) {
 		model := models.UpdateUserModel{
 			Name:     "oguzhan",
-			Password: "123",
+			Password: "s3cret-Password",
 			Id:       primitive.NewObjectID().Hex(),
 		}
 		logger := log.New()
-		validator := validators.NewUserValidator(logger)
+		validator := newTestUserValidator(logger)
+		hasher := helpers.NewPasswordHasher(configuration.PasswordHashingConfigurations{})
 		helpers.UserCollection = mt.Coll
-		userService := services.NewUserService(validator, logger)
+		userService := services.NewUserService(validator, hasher, nil, nil, newTestPasswordPolicyConfig(),
+			configuration.PaginationConfigurations{}, logger)
 		c, _ := gin.CreateTestContext(httptest.NewRecorder())
 		first := mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch)
 
@@ -114,9 +121,24 @@ func TestUpdateUser_Should_Return_UserNotFound(t *testing.T) {
 	})
 }
 
+func TestUpdateUser_Should_Refuse_Impersonation(t *testing.T) {
+	logger := log.New()
+	userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+		newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+	id := primitive.NewObjectID().Hex()
+	ctx := helpers.WithPrincipal(context.Background(), models.Principal{UserId: id,
+		ActorId: primitive.NewObjectID().Hex()})
+
+	_, message := userService.UpdateUser(ctx, models.UpdateUserModel{Id: id, Name: "oguzhan",
+		Password: "s3cret-Password"})
+	assert.NotNil(t, message)
+	assert.Equal(t, http.StatusForbidden, message.StatusCode)
+	assert.Equal(t, models.ImpersonationForbiddenMessage, message.Error)
+}
+
 func TestValidateDeleteUserModel_Should_Not_Validate(t *testing.T) {
 	logger := log.New()
-	validator := validators.NewUserValidator(logger)
+	validator := newTestUserValidator(logger)
 
 	model := models.DeleteUserModel{
 		Id: "",
@@ -131,7 +153,7 @@ func TestValidateDeleteUserModel_Should_Not_Validate(t *testing.T) {
 
 func TestValidateGetUserModel_Should_Not_Validate(t *testing.T) {
 	logger := log.New()
-	validator := validators.NewUserValidator(logger)
+	validator := newTestUserValidator(logger)
 
 	model := models.GetUserModel{
 		Id: "",
@@ -144,6 +166,341 @@ func TestValidateGetUserModel_Should_Not_Validate(t *testing.T) {
 	assert.Equal(t, models.BadRequestErrorMessage, result.Error)
 }
 
+func TestDeleteUser_Should_Soft_Delete(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("delete", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), nil, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Roles", bson.A{models.UserRole}},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := userService.DeleteUser(c, models.DeleteUserModel{Id: id.Hex()})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, "update", started[1].CommandName)
+		update := started[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, id, update.Lookup("q", "_id").ObjectID())
+		assert.False(t, update.Lookup("q", "DeletedAt", "$exists").Boolean())
+		assert.NotNil(t, update.Lookup("u", "$set", "DeletedAt").Value)
+	})
+
+	mt.Run("already deleted", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		message := userService.DeleteUser(c, models.DeleteUserModel{Id: primitive.NewObjectID().Hex()})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusNotFound, message.StatusCode)
+	})
+
+	mt.Run("last admin", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Roles", bson.A{models.UserRole, models.AdminRole}},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}))
+
+		message := userService.DeleteUser(c, models.DeleteUserModel{Id: id.Hex()})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusConflict, message.StatusCode)
+		assert.Equal(t, models.LastAdminErrorMessage, message.Error)
+		assert.Equal(t, 2, len(mt.GetAllStartedEvents()))
+	})
+}
+
+func TestBootstrapAdmin_Should_Count_Deleted_Admins(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("deleted admin", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}))
+
+		err := userService.BootstrapAdmin(c, configuration.BootstrapConfigurations{AdminEmail: "admin@gmail.com"})
+		assert.Nil(t, err)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, 1, len(started))
+		assert.NotContains(t, started[0].Command.String(), "DeletedAt")
+	})
+}
+
+func TestEnsureTokenIndexes_Should_Index_Hashes_And_Expiry(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("indexes", func(mt *mtest.T) {
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		helpers.PasswordResetTokenCollection = mt.Coll
+		helpers.MagicLinkTokenCollection = mt.Coll
+		helpers.ApiKeyCollection = mt.Coll
+		helpers.AuthorizationCodeCollection = mt.Coll
+
+		for i := 0; i < 6; i++ {
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+		}
+
+		err := helpers.EnsureTokenIndexes(context.Background(), 15*time.Minute)
+		assert.Nil(t, err)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, 6, len(started))
+
+		refreshTokenIndexes := started[0].Command.Lookup("indexes").String()
+		assert.Contains(t, refreshTokenIndexes, `"TokenHash"`)
+		assert.Contains(t, refreshTokenIndexes, `"unique": true`)
+		assert.Contains(t, refreshTokenIndexes, `"expireAfterSeconds": {"$numberInt":"0"}`)
+
+		magicLinkIndexes := started[3].Command.Lookup("indexes").String()
+		assert.Contains(t, magicLinkIndexes, `"sparse": true`)
+		assert.Contains(t, magicLinkIndexes, `"expireAfterSeconds": {"$numberInt":"900"}`)
+
+		assert.Contains(t, started[4].Command.Lookup("indexes").String(), `"Prefix"`)
+		assert.Contains(t, started[5].Command.Lookup("indexes").String(), `"CodeHash"`)
+	})
+}
+
+func TestBootstrapAdmin_Should_Not_Promote_Existing_User(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("email taken", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}))
+
+		err := userService.BootstrapAdmin(c, configuration.BootstrapConfigurations{
+			AdminEmail: "admin@gmail.com", AdminPassword: "Adm1n-Password"})
+		assert.NotNil(t, err)
+
+		for _, event := range mt.GetAllStartedEvents() {
+			assert.NotEqual(t, "update", event.CommandName)
+			assert.NotEqual(t, "insert", event.CommandName)
+		}
+	})
+}
+
+func TestMigrateLegacyPasswords_Should_Hash_Plaintext(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("plaintext", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+				bson.D{{"_id", primitive.NewObjectID()}, {"Password", "123"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		err := userService.MigrateLegacyPasswords(c)
+		assert.Nil(t, err)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, 2, len(started))
+
+		passwordHash := started[1].Command.Lookup("updates", "0", "u", "$set", "Password").StringValue()
+		match, _, err := hasher.Verify("123", passwordHash)
+		assert.Nil(t, err)
+		assert.True(t, match)
+	})
+}
+
+func TestRestoreUser_Should_Undo_Delete(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+	deletedUser := bson.D{{"_id", id}, {"Email", "oguzhan@gmail.com"}, {"DeletedAt", time.Now().UTC()}}
+
+	mt.Run("restore", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, deletedUser),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{"_id", id}, {"Email", "oguzhan@gmail.com"}}}))
+
+		result, message := userService.RestoreUser(c, models.RestoreUserModel{Id: id.Hex()})
+		assert.Nil(t, message)
+		assert.Equal(t, id, result.Id)
+		assert.Nil(t, result.DeletedAt)
+
+		started := mt.GetAllStartedEvents()
+		assert.True(t, started[0].Command.Lookup("filter", "DeletedAt", "$exists").Boolean())
+		assert.Equal(t, "oguzhan@gmail.com", started[1].Command.Lookup("pipeline").Array().Index(0).Value().
+			Document().Lookup("$match", "Email").StringValue())
+		assert.NotNil(t, started[2].Command.Lookup("update", "$unset", "DeletedAt").Value)
+	})
+
+	mt.Run("email taken", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, deletedUser),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}))
+
+		_, message := userService.RestoreUser(c, models.RestoreUserModel{Id: id.Hex()})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusForbidden, message.StatusCode)
+		assert.Equal(t, models.EmailExistMessage, message.Error)
+	})
+
+	mt.Run("not deleted", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		_, message := userService.RestoreUser(c, models.RestoreUserModel{Id: id.Hex()})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusNotFound, message.StatusCode)
+	})
+}
+
+func TestListDeletedUsers_Should_Return_Deleted_Users(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("list", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()}, {"Email", "oguzhan@gmail.com"}, {"DeletedAt", deletedAt}}))
+
+		result, message := userService.ListDeletedUsers(c, models.ListDeletedUsersModel{})
+		assert.Nil(t, message)
+		assert.Equal(t, int64(1), *result.Total)
+		assert.Equal(t, 1, len(result.Items))
+		assert.Equal(t, deletedAt, *result.Items[0].DeletedAt)
+
+		find := mt.GetAllStartedEvents()[1].Command
+		assert.True(t, find.Lookup("filter", "DeletedAt", "$exists").Boolean())
+		assert.Equal(t, int32(-1), find.Lookup("sort", "DeletedAt").Int32())
+	})
+}
+
+func TestPurgeDeletedUsers_Should_Remove_Expired_Users(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("purge", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		helpers.ApiKeyCollection = mt.Coll
+		helpers.PasswordResetTokenCollection = mt.Coll
+		helpers.MagicLinkTokenCollection = mt.Coll
+		helpers.AuthorizationCodeCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			bson.D{{"_id", ids[0]}}, bson.D{{"_id", ids[1]}}))
+		for i := 0; i < 7; i++ {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}))
+		}
+
+		purged, err := userService.PurgeDeletedUsers(c, 24*time.Hour)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), purged)
+
+		started := mt.GetAllStartedEvents()
+		assert.Equal(t, 8, len(started))
+		deletedBefore := started[0].Command.Lookup("filter", "DeletedAt", "$lte").Time()
+		assert.WithinDuration(t, time.Now().Add(-24*time.Hour), deletedBefore, time.Minute)
+
+		users := started[7].Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q")
+		in, _ := users.Document().Lookup("_id", "$in").Array().Values()
+		assert.Equal(t, ids[1], in[1].ObjectID())
+		assert.NotNil(t, users.Document().Lookup("DeletedAt", "$lte").Value)
+	})
+
+	mt.Run("nothing to purge", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		purged, err := userService.PurgeDeletedUsers(c, 24*time.Hour)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), purged)
+		assert.Equal(t, 1, len(mt.GetAllStartedEvents()))
+	})
+}
+
 func TestGetUser_Should_Return_UserNotFound(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 	defer mt.Close()
@@ -153,9 +510,11 @@ func TestGetUser_Should_Return_UserNotFound(t *testing.T) {
 			Id: primitive.NewObjectID().Hex(),
 		}
 		logger := log.New()
-		validator := validators.NewUserValidator(logger)
+		validator := newTestUserValidator(logger)
+		hasher := helpers.NewPasswordHasher(configuration.PasswordHashingConfigurations{})
 		helpers.UserCollection = mt.Coll
-		userService := services.NewUserService(validator, logger)
+		userService := services.NewUserService(validator, hasher, nil, nil, newTestPasswordPolicyConfig(),
+			configuration.PaginationConfigurations{}, logger)
 		c, _ := gin.CreateTestContext(httptest.NewRecorder())
 		first := mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch)
 
@@ -166,3 +525,763 @@ func TestGetUser_Should_Return_UserNotFound(t *testing.T) {
 		assert.Equal(t, models.UserNotFoundErrorMessage, message.Error)
 	})
 }
+
+func TestUpdateUser_Should_Reject_Reused_Password(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("reused password", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		currentHash, _ := hasher.Hash("Current-Pass1")
+		previousHash, _ := hasher.Hash("Previous-Pass1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", currentHash},
+			{"PasswordHistory", bson.A{previousHash}},
+		}))
+
+		_, message := userService.UpdateUser(c, models.UpdateUserModel{Id: primitive.NewObjectID().Hex(),
+			Name: "oguzhan", Password: "Previous-Pass1"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.PasswordReusedErrorMessage, message.Error)
+	})
+
+	mt.Run("new password", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		currentHash, _ := hasher.Hash("Current-Pass1")
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", primitive.NewObjectID()},
+				{"Email", "oguzhan@gmail.com"},
+				{"Password", currentHash},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		_, message := userService.UpdateUser(c, models.UpdateUserModel{Id: primitive.NewObjectID().Hex(),
+			Name: "oguzhan", Password: "Brand-New-Pass1"})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		update := started[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		history := update.Lookup("u", "$push", "PasswordHistory").Document()
+		each, _ := history.Lookup("$each").Array().Values()
+		assert.Equal(t, currentHash, each[0].StringValue())
+		assert.Equal(t, int32(-3), history.Lookup("$slice").Int32())
+	})
+}
+
+func TestValidatePatchUserModel_Should_Not_Validate(t *testing.T) {
+	validator := newTestUserValidator(log.New())
+	id := primitive.NewObjectID().Hex()
+
+	for _, model := range []models.PatchUserModel{
+		{MergePatch: map[string]interface{}{}},
+		{Id: primitive.NilObjectID.Hex(), MergePatch: map[string]interface{}{}},
+		{Id: id},
+		{Id: id, MergePatch: map[string]interface{}{}, Operations: []models.JsonPatchOperationModel{}},
+	} {
+		result := validator.ValidatePatchUserModel(model)
+		assert.NotNil(t, result)
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	}
+
+	assert.Nil(t, validator.ValidatePatchUserModel(models.PatchUserModel{Id: id,
+		Operations: []models.JsonPatchOperationModel{}}))
+}
+
+func TestPatchUser_Should_Update_Only_Given_Fields(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+	userDocument := bson.D{
+		{"_id", id},
+		{"Name", "Oguzhan"},
+		{"Email", "oguzhan@gmail.com"},
+		{"Password", "$argon2id$hash"},
+	}
+
+	mt.Run("merge patch", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		result, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(),
+			MergePatch: map[string]interface{}{"id": id.Hex(), "name": "Oguz"}})
+		assert.Nil(t, message)
+		assert.Equal(t, "Oguz", result.Name)
+		assert.Equal(t, "oguzhan@gmail.com", result.Email)
+
+		set := mt.GetAllStartedEvents()[1].Command.Lookup("updates").Array().Index(0).Value().Document().
+			Lookup("u", "$set").Document()
+		elements, _ := set.Elements()
+		assert.Equal(t, 1, len(elements))
+		assert.Equal(t, "Oguz", set.Lookup("Name").StringValue())
+	})
+
+	mt.Run("invalid merge patch", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		for _, patch := range []map[string]interface{}{
+			{"name": nil},
+			{"name": 5},
+			{"roles": []interface{}{"admin"}},
+			{"email": "other@gmail.com"},
+			{"name": "  "},
+		} {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+			_, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(), MergePatch: patch})
+			assert.NotNil(t, message)
+			assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		}
+	})
+
+	mt.Run("json patch", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		result, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(),
+			Operations: []models.JsonPatchOperationModel{
+				{Op: "test", Path: "/email", Value: "oguzhan@gmail.com"},
+				{Op: "replace", Path: "/password", Value: "Brand-New-Pass1"},
+			}})
+		assert.Nil(t, message)
+		assert.Equal(t, "Oguzhan", result.Name)
+
+		update := mt.GetAllStartedEvents()[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Nil(t, update.Lookup("u", "$set", "Name").Value)
+		assert.False(t, update.Lookup("u", "$set", "MustChangePassword").Boolean())
+		passwordHash := update.Lookup("u", "$set", "Password").StringValue()
+		match, _, _ := hasher.Verify("Brand-New-Pass1", passwordHash)
+		assert.True(t, match)
+	})
+
+	mt.Run("password while impersonating", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		ctx := helpers.WithPrincipal(context.Background(), models.Principal{UserId: id.Hex(),
+			ActorId: primitive.NewObjectID().Hex()})
+
+		for _, model := range []models.PatchUserModel{
+			{Id: id.Hex(), MergePatch: map[string]interface{}{"password": "Brand-New-Pass1"}},
+			{Id: id.Hex(), Operations: []models.JsonPatchOperationModel{
+				{Op: "replace", Path: "/password", Value: "Brand-New-Pass1"}}},
+		} {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+			_, message := userService.PatchUser(ctx, model)
+			assert.NotNil(t, message)
+			assert.Equal(t, http.StatusForbidden, message.StatusCode)
+			assert.Equal(t, models.ImpersonationForbiddenMessage, message.Error)
+		}
+
+		for _, event := range mt.GetAllStartedEvents() {
+			assert.NotEqual(t, "update", event.CommandName)
+		}
+	})
+
+	mt.Run("invalid json patch", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		for _, operation := range []models.JsonPatchOperationModel{
+			{Op: "remove", Path: "/name"},
+			{Op: "replace", Path: "/email", Value: "other@gmail.com"},
+			{Op: "copy", From: "/name", Path: "/password"},
+			{Op: "test", Path: "/password", Value: "secret"},
+		} {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+			_, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(),
+				Operations: []models.JsonPatchOperationModel{operation}})
+			assert.NotNil(t, message)
+			assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+			assert.Equal(t, models.InvalidPatchMessage, message.Error)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+		_, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(),
+			Operations: []models.JsonPatchOperationModel{
+				{Op: "replace", Path: "/name", Value: "Oguz"},
+				{Op: "test", Path: "/name", Value: "Oguzhan"},
+			}})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusConflict, message.StatusCode)
+		assert.Equal(t, models.PatchTestFailedMessage, message.Error)
+	})
+}
+
+func TestPatchUser_Should_Check_Version(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+	userDocument := bson.D{
+		{"_id", id},
+		{"Name", "Oguzhan"},
+		{"Email", "oguzhan@gmail.com"},
+		{"Password", "$argon2id$hash"},
+		{"Version", int64(3)},
+	}
+	version := int64(3)
+	staleVersion := int64(2)
+
+	mt.Run("matching version", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		result, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(), Version: &version,
+			MergePatch: map[string]interface{}{"name": "Oguz"}})
+		assert.Nil(t, message)
+		assert.Equal(t, int64(4), result.Version)
+
+		update := mt.GetAllStartedEvents()[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, int64(3), update.Lookup("q", "Version").Int64())
+		assert.Equal(t, int32(1), update.Lookup("u", "$inc", "Version").Int32())
+	})
+
+	mt.Run("stale version", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument))
+
+		_, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(), Version: &staleVersion,
+			MergePatch: map[string]interface{}{"name": "Oguz"}})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusPreconditionFailed, message.StatusCode)
+		assert.Equal(t, models.PreconditionFailedMessage, message.Error)
+		assert.Equal(t, 1, len(mt.GetAllStartedEvents()))
+	})
+
+	mt.Run("concurrent write", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, userDocument),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+
+		_, message := userService.PatchUser(c, models.PatchUserModel{Id: id.Hex(), Version: &version,
+			MergePatch: map[string]interface{}{"name": "Oguz"}})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusPreconditionFailed, message.StatusCode)
+	})
+}
+
+func TestDeleteUser_Should_Match_Unversioned_User_At_Version_Zero(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("version zero", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), nil, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		version := int64(0)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Email", "oguzhan@gmail.com"},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := userService.DeleteUser(c, models.DeleteUserModel{Id: id.Hex(), Version: &version})
+		assert.Nil(t, message)
+
+		update := mt.GetAllStartedEvents()[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		versions, _ := update.Lookup("q", "Version", "$in").Array().Values()
+		assert.Equal(t, 2, len(versions))
+		assert.Equal(t, int32(1), update.Lookup("u", "$inc", "Version").Int32())
+	})
+}
+
+func TestSetPassword_Should_Reject_Current_Password(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("current password", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		currentHash, _ := hasher.Hash("Current-Pass1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", currentHash},
+		}))
+
+		message := userService.SetPassword(c, primitive.NewObjectID(), "Current-Pass1")
+		assert.NotNil(t, message)
+		assert.Equal(t, models.PasswordReusedErrorMessage, message.Error)
+	})
+}
+
+func TestChangePassword_Should_Reject_Wrong_Current_Password(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("wrong current password", func(mt *mtest.T) {
+		logger := log.New()
+		hasher := newTestHasher(helpers.Argon2idAlgorithm, 1, 4)
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), hasher, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		currentHash, _ := hasher.Hash("Current-Pass1")
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+			{"_id", primitive.NewObjectID()},
+			{"Email", "oguzhan@gmail.com"},
+			{"Password", currentHash},
+		}))
+
+		message := userService.ChangePassword(c, models.ChangePasswordModel{Id: primitive.NewObjectID().Hex(),
+			CurrentPassword: "Wrong-Pass1", NewPassword: "Brand-New-Pass1"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+		assert.Equal(t, models.InvalidCurrentPasswordMessage, message.Error)
+	})
+}
+
+func TestExpirePassword_Should_Require_Password_Change(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("expire", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		helpers.RefreshTokenCollection = mt.Coll
+		helpers.SessionCollection = mt.Coll
+		tokenHelper, _ := helpers.NewTokenHelper(newTestJwtConfig())
+		tokenService := services.NewTokenService(tokenHelper, newTestJwtConfig(), newTestPasswordPolicyConfig(), logger)
+		userService := services.NewUserService(newTestUserValidator(logger), nil, tokenService, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		message := userService.ExpirePassword(c, models.ExpirePasswordModel{Id: primitive.NewObjectID().Hex()})
+		assert.Nil(t, message)
+
+		started := mt.GetAllStartedEvents()
+		update := started[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.True(t, update.Lookup("u", "$set", "MustChangePassword").Boolean())
+		assert.Equal(t, 2, len(started))
+	})
+
+	mt.Run("expiry date", func(mt *mtest.T) {
+		userService := services.NewUserService(newTestUserValidator(log.New()), nil, nil, nil,
+			newTestPasswordPolicyConfig(),
+			configuration.PaginationConfigurations{}, log.New())
+
+		changedAt := time.Now().UTC().Add(-time.Hour)
+		expiresAt := userService.PasswordExpiresAt(models.UserEntity{PasswordChangedAt: &changedAt})
+		assert.Equal(t, changedAt.Add(90*24*time.Hour), *expiresAt)
+		assert.False(t, userService.PasswordExpired(models.UserEntity{PasswordChangedAt: &changedAt}))
+		assert.True(t, userService.PasswordExpired(models.UserEntity{PasswordChangedAt: &changedAt,
+			MustChangePassword: true}))
+		assert.Nil(t, userService.PasswordExpiresAt(models.UserEntity{}))
+	})
+}
+
+func TestValidateListUsersModel_Should_Not_Validate(t *testing.T) {
+	validator := newTestUserValidator(log.New())
+
+	for _, model := range []models.ListUsersModel{
+		{Page: -1},
+		{Limit: -5},
+		{Sort: "password"},
+		{Sort: "--name"},
+		{Status: "deleted"},
+		{EmailDomain: "a@gmail.com"},
+		{Pagination: "keyset"},
+		{Pagination: models.OffsetPagination, Cursor: "abc"},
+		{Page: 2, Cursor: "abc"},
+	} {
+		result := validator.ValidateListUsersModel(model)
+		assert.NotNil(t, result)
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	}
+
+	assert.Nil(t, validator.ValidateListUsersModel(models.ListUsersModel{Sort: "-createdAt", Status: "disabled"}))
+}
+
+func TestGetAllUsers_Should_Page_And_Filter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("page", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{DefaultLimit: 2, MaxLimit: 3},
+			logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 7}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Name", "Oguzhan"},
+				{"Email", "oguzhan@gmail.com"},
+				{"Password", "secret"},
+			}))
+
+		result, message := userService.GetAllUsers(c, models.ListUsersModel{
+			Page:        2,
+			Limit:       50,
+			Sort:        "-name",
+			Name:        "Og",
+			EmailDomain: "gmail.com",
+			Status:      models.DisabledUserStatus,
+		})
+		assert.Nil(t, message)
+		assert.NotNil(t, result.Total)
+		assert.Equal(t, int64(7), *result.Total)
+		assert.Equal(t, 2, result.Page)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 1, len(result.Items))
+		assert.Equal(t, id, result.Items[0].Id)
+		assert.Equal(t, id.Timestamp().UTC(), result.Items[0].CreatedAt)
+
+		find := mt.GetAllStartedEvents()[1].Command
+		assert.Equal(t, int64(3), find.Lookup("skip").AsInt64())
+		assert.Equal(t, int64(3), find.Lookup("limit").AsInt64())
+		assert.Equal(t, "Name", find.Lookup("sort").Document().Index(0).Key())
+		assert.Equal(t, int32(-1), find.Lookup("sort", "Name").Int32())
+
+		filter := find.Lookup("filter").Document()
+		pattern, options := filter.Lookup("Name").Regex()
+		assert.Equal(t, "^Og", pattern)
+		assert.Equal(t, "i", options)
+		pattern, _ = filter.Lookup("Email").Regex()
+		assert.Equal(t, `@gmail\.com$`, pattern)
+		assert.True(t, filter.Lookup("Disabled").Boolean())
+	})
+}
+
+func TestGetAllUsers_Should_Page_By_Cursor(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	user := func(id primitive.ObjectID, name string) bson.D {
+		return bson.D{{"_id", id}, {"Name", name}, {"Email", strings.ToLower(name) + "@gmail.com"}}
+	}
+
+	mt.Run("cursor", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{CursorSecret: "secret"}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			user(ids[0], "Ali"), user(ids[1], "Ayse"), user(ids[2], "Aysel")))
+
+		first, message := userService.GetAllUsers(c, models.ListUsersModel{
+			Limit: 2, Sort: "name", Name: "A", Pagination: models.CursorPagination})
+		assert.Nil(t, message)
+		assert.Nil(t, first.Total)
+		assert.Equal(t, 2, len(first.Items))
+		assert.NotEmpty(t, first.NextCursor)
+		assert.Empty(t, first.PrevCursor)
+
+		find := mt.GetAllStartedEvents()[0].Command
+		assert.Equal(t, int64(3), find.Lookup("limit").AsInt64())
+		assert.Nil(t, find.Lookup("skip").Value)
+
+		// The cursor keeps the filters of the first page.
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, user(ids[2], "Aysel")))
+
+		second, message := userService.GetAllUsers(c, models.ListUsersModel{
+			Limit: 2, Name: "Z", Cursor: first.NextCursor})
+		assert.Nil(t, message)
+		assert.Equal(t, 1, len(second.Items))
+		assert.Empty(t, second.NextCursor)
+		assert.NotEmpty(t, second.PrevCursor)
+
+		filter := mt.GetAllStartedEvents()[1].Command.Lookup("filter").Document()
+		pattern, _ := filter.Lookup("Name").Regex()
+		assert.Equal(t, "^A", pattern)
+		after := filter.Lookup("$or").Array()
+		assert.Equal(t, "Ayse", after.Index(0).Value().Document().Lookup("Name", "$gt").StringValue())
+		assert.Equal(t, "Ayse", after.Index(1).Value().Document().Lookup("Name").StringValue())
+		assert.Equal(t, ids[1], after.Index(1).Value().Document().Lookup("_id", "$gt").ObjectID())
+
+		// Going back reads in reverse order and flips the page.
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			user(ids[1], "Ayse"), user(ids[0], "Ali")))
+
+		previous, message := userService.GetAllUsers(c, models.ListUsersModel{Limit: 2, Cursor: second.PrevCursor})
+		assert.Nil(t, message)
+		assert.Equal(t, []primitive.ObjectID{ids[0], ids[1]},
+			[]primitive.ObjectID{previous.Items[0].Id, previous.Items[1].Id})
+		assert.NotEmpty(t, previous.NextCursor)
+		assert.Empty(t, previous.PrevCursor)
+
+		find = mt.GetAllStartedEvents()[2].Command
+		assert.Equal(t, int32(-1), find.Lookup("sort", "Name").Int32())
+		after = find.Lookup("filter", "$or").Array()
+		assert.Equal(t, "Aysel", after.Index(0).Value().Document().Lookup("Name", "$lt").StringValue())
+	})
+
+	mt.Run("invalid cursor", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{CursorSecret: "secret"}, logger)
+		otherService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{CursorSecret: "other"}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch,
+			user(primitive.NewObjectID(), "Ali"), user(primitive.NewObjectID(), "Ayse")))
+
+		first, _ := userService.GetAllUsers(c, models.ListUsersModel{Limit: 1, Pagination: models.CursorPagination})
+		assert.NotEmpty(t, first.NextCursor)
+
+		for _, cursor := range []string{"abc", first.NextCursor + "x", "e30." + first.NextCursor[4:]} {
+			_, message := userService.GetAllUsers(c, models.ListUsersModel{Cursor: cursor})
+			assert.NotNil(t, message)
+			assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+			assert.Equal(t, models.InvalidCursorMessage, message.Error)
+		}
+
+		_, message := otherService.GetAllUsers(c, models.ListUsersModel{Cursor: first.NextCursor})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusBadRequest, message.StatusCode)
+	})
+}
+
+func TestCursorLinks_Should_Replace_Cursor(t *testing.T) {
+	requestUrl, _ := url.Parse("/users?status=active&cursor=abc&page=2")
+
+	links := helpers.CursorLinks(requestUrl, 10, "prev", "next")
+	assert.Equal(t, "/users?cursor=abc&limit=10&status=active", links.Self)
+	assert.Equal(t, "/users?limit=10&pagination=cursor&status=active", links.First)
+	assert.Equal(t, "/users?cursor=prev&limit=10&status=active", links.Prev)
+	assert.Equal(t, "/users?cursor=next&limit=10&status=active", links.Next)
+	assert.Empty(t, links.Last)
+
+	links = helpers.CursorLinks(requestUrl, 10, "", "")
+	assert.Empty(t, links.Prev)
+	assert.Empty(t, links.Next)
+}
+
+func TestValidateSearchUsersModel_Should_Not_Validate(t *testing.T) {
+	validator := newTestUserValidator(log.New())
+
+	for _, model := range []models.SearchUsersModel{
+		{},
+		{Query: "   "},
+		{Query: strings.Repeat("a", 201)},
+		{Query: "ali", Page: -1},
+		{Query: "ali", Limit: -1},
+	} {
+		result := validator.ValidateSearchUsersModel(model)
+		assert.NotNil(t, result)
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	}
+
+	assert.Nil(t, validator.ValidateSearchUsersModel(models.SearchUsersModel{Query: "ali"}))
+}
+
+func TestSearchUsers_Should_Rank_And_Highlight(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("text index", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{"_id", id},
+				{"Name", "Ali Veli"},
+				{"Email", "veli@gmail.com"},
+				{"Score", 1.5},
+			}))
+
+		result, message := userService.SearchUsers(c, models.SearchUsersModel{Query: " ali ", Page: 2})
+		assert.Nil(t, message)
+		assert.Equal(t, int64(1), result.Total)
+		assert.Equal(t, 2, result.Page)
+		assert.Equal(t, 20, result.Limit)
+		assert.Equal(t, 1, len(result.Items))
+		assert.Equal(t, id, result.Items[0].Id)
+		assert.Equal(t, 1.5, result.Items[0].Score)
+		assert.Equal(t, map[string]string{"name": "<em>Ali</em> Veli"}, result.Items[0].Highlights)
+
+		find := mt.GetAllStartedEvents()[1].Command
+		assert.Equal(t, "ali", find.Lookup("filter", "$text", "$search").StringValue())
+		assert.Equal(t, "textScore", find.Lookup("sort", "Score", "$meta").StringValue())
+		assert.Equal(t, "textScore", find.Lookup("projection", "Score", "$meta").StringValue())
+		assert.Equal(t, int64(20), find.Lookup("skip").AsInt64())
+	})
+
+	mt.Run("prefix fallback", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		for _, textResponse := range []bson.D{
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 0}}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 27, Name: "IndexNotFound",
+				Message: "text index required for $text query"}),
+		} {
+			mt.ClearEvents()
+			mt.AddMockResponses(
+				textResponse,
+				mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{"n", 1}}),
+				mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+					{"_id", primitive.NewObjectID()},
+					{"Name", "Oguzhan"},
+					{"Email", "oguzhan@gmail.com"},
+				}))
+
+			result, message := userService.SearchUsers(c, models.SearchUsersModel{Query: "oguz"})
+			assert.Nil(t, message)
+			assert.Equal(t, int64(1), result.Total)
+			assert.Equal(t, map[string]string{"name": "<em>Oguz</em>han", "email": "<em>oguz</em>han@gmail.com"},
+				result.Items[0].Highlights)
+
+			find := mt.GetAllStartedEvents()[2].Command
+			pattern, options := find.Lookup("filter", "$or").Array().Index(0).Value().Document().
+				Lookup("Name").Regex()
+			assert.Equal(t, "^oguz", pattern)
+			assert.Equal(t, "i", options)
+			assert.Equal(t, "Name", find.Lookup("sort").Document().Index(0).Key())
+		}
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		logger := log.New()
+		helpers.UserCollection = mt.Coll
+		userService := services.NewUserService(newTestUserValidator(logger), nil, nil, nil,
+			newTestPasswordPolicyConfig(), configuration.PaginationConfigurations{}, logger)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad"}))
+
+		_, message := userService.SearchUsers(c, models.SearchUsersModel{Query: "ali"})
+		assert.NotNil(t, message)
+		assert.Equal(t, http.StatusInternalServerError, message.StatusCode)
+	})
+}
+
+func TestHighlight_Should_Escape_And_Mark_Terms(t *testing.T) {
+	terms := helpers.SearchTerms(`"ali veli" -deli al`)
+	assert.Equal(t, []string{"ali", "veli", "al"}, terms)
+
+	highlighted, ok := helpers.Highlight("<b>Ali</b> Velioglu", terms)
+	assert.True(t, ok)
+	assert.Equal(t, "&lt;b&gt;<em>Ali</em>&lt;/b&gt; <em>Veli</em>oglu", highlighted)
+
+	highlighted, ok = helpers.Highlight("Mehmet & Co", terms)
+	assert.False(t, ok)
+	assert.Equal(t, "Mehmet &amp; Co", highlighted)
+}
+
+func TestPageLinks_Should_Keep_Query(t *testing.T) {
+	requestUrl, _ := url.Parse("/users?status=active&page=2&limit=10")
+
+	links := helpers.PageLinks(requestUrl, 2, 10, 25)
+	assert.Equal(t, "/users?limit=10&page=2&status=active", links.Self)
+	assert.Equal(t, "/users?limit=10&page=1&status=active", links.First)
+	assert.Equal(t, "/users?limit=10&page=1&status=active", links.Prev)
+	assert.Equal(t, "/users?limit=10&page=3&status=active", links.Next)
+	assert.Equal(t, "/users?limit=10&page=3&status=active", links.Last)
+
+	links = helpers.PageLinks(requestUrl, 1, 10, 0)
+	assert.Empty(t, links.Prev)
+	assert.Empty(t, links.Next)
+	assert.Equal(t, links.First, links.Last)
+}
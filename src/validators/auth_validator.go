@@ -0,0 +1,133 @@
+package validators
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/mail"
+	"user-management-service/src/models"
+)
+
+type IAuthValidator interface {
+	ValidateLoginModel(model models.LoginModel) *models.ErrorModel
+	ValidateRefreshTokenModel(model models.RefreshTokenModel) *models.ErrorModel
+	ValidateLogoutModel(model models.LogoutModel) *models.ErrorModel
+	ValidateForgotPasswordModel(model models.ForgotPasswordModel) *models.ErrorModel
+	ValidateResetPasswordModel(model models.ResetPasswordModel) *models.ErrorModel
+	ValidateMagicLinkModel(model models.MagicLinkModel) *models.ErrorModel
+	ValidateMagicLinkLoginModel(model models.MagicLinkLoginModel) *models.ErrorModel
+}
+
+type AuthValidator struct {
+	logger *logrus.Logger
+}
+
+func NewAuthValidator(logger *logrus.Logger) *AuthValidator {
+	return &AuthValidator{logger: logger}
+}
+
+func (v *AuthValidator) ValidateLoginModel(model models.LoginModel) *models.ErrorModel {
+	if model.Email == "" || model.Password == "" {
+		v.logger.
+			WithField("Email", model.Email).
+			WithField("Service", "AuthValidator").
+			WithField("Method", "ValidateLoginModel").
+			Warn("Email or Password empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *AuthValidator) ValidateRefreshTokenModel(model models.RefreshTokenModel) *models.ErrorModel {
+	if model.RefreshToken == "" {
+		v.logger.
+			WithField("Service", "AuthValidator").
+			WithField("Method", "ValidateRefreshTokenModel").
+			Warn("RefreshToken empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *AuthValidator) ValidateLogoutModel(model models.LogoutModel) *models.ErrorModel {
+	if model.RefreshToken == "" {
+		v.logger.
+			WithField("Service", "AuthValidator").
+			WithField("Method", "ValidateLogoutModel").
+			Warn("RefreshToken empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *AuthValidator) ValidateForgotPasswordModel(model models.ForgotPasswordModel) *models.ErrorModel {
+	_, err := mail.ParseAddress(model.Email)
+
+	if err != nil {
+		v.logger.
+			WithField("Service", "AuthValidator").
+			WithField("Operation", "ParseAddress").
+			WithField("Method", "ValidateForgotPasswordModel").
+			WithField("Error", err.Error()).
+			Warn("Email empty or invalid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *AuthValidator) ValidateResetPasswordModel(model models.ResetPasswordModel) *models.ErrorModel {
+	if model.Token == "" || model.Password == "" {
+		v.logger.
+			WithField("Service", "AuthValidator").
+			WithField("Method", "ValidateResetPasswordModel").
+			Warn("Token or Password empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *AuthValidator) ValidateMagicLinkModel(model models.MagicLinkModel) *models.ErrorModel {
+	_, err := mail.ParseAddress(model.Email)
+
+	if err != nil {
+		v.logger.
+			WithField("Service", "AuthValidator").
+			WithField("Operation", "ParseAddress").
+			WithField("Method", "ValidateMagicLinkModel").
+			WithField("Error", err.Error()).
+			Warn("Email empty or invalid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *AuthValidator) ValidateMagicLinkLoginModel(model models.MagicLinkLoginModel) *models.ErrorModel {
+	if model.Token == "" {
+		v.logger.
+			WithField("Service", "AuthValidator").
+			WithField("Method", "ValidateMagicLinkLoginModel").
+			Warn("Token empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
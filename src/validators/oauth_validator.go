@@ -0,0 +1,128 @@
+package validators
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"user-management-service/src/models"
+)
+
+type IOAuthValidator interface {
+	ValidateCreateOAuthClientModel(model models.CreateOAuthClientModel) *models.ErrorModel
+	ValidateOAuthClientModel(model models.OAuthClientModel) *models.ErrorModel
+	ValidateAuthorizeModel(model models.AuthorizeModel) *models.ErrorModel
+	ValidateOAuthTokenModel(model models.OAuthTokenModel) *models.ErrorModel
+}
+
+// OAuthValidator validates client registrations with the repo's messages,
+// and requests to the protocol endpoints with OAuth error codes.
+type OAuthValidator struct {
+	logger *logrus.Logger
+}
+
+func NewOAuthValidator(logger *logrus.Logger) *OAuthValidator {
+	return &OAuthValidator{logger: logger}
+}
+
+func (v *OAuthValidator) ValidateCreateOAuthClientModel(model models.CreateOAuthClientModel) *models.ErrorModel {
+	if strings.TrimSpace(model.Name) == "" || len(model.RedirectUris) == 0 {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "OAuthValidator").
+			WithField("Method", "ValidateCreateOAuthClientModel").
+			Warn("Name or RedirectUris empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+
+	for _, redirectUri := range model.RedirectUris {
+		if !validRedirectUri(redirectUri) {
+			v.logger.
+				WithField("RedirectUri", redirectUri).
+				WithField("Service", "OAuthValidator").
+				WithField("Method", "ValidateCreateOAuthClientModel").
+				Warn("RedirectUri is not valid")
+			return &models.ErrorModel{
+				StatusCode: http.StatusBadRequest,
+				Error:      models.InvalidRedirectUrisMessage,
+			}
+		}
+	}
+	return nil
+}
+
+func (v *OAuthValidator) ValidateOAuthClientModel(model models.OAuthClientModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "OAuthValidator").
+			WithField("Method", "ValidateOAuthClientModel").
+			Warn("Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *OAuthValidator) ValidateAuthorizeModel(model models.AuthorizeModel) *models.ErrorModel {
+	if model.ClientId == "" || model.UserId == "" {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "OAuthValidator").
+			WithField("Method", "ValidateAuthorizeModel").
+			Warn("ClientId or UserId empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.OAuthInvalidRequestError,
+		}
+	}
+	return nil
+}
+
+func (v *OAuthValidator) ValidateOAuthTokenModel(model models.OAuthTokenModel) *models.ErrorModel {
+	if model.GrantType == "" || model.ClientId == "" {
+		v.logger.
+			WithField("GrantType", model.GrantType).
+			WithField("ClientId", model.ClientId).
+			WithField("Service", "OAuthValidator").
+			WithField("Method", "ValidateOAuthTokenModel").
+			Warn("GrantType or ClientId empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.OAuthInvalidRequestError,
+		}
+	}
+	return nil
+}
+
+// validRedirectUri accepts absolute https URIs, and http URIs on a loopback
+// host for local development. Fragments are never allowed.
+func validRedirectUri(redirectUri string) bool {
+	parsed, err := url.Parse(redirectUri)
+
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" ||
+		strings.Contains(redirectUri, "#") {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+
+	return false
+}
@@ -0,0 +1,171 @@
+package validators
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"user-management-service/src/configuration"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordMaxLength = 128
+	minPersonalInfoLength    = 3
+)
+
+type IPasswordPolicy interface {
+	Check(password string, name string, email string) []string
+}
+
+// PasswordPolicy checks new passwords against the configured rules. Check
+// reports every rule that failed so clients can show them all at once.
+type PasswordPolicy struct {
+	minLength            int
+	maxLength            int
+	requireUppercase     bool
+	requireLowercase     bool
+	requireDigit         bool
+	requireSymbol        bool
+	disallowPersonalInfo bool
+	commonPasswords      map[string]struct{}
+}
+
+// NewPasswordPolicy fails when a common password file is configured but
+// cannot be read, so a typo does not silently disable the check.
+func NewPasswordPolicy(config configuration.PasswordPolicyConfigurations) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		minLength:            config.MinLength,
+		maxLength:            config.MaxLength,
+		requireUppercase:     config.RequireUppercase,
+		requireLowercase:     config.RequireLowercase,
+		requireDigit:         config.RequireDigit,
+		requireSymbol:        config.RequireSymbol,
+		disallowPersonalInfo: config.DisallowPersonalInfo,
+		commonPasswords:      map[string]struct{}{},
+	}
+
+	if policy.minLength <= 0 {
+		policy.minLength = defaultPasswordMinLength
+	}
+	if policy.maxLength < policy.minLength {
+		policy.maxLength = defaultPasswordMaxLength
+	}
+
+	if config.CommonPasswordFile != "" {
+		commonPasswords, err := loadCommonPasswords(config.CommonPasswordFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		policy.commonPasswords = commonPasswords
+	}
+
+	return policy, nil
+}
+
+func (p *PasswordPolicy) Check(password string, name string, email string) []string {
+	var failures []string
+
+	length := utf8.RuneCountInString(password)
+
+	if length < p.minLength {
+		failures = append(failures, fmt.Sprintf("must be at least %d characters", p.minLength))
+	}
+	if length > p.maxLength {
+		failures = append(failures, fmt.Sprintf("must be at most %d characters", p.maxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.requireUppercase && !hasUpper {
+		failures = append(failures, "must contain an uppercase letter")
+	}
+	if p.requireLowercase && !hasLower {
+		failures = append(failures, "must contain a lowercase letter")
+	}
+	if p.requireDigit && !hasDigit {
+		failures = append(failures, "must contain a digit")
+	}
+	if p.requireSymbol && !hasSymbol {
+		failures = append(failures, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+
+	if p.disallowPersonalInfo && containsPersonalInfo(lowered, name, email) {
+		failures = append(failures, "must not contain the name or email")
+	}
+
+	if _, common := p.commonPasswords[lowered]; common {
+		failures = append(failures, "is too common or has appeared in a data breach")
+	}
+
+	return failures
+}
+
+// containsPersonalInfo looks for the name, its parts and the email's local
+// part. Fragments shorter than minPersonalInfoLength are ignored.
+func containsPersonalInfo(password string, name string, email string) bool {
+	fragments := strings.Fields(strings.ToLower(name))
+	fragments = append(fragments, strings.ToLower(strings.TrimSpace(name)))
+
+	if email != "" {
+		email = strings.ToLower(email)
+		fragments = append(fragments, email, strings.SplitN(email, "@", 2)[0])
+	}
+
+	for _, fragment := range fragments {
+		if utf8.RuneCountInString(fragment) >= minPersonalInfoLength && strings.Contains(password, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadCommonPasswords reads one password per line. Blank lines and lines
+// starting with # are skipped; entries are compared case-insensitively.
+func loadCommonPasswords(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	commonPasswords := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		commonPasswords[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return commonPasswords, nil
+}
@@ -5,28 +5,56 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 	"net/mail"
+	"strings"
 	"user-management-service/src/models"
 )
 
 type IUserValidator interface {
 	ValidateAddUserModel(model models.AddUserModel) *models.ErrorModel
 	ValidateUpdateUserModel(model models.UpdateUserModel) *models.ErrorModel
+	ValidatePatchUserModel(model models.PatchUserModel) *models.ErrorModel
+	ValidateUserName(name string) *models.ErrorModel
 	ValidateDeleteUserModel(model models.DeleteUserModel) *models.ErrorModel
+	ValidateRestoreUserModel(model models.RestoreUserModel) *models.ErrorModel
+	ValidateListDeletedUsersModel(model models.ListDeletedUsersModel) *models.ErrorModel
 	ValidateGetUserModel(model models.GetUserModel) *models.ErrorModel
+	ValidateUserRoleModel(model models.UserRoleModel) *models.ErrorModel
+	ValidateVerifyEmailModel(model models.VerifyEmailModel) *models.ErrorModel
+	ValidateResendVerificationModel(model models.ResendVerificationModel) *models.ErrorModel
+	ValidateEmailChangeModel(model models.EmailChangeModel) *models.ErrorModel
+	ValidateTwoFactorModel(model models.TwoFactorModel) *models.ErrorModel
+	ValidateTwoFactorCodeModel(model models.TwoFactorCodeModel) *models.ErrorModel
+	ValidateUnlockUserModel(model models.UnlockUserModel) *models.ErrorModel
+	ValidatePassword(password string, name string, email string) *models.ErrorModel
+	ValidateChangePasswordModel(model models.ChangePasswordModel) *models.ErrorModel
+	ValidateExpirePasswordModel(model models.ExpirePasswordModel) *models.ErrorModel
+	ValidateCreateApiKeyModel(model models.CreateApiKeyModel) *models.ErrorModel
+	ValidateListApiKeysModel(model models.ListApiKeysModel) *models.ErrorModel
+	ValidateRevokeApiKeyModel(model models.RevokeApiKeyModel) *models.ErrorModel
+	ValidateListSessionsModel(model models.ListSessionsModel) *models.ErrorModel
+	ValidateRevokeSessionModel(model models.RevokeSessionModel) *models.ErrorModel
+	ValidateRevokeSessionsModel(model models.RevokeSessionsModel) *models.ErrorModel
+	ValidateImpersonateModel(model models.ImpersonateModel) *models.ErrorModel
+	ValidateScimUserModel(model models.ScimUserModel) *models.ErrorModel
+	ValidateListUsersModel(model models.ListUsersModel) *models.ErrorModel
+	ValidateSearchUsersModel(model models.SearchUsersModel) *models.ErrorModel
 }
 
+const maxSearchQueryLength = 200
+
 type UserValidator struct {
-	logger *logrus.Logger
+	passwordPolicy IPasswordPolicy
+	logger         *logrus.Logger
 }
 
-func NewUserValidator(logger *logrus.Logger) *UserValidator {
-	return &UserValidator{logger: logger}
+func NewUserValidator(passwordPolicy IPasswordPolicy, logger *logrus.Logger) *UserValidator {
+	return &UserValidator{passwordPolicy: passwordPolicy, logger: logger}
 }
 
 func (v *UserValidator) ValidateAddUserModel(model models.AddUserModel) *models.ErrorModel {
 	if model.Name == "" || model.Password == "" || model.Email == "" {
 		v.logger.
-			WithField("RequestModel", model).
+			WithField("Email", model.Email).
 			WithField("Service", "UserValidator").
 			WithField("Method", "ValidateAddUserModel").
 			Warn("Name or Password or Email empty")
@@ -39,7 +67,7 @@ func (v *UserValidator) ValidateAddUserModel(model models.AddUserModel) *models.
 
 		if err != nil {
 			v.logger.
-				WithField("RequestModel", model).
+				WithField("Email", model.Email).
 				WithField("Service", "UserValidator").
 				WithField("Operation", "ParseAddress").
 				WithField("Method", "ValidateAddUserModel").
@@ -51,13 +79,13 @@ func (v *UserValidator) ValidateAddUserModel(model models.AddUserModel) *models.
 			}
 		}
 	}
-	return nil
+	return v.ValidatePassword(model.Password, model.Name, model.Email)
 }
 
 func (v *UserValidator) ValidateUpdateUserModel(model models.UpdateUserModel) *models.ErrorModel {
 	if model.Name == "" || model.Password == "" || model.Id == "" {
 		v.logger.
-			WithField("RequestModel", model).
+			WithField("UserId", model.Id).
 			WithField("Service", "UserValidator").
 			WithField("Method", "ValidateUpdateUserModel").
 			Warn("Name or Password or Id empty")
@@ -66,6 +94,37 @@ func (v *UserValidator) ValidateUpdateUserModel(model models.UpdateUserModel) *m
 			Error:      models.BadRequestErrorMessage,
 		}
 	}
+	return v.ValidatePassword(model.Password, model.Name, "")
+}
+
+func (v *UserValidator) ValidatePatchUserModel(model models.PatchUserModel) *models.ErrorModel {
+	id, err := primitive.ObjectIDFromHex(model.Id)
+
+	if err != nil || id.IsZero() || (model.MergePatch == nil) == (model.Operations == nil) {
+		v.logger.
+			WithField("UserId", model.Id).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidatePatchUserModel").
+			Warn("Id is not valid or the patch is missing")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateUserName(name string) *models.ErrorModel {
+	if strings.TrimSpace(name) == "" {
+		v.logger.
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateUserName").
+			Warn("Name empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
 	return nil
 }
 
@@ -84,6 +143,36 @@ func (v *UserValidator) ValidateDeleteUserModel(model models.DeleteUserModel) *m
 	return nil
 }
 
+func (v *UserValidator) ValidateRestoreUserModel(model models.RestoreUserModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateRestoreUserModel").
+			Warn("Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateListDeletedUsersModel(model models.ListDeletedUsersModel) *models.ErrorModel {
+	if model.Page < 0 || model.Limit < 0 {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateListDeletedUsersModel").
+			Warn("Page or Limit is not valid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
 func (v *UserValidator) ValidateGetUserModel(model models.GetUserModel) *models.ErrorModel {
 	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() {
 		v.logger.
@@ -98,3 +187,360 @@ func (v *UserValidator) ValidateGetUserModel(model models.GetUserModel) *models.
 	}
 	return nil
 }
+
+func (v *UserValidator) ValidateUserRoleModel(model models.UserRoleModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() ||
+		(model.Role != models.AdminRole && model.Role != models.UserRole) {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateUserRoleModel").
+			Warn("Id is not valid or empty or Role is unknown")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateVerifyEmailModel(model models.VerifyEmailModel) *models.ErrorModel {
+	if model.Token == "" {
+		v.logger.
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateVerifyEmailModel").
+			Warn("Token empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateResendVerificationModel(model models.ResendVerificationModel) *models.ErrorModel {
+	_, err := mail.ParseAddress(model.Email)
+
+	if err != nil {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Operation", "ParseAddress").
+			WithField("Method", "ValidateResendVerificationModel").
+			WithField("Error", err.Error()).
+			Warn("Email empty or invalid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateEmailChangeModel(model models.EmailChangeModel) *models.ErrorModel {
+	id, err := primitive.ObjectIDFromHex(model.Id)
+
+	if err == nil && !id.IsZero() {
+		_, err = mail.ParseAddress(model.Email)
+	}
+
+	if err != nil || id.IsZero() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateEmailChangeModel").
+			Warn("Id or Email is not valid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateTwoFactorModel(model models.TwoFactorModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateTwoFactorModel").
+			Warn("Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateTwoFactorCodeModel(model models.TwoFactorCodeModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() || model.Code == "" {
+		v.logger.
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateTwoFactorCodeModel").
+			Warn("Id is not valid or empty or Code empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateUnlockUserModel(model models.UnlockUserModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateUnlockUserModel").
+			Warn("Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateChangePasswordModel(model models.ChangePasswordModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() || model.CurrentPassword == "" ||
+		model.NewPassword == "" {
+		v.logger.
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateChangePasswordModel").
+			Warn("Id is not valid or empty or CurrentPassword or NewPassword empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateExpirePasswordModel(model models.ExpirePasswordModel) *models.ErrorModel {
+	if model.Id == "" || model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateExpirePasswordModel").
+			Warn("Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateCreateApiKeyModel(model models.CreateApiKeyModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() || strings.TrimSpace(model.Name) == "" ||
+		len(model.Scopes) == 0 {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateCreateApiKeyModel").
+			Warn("UserId is not valid or empty or Name or Scopes empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateListApiKeysModel(model models.ListApiKeysModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateListApiKeysModel").
+			Warn("UserId is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateRevokeApiKeyModel(model models.RevokeApiKeyModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() || model.Id == "" ||
+		model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateRevokeApiKeyModel").
+			Warn("UserId or Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateListSessionsModel(model models.ListSessionsModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateListSessionsModel").
+			Warn("UserId is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateRevokeSessionModel(model models.RevokeSessionModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() || model.Id == "" ||
+		model.Id == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateRevokeSessionModel").
+			Warn("UserId or Id is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateRevokeSessionsModel(model models.RevokeSessionsModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateRevokeSessionsModel").
+			Warn("UserId is not valid or empty")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateImpersonateModel(model models.ImpersonateModel) *models.ErrorModel {
+	if model.UserId == "" || model.UserId == primitive.NilObjectID.Hex() || model.ActorId == "" ||
+		model.UserId == model.ActorId {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateImpersonateModel").
+			Warn("UserId is not valid or empty or is the actor")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+// ValidateScimUserModel checks the attributes a provisioned user needs. The
+// password, when sent, is checked against the policy by the service since it
+// depends on the stored name and email.
+func (v *UserValidator) ValidateScimUserModel(model models.ScimUserModel) *models.ErrorModel {
+	hasUserSchema := false
+
+	for _, schema := range model.Schemas {
+		if schema == models.ScimUserSchema {
+			hasUserSchema = true
+		}
+	}
+
+	if !hasUserSchema || model.UserName == "" {
+		v.logger.
+			WithField("UserName", model.UserName).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateScimUserModel").
+			Warn("User schema or userName missing")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+
+	_, err := mail.ParseAddress(model.UserName)
+
+	if err != nil {
+		v.logger.
+			WithField("UserName", model.UserName).
+			WithField("Service", "UserValidator").
+			WithField("Operation", "ParseAddress").
+			WithField("Method", "ValidateScimUserModel").
+			WithField("Error", err.Error()).
+			Warn("userName is not an email")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateListUsersModel(model models.ListUsersModel) *models.ErrorModel {
+	sortField := strings.TrimPrefix(model.Sort, "-")
+
+	validSort := model.Sort == "" || sortField == models.NameSortField || sortField == models.EmailSortField ||
+		sortField == models.CreatedAtSortField
+	validStatus := model.Status == "" || model.Status == models.ActiveUserStatus ||
+		model.Status == models.DisabledUserStatus || model.Status == models.UnverifiedUserStatus
+	validPagination := model.Pagination == "" || model.Pagination == models.CursorPagination ||
+		model.Pagination == models.OffsetPagination && model.Cursor == ""
+	// Cursors carry their own position, so they cannot be combined with a page.
+	validCursor := model.Cursor == "" || model.Page == 0
+
+	if model.Page < 0 || model.Limit < 0 || !validSort || !validStatus || strings.Contains(model.EmailDomain, "@") ||
+		!validPagination || !validCursor {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateListUsersModel").
+			Warn("Page, Limit, Sort, Status, EmailDomain, Pagination or Cursor is not valid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+func (v *UserValidator) ValidateSearchUsersModel(model models.SearchUsersModel) *models.ErrorModel {
+	query := strings.TrimSpace(model.Query)
+
+	if query == "" || len(query) > maxSearchQueryLength || model.Page < 0 || model.Limit < 0 {
+		v.logger.
+			WithField("RequestModel", model).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidateSearchUsersModel").
+			Warn("Query, Page or Limit is not valid")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.BadRequestErrorMessage,
+		}
+	}
+	return nil
+}
+
+// ValidatePassword lists every failed password policy rule in the error.
+func (v *UserValidator) ValidatePassword(password string, name string, email string) *models.ErrorModel {
+	failures := v.passwordPolicy.Check(password, name, email)
+
+	if len(failures) > 0 {
+		v.logger.
+			WithField("Failures", failures).
+			WithField("Service", "UserValidator").
+			WithField("Method", "ValidatePassword").
+			Warn("Password does not meet the policy")
+		return &models.ErrorModel{
+			StatusCode: http.StatusBadRequest,
+			Error:      models.PasswordPolicyErrorMessage + ": " + strings.Join(failures, ", "),
+		}
+	}
+	return nil
+}